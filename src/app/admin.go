@@ -0,0 +1,244 @@
+package app
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type adminUser struct {
+	User
+	Habits int64
+}
+
+type adminStats struct {
+	Users     int64
+	Verified  int64
+	Disabled  int64
+	Habits    int64
+	Acks      int64
+	AcksWeek  int64
+	Scheduled int64
+}
+
+// Makes the verified accounts with the emails listed in APP_ADMINS admins, and nobody else.
+// Usernames are not matched, since anyone can take a name once its owner renames away.
+func loadAdmins() {
+	list, ok := os.LookupEnv("APP_ADMINS")
+	if !ok {
+		return
+	}
+
+	var emails []string
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		address, err := sanitizeEmail(entry)
+		if err != nil {
+			log.Printf("APP_ADMINS lists email addresses, %q is ignored.", entry)
+			continue
+		}
+		emails = append(emails, address)
+	}
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		revoke := tx.Model(&User{}).Where("admin = ?", true)
+		if len(emails) > 0 {
+			revoke = revoke.Where("email NOT IN ? OR verified = ?", emails, false)
+		}
+		err := revoke.Update("admin", false).Error
+		if err != nil || len(emails) == 0 {
+			return err
+		}
+
+		return tx.Model(&User{}).Where("email IN ? AND verified = ?", emails, true).Update("admin", true).Error
+	})
+	if err != nil {
+		log.Println("Could not update admin roles:", err)
+	}
+}
+
+func getAdminStats() (stats adminStats) {
+	db.Model(&User{}).Count(&stats.Users)
+	db.Model(&User{}).Where("verified = ?", true).Count(&stats.Verified)
+	db.Model(&User{}).Where("disabled = ?", true).Count(&stats.Disabled)
+	db.Model(&User{}).Where("deletion_scheduled IS NOT NULL").Count(&stats.Scheduled)
+	db.Model(&Habit{}).Count(&stats.Habits)
+	db.Model(&Ack{}).Count(&stats.Acks)
+	db.Model(&Ack{}).Where("created_at > ?", time.Now().Add(-durationWeek)).Count(&stats.AcksWeek)
+	return
+}
+
+func getAdminUsers() (users []adminUser, err error) {
+	var rows []User
+	err = db.Model(&User{}).Order("id").Find(&rows).Error
+	if err != nil {
+		return
+	}
+
+	var counts []struct {
+		UserID uint
+		Count  int64
+	}
+	err = db.Model(&Habit{}).Select("user_id, count(*) AS count").Group("user_id").Scan(&counts).Error
+	if err != nil {
+		return
+	}
+
+	habits := map[uint]int64{}
+	for _, c := range counts {
+		habits[c.UserID] = c.Count
+	}
+
+	for _, user := range rows {
+		users = append(users, adminUser{User: user, Habits: habits[user.ID]})
+	}
+	return
+}
+
+// Returns the user an admin action applies to; admins cannot act on their own account
+func getAdminUserHelper(w http.ResponseWriter, r *http.Request) (user User, err error) {
+	id := getID(r)
+	if id == 0 {
+		err = errors.New("no id")
+		httpError(w, r, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	admin, ok := getLoggedUser(r)
+	if !ok {
+		err = errors.New("no logged user")
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if admin.ID == id {
+		err = errors.New("own account")
+		httpError(w, r, "You cannot do this to your own account.", http.StatusBadRequest)
+		return
+	}
+
+	err = db.First(&user, id).Error
+	if err != nil {
+		httpError(w, r, "not found", http.StatusNotFound)
+	}
+	return
+}
+
+func getAdminHandler(w http.ResponseWriter, r *http.Request) {
+	users, err := getAdminUsers()
+	if err != nil {
+		httpError(w, r, "Could not get users.", http.StatusInternalServerError)
+		return
+	}
+
+	peppers, err := getPepperUsage()
+	if err != nil {
+		httpError(w, r, "Could not get pepper usage.", http.StatusInternalServerError)
+		return
+	}
+
+	failedEmails, err := getFailedEmails()
+	if err != nil {
+		httpError(w, r, "Could not get the email outbox.", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Users":             users,
+		"Peppers":           peppers,
+		"Outbox":            getOutboxStats(),
+		"FailedEmails":      failedEmails,
+		"Stats":             getAdminStats(),
+		"RegistrationMode":  getRegistrationMode(),
+		"RegistrationModes": registrationModes,
+	}
+
+	executeTemplate(w, r, http.StatusOK, "admin.tmpl", data)
+}
+
+func postAdminRegistrationHandler(w http.ResponseWriter, r *http.Request) {
+	mode := r.FormValue("mode")
+	if !isRegistrationMode(mode) {
+		httpError(w, r, "Unknown registration mode.", http.StatusBadRequest)
+		return
+	}
+
+	setRegistrationMode(mode)
+	log.Println("Registration mode:", mode)
+
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
+
+func postAdminDisableHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := getAdminUserHelper(w, r)
+	if err != nil {
+		return
+	}
+
+	user.Disabled = true
+	db.Save(&user)
+	auditAdmin(r, user.ID, auditAccountDisabled)
+	revokeSessions(r, user.ID, "")
+
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
+
+func postAdminEnableHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := getAdminUserHelper(w, r)
+	if err != nil {
+		return
+	}
+
+	user.Disabled = false
+	db.Save(&user)
+	auditAdmin(r, user.ID, auditAccountEnabled)
+
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
+
+// Clears the password and sends a reset link, so the user has to choose a new one
+func postAdminResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := getAdminUserHelper(w, r)
+	if err != nil {
+		return
+	}
+
+	user.PasswordHash = ""
+	user.Salt = ""
+	db.Save(&user)
+	revokeSessions(r, user.ID, "")
+
+	err = sendPasswordReset(user)
+	if err != nil {
+		httpError(w, r, "Could not generate reset token.", http.StatusInternalServerError)
+		return
+	}
+	auditAdmin(r, user.ID, auditPasswordResetSent)
+
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
+
+func postAdminDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	user, err := getAdminUserHelper(w, r)
+	if err != nil {
+		return
+	}
+
+	user.DeletionExport = false
+	err = purgeUser(user)
+	if err != nil {
+		httpError(w, r, "Could not delete account.", http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
@@ -0,0 +1,35 @@
+package app
+
+import "testing"
+
+func TestLoadAdmins(t *testing.T) {
+	listed := createTestUser(t, "adminlisted")
+	unverified := createTestUser(t, "adminunverified")
+	db.Model(&unverified).Updates(map[string]interface{}{"verified": false, "admin": true})
+	removed := createTestUser(t, "adminremoved")
+	db.Model(&removed).Update("admin", true)
+
+	// usernames are ignored, anyone could take one
+	impostor := createTestUser(t, "adminimpostor")
+
+	t.Setenv("APP_ADMINS", " AdminListed@example.com, adminunverified@example.com, adminimpostor, ")
+	loadAdmins()
+
+	want := map[uint]bool{listed.ID: true, unverified.ID: false, removed.ID: false, impostor.ID: false}
+	for id, admin := range want {
+		var user User
+		db.First(&user, id)
+		if user.Admin != admin {
+			t.Errorf("%s: admin %t, want %t", user.Username, user.Admin, admin)
+		}
+	}
+
+	t.Setenv("APP_ADMINS", "")
+	loadAdmins()
+
+	var count int64
+	db.Model(&User{}).Where("admin = ?", true).Count(&count)
+	if count != 0 {
+		t.Errorf("%d admins left with an empty APP_ADMINS", count)
+	}
+}
@@ -0,0 +1,206 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+)
+
+// AuditEvent is a security-relevant action on an account; entries are only ever added,
+// and removed when they exceed the retention period or the account is deleted
+type AuditEvent struct {
+	ID        uint `gorm:"primarykey"`
+	CreatedAt time.Time
+	UserID    uint `gorm:"index"`
+	Event     string
+	Details   string
+	IP        string
+	UserAgent string
+}
+
+const (
+	auditLogin                = "login"
+	auditLoginFailed          = "login_failed"
+	auditLockout              = "lockout"
+	auditPasswordResetSent    = "password_reset_sent"
+	auditPasswordReset        = "password_reset"
+	auditPasswordChanged      = "password_changed"
+	auditEmailChanged         = "email_changed"
+	auditEmailChangeRequested = "email_change_requested"
+	auditUsernameChanged      = "username_changed"
+	auditTwoFactorEnabled     = "2fa_enabled"
+	auditTwoFactorDisabled    = "2fa_disabled"
+	auditRecoveryCodes        = "recovery_codes"
+	auditPasskeyAdded         = "passkey_added"
+	auditPasskeyRemoved       = "passkey_removed"
+	auditIdentityLinked       = "sso_linked"
+	auditIdentityUnlinked     = "sso_unlinked"
+	auditSessionsRevoked      = "sessions_revoked"
+	auditWebhookUsed          = "webhook_used"
+	auditTelegramLinked       = "telegram_linked"
+	auditTelegramUnlinked     = "telegram_unlinked"
+	auditTelegramUsed         = "telegram_used"
+	auditAccountDisabled      = "account_disabled"
+	auditAccountEnabled       = "account_enabled"
+	auditDeletionScheduled    = "deletion_scheduled"
+	auditDeletionCancelled    = "deletion_cancelled"
+
+	auditRetentionInterval  = time.Hour
+	auditPageSize           = 100 // latest events shown
+	maxAuditUserAgentLength = 255
+)
+
+var (
+	auditRetention = 90 * 24 * time.Hour
+
+	auditDescriptions = map[string]string{
+		auditLogin:                "Logged in",
+		auditLoginFailed:          "Failed login",
+		auditLockout:              "Locked after too many failed logins",
+		auditPasswordResetSent:    "Password reset requested",
+		auditPasswordReset:        "Password reset",
+		auditPasswordChanged:      "Password changed",
+		auditEmailChanged:         "Email changed",
+		auditEmailChangeRequested: "Email change requested",
+		auditUsernameChanged:      "Username changed",
+		auditTwoFactorEnabled:     "Two-factor authentication enabled",
+		auditTwoFactorDisabled:    "Two-factor authentication disabled",
+		auditRecoveryCodes:        "Recovery codes regenerated",
+		auditPasskeyAdded:         "Passkey added",
+		auditPasskeyRemoved:       "Passkey removed",
+		auditIdentityLinked:       "Single sign-on linked",
+		auditIdentityUnlinked:     "Single sign-on unlinked",
+		auditSessionsRevoked:      "Sessions revoked",
+		auditWebhookUsed:          "Webhook used",
+		auditTelegramLinked:       "Telegram chat linked",
+		auditTelegramUnlinked:     "Telegram chat unlinked",
+		auditTelegramUsed:         "Habit acked from Telegram",
+		auditAccountDisabled:      "Account disabled",
+		auditAccountEnabled:       "Account enabled",
+		auditDeletionScheduled:    "Account deletion scheduled",
+		auditDeletionCancelled:    "Account deletion cancelled",
+	}
+)
+
+func (e AuditEvent) Description() string {
+	if d, ok := auditDescriptions[e.Event]; ok {
+		return d
+	}
+	return e.Event
+}
+
+func loadAuditConfig() {
+	s := os.Getenv("APP_AUDIT_RETENTION")
+	if s == "" {
+		return
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		log.Fatal("Invalid APP_AUDIT_RETENTION: ", err)
+	}
+	auditRetention = d
+}
+
+// Records an event for a user; r is nil for actions that do not come from a request
+func audit(r *http.Request, userID uint, event, details string) {
+	entry := AuditEvent{UserID: userID, Event: event, Details: details}
+	if r != nil {
+		entry.IP = clientIP(r)
+		entry.UserAgent = r.UserAgent()
+		if len(entry.UserAgent) > maxAuditUserAgentLength {
+			entry.UserAgent = entry.UserAgent[:maxAuditUserAgentLength]
+		}
+	}
+
+	err := db.Create(&entry).Error
+	if err != nil {
+		log.Printf("Could not record %s for user %d: %v", event, userID, err)
+	}
+}
+
+// Records an action taken by an admin on someone else's account
+func auditAdmin(r *http.Request, userID uint, event string) {
+	details := ""
+	if admin, ok := getLoggedUser(r); ok {
+		details = "by admin " + admin.Username
+	}
+	audit(r, userID, event, details)
+}
+
+func startAuditRetention() {
+	if auditRetention <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(auditRetentionInterval)
+	for {
+		err := removeOldAuditEvents()
+		if err != nil {
+			log.Println("Could not remove old audit events:", err)
+		}
+		<-ticker.C
+	}
+}
+
+func removeOldAuditEvents() error {
+	return db.Where("created_at < ?", time.Now().Add(-auditRetention)).Delete(&AuditEvent{}).Error
+}
+
+func getAuditEvents(userID uint) (events []AuditEvent, err error) {
+	err = db.Model(&AuditEvent{}).Where(&AuditEvent{UserID: userID}).Order("id DESC").Limit(auditPageSize).Find(&events).Error
+	return
+}
+
+func renderAudit(w http.ResponseWriter, r *http.Request, user User, back string) {
+	events, err := getAuditEvents(user.ID)
+	if err != nil {
+		httpError(w, r, "Could not get security log.", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"User":      user,
+		"Events":    events,
+		"Back":      back,
+		"Retention": int(auditRetention / durationDay),
+	}
+
+	executeTemplate(w, r, http.StatusOK, "audit.tmpl", data)
+}
+
+func getAuditHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "Could not find user in context.", http.StatusInternalServerError)
+		return
+	}
+
+	renderAudit(w, r, user, "/settings")
+}
+
+func getAdminAuditHandler(w http.ResponseWriter, r *http.Request) {
+	id := getID(r)
+	if id == 0 {
+		httpError(w, r, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	var user User
+	err := db.First(&user, id).Error
+	if err != nil {
+		httpError(w, r, "not found", http.StatusNotFound)
+		return
+	}
+
+	renderAudit(w, r, user, "/admin")
+}
+
+func describeLogin(method string, remember bool) string {
+	if remember {
+		return fmt.Sprintf("%s, remembered", method)
+	}
+	return method
+}
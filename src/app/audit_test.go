@@ -0,0 +1,137 @@
+package app
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/birabittoh/auth-boilerplate/src/telegram"
+)
+
+// Returns the user's events, oldest first
+func auditEvents(t *testing.T, userID uint) (events []AuditEvent) {
+	t.Helper()
+
+	err := db.Where(&AuditEvent{UserID: userID}).Order("id").Find(&events).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
+
+func TestAudit(t *testing.T) {
+	user := createTestUser(t, "auditrec")
+
+	r := httptest.NewRequest(http.MethodPost, "/login", nil)
+	r.RemoteAddr = "203.0.113.7:4321"
+	r.Header.Set("User-Agent", strings.Repeat("a", 300))
+	audit(r, user.ID, auditLoginFailed, "wrong password")
+	audit(nil, user.ID, auditDeletionScheduled, "")
+
+	events := auditEvents(t, user.ID)
+	if len(events) != 2 {
+		t.Fatalf("got %d events, want 2", len(events))
+	}
+	if e := events[0]; e.Event != auditLoginFailed || e.Details != "wrong password" || e.IP != "203.0.113.7" || len(e.UserAgent) != maxAuditUserAgentLength {
+		t.Errorf("from a request: %+v", e)
+	}
+	if e := events[1]; e.IP != "" || e.UserAgent != "" {
+		t.Errorf("without a request: %+v", e)
+	}
+	if events[0].Description() != "Failed login" || (AuditEvent{Event: "unknown"}).Description() != "unknown" {
+		t.Errorf("descriptions %q, %q", events[0].Description(), (AuditEvent{Event: "unknown"}).Description())
+	}
+}
+
+func TestAuditPages(t *testing.T) {
+	user := createTestUser(t, "auditpage")
+	other := createTestUser(t, "auditother")
+	admin := createTestUser(t, "auditadmin")
+	db.Model(&admin).Update("admin", true)
+	audit(nil, user.ID, auditPasswordChanged, "mine")
+	audit(nil, other.ID, auditPasswordChanged, "theirs")
+
+	c := newTestClient(t)
+	c.login(user)
+	body := readBody(t, c.get("/settings/security-log"))
+	if !strings.Contains(body, "Password changed") || !strings.Contains(body, "mine") || strings.Contains(body, "theirs") {
+		t.Errorf("security log: %s", body)
+	}
+
+	path := "/admin/users/" + strconv.FormatUint(uint64(other.ID), 10) + "/audit"
+	if res := c.get(path); res.StatusCode != http.StatusForbidden {
+		t.Errorf("user opening another log: status %d", res.StatusCode)
+	}
+
+	c.login(admin)
+	body = readBody(t, c.get(path))
+	if !strings.Contains(body, "theirs") || strings.Contains(body, "mine") {
+		t.Errorf("admin view: %s", body)
+	}
+}
+
+func TestAuditRetention(t *testing.T) {
+	user := createTestUser(t, "auditkeep")
+	old := AuditEvent{UserID: user.ID, Event: auditLogin, CreatedAt: time.Now().Add(-auditRetention - time.Hour)}
+	recent := AuditEvent{UserID: user.ID, Event: auditLogin, CreatedAt: time.Now().Add(-auditRetention + time.Hour)}
+	db.Create(&old)
+	db.Create(&recent)
+
+	err := removeOldAuditEvents()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	events := auditEvents(t, user.ID)
+	if len(events) != 1 || events[0].ID != recent.ID {
+		t.Errorf("kept %v, want only the recent event", events)
+	}
+}
+
+func TestAuditTokenUse(t *testing.T) {
+	user := createTestUser(t, "audittoken")
+	habit := Habit{UserID: user.ID, Name: "Walk", Days: 1}
+	db.Create(&habit)
+	db.Create(&Webhook{HabitID: habit.ID, Token: "audit-hook"})
+
+	c := newTestClient(t)
+	if res := c.post("/hook/audit-hook", url.Values{}); res.StatusCode != http.StatusOK {
+		t.Fatalf("webhook: status %d", res.StatusCode)
+	}
+	if res := c.post("/hook/audit-hook", url.Values{}); res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("webhook in cooldown: status %d", res.StatusCode)
+	}
+
+	f := newFakeTelegram(t)
+	const chatID = 710101
+	ks.Set("telegram:audit-code", user.ID, telegramLinkDuration)
+	handleTelegramUpdate(privateMessage(chatID, "/start audit-code"))
+	db.Model(&Ack{}).Where("habit_id = ?", habit.ID).Delete(&Ack{})
+	db.Model(&habit).Update("last_ack", nil)
+	handleTelegramUpdate(callback("audit", chatID, chatID, telegram.ChatPrivate, "ack:"+strconv.FormatUint(uint64(habit.ID), 10)))
+	if f.answers["audit"] != "Acked Walk." {
+		t.Fatalf("callback answered %q", f.answers["audit"])
+	}
+	handleTelegramUpdate(privateMessage(chatID, "/unlink"))
+
+	want := []struct{ event, details string }{
+		{auditWebhookUsed, "Walk"},
+		{auditWebhookUsed, "Walk, acked too recently"},
+		{auditTelegramLinked, ""},
+		{auditTelegramUsed, "Walk"},
+		{auditTelegramUnlinked, "from the chat"},
+	}
+	events := auditEvents(t, user.ID)
+	if len(events) != len(want) {
+		t.Fatalf("got %d events, want %d", len(events), len(want))
+	}
+	for i, w := range want {
+		if events[i].Event != w.event || events[i].Details != w.details {
+			t.Errorf("event %d: %s %q, want %s %q", i, events[i].Event, events[i].Details, w.event, w.details)
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package app
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/birabittoh/auth-boilerplate/src/notify"
+)
+
+var channelKinds = append([]string{notify.KindEmail}, notify.Kinds...)
+
+func getChannelNotifier(channel Channel, user User) (notify.Notifier, error) {
+	if channel.Kind == notify.KindEmail {
+		if m == nil {
+			return nil, errors.New("email client is not initialized")
+		}
+		if !user.Verified {
+			return nil, errors.New("email address is not verified")
+		}
+		return notify.Email{Mailer: outboxMailer{userID: user.ID}, To: user.Email}, nil
+	}
+
+	return notify.New(channel.Kind, channel.URL, channel.Token, channel.Target)
+}
+
+// Returns the push and Telegram bot notifiers followed by every enabled channel of a user
+func getUserNotifiers(user User) (notifiers []notify.Notifier) {
+	notifiers = append(notifiers, pushNotifier{userID: user.ID})
+	if tg != nil && user.TelegramChatID != nil {
+		notifiers = append(notifiers, telegramNotifier{chatID: *user.TelegramChatID})
+	}
+
+	var channels []Channel
+	err := db.Model(&Channel{}).Where("user_id = ? AND enabled = ?", user.ID, true).Find(&channels).Error
+	if err != nil {
+		log.Printf("Could not get channels for user %d: %v", user.ID, err)
+		return
+	}
+
+	for _, channel := range channels {
+		notifier, err := getChannelNotifier(channel, user)
+		if err != nil {
+			log.Printf("Skipping channel %d: %v", channel.ID, err)
+			continue
+		}
+		notifiers = append(notifiers, notifier)
+	}
+	return
+}
+
+// Fans a message out to every notifier of a user, returning the joined errors
+func notifyUser(userID uint, message notify.Message) error {
+	var user User
+	err := db.First(&user, userID).Error
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, notifier := range getUserNotifiers(user) {
+		errs = append(errs, notifier.Notify(message))
+	}
+	return errors.Join(errs...)
+}
+
+func isChannelKind(kind string) bool {
+	for _, k := range channelKinds {
+		if k == kind {
+			return true
+		}
+	}
+	return false
+}
+
+func getChannelHelper(w http.ResponseWriter, r *http.Request) (channel Channel, user User, err error) {
+	id := getID(r)
+	if id == 0 {
+		err = errors.New("no id")
+		httpError(w, r, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := getLoggedUser(r)
+	if !ok {
+		err = errors.New("no logged user")
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err = db.Model(&Channel{}).Where("id = ? AND user_id = ?", id, user.ID).First(&channel).Error
+	if err != nil {
+		httpError(w, r, "not found", http.StatusNotFound)
+	}
+	return
+}
+
+func postChannelsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	channel := Channel{
+		UserID:  user.ID,
+		Kind:    r.FormValue("kind"),
+		URL:     strings.TrimSpace(r.FormValue("url")),
+		Token:   strings.TrimSpace(r.FormValue("token")),
+		Target:  strings.TrimSpace(r.FormValue("target")),
+		Enabled: true,
+	}
+
+	if !isChannelKind(channel.Kind) {
+		httpError(w, r, "Unknown channel kind.", http.StatusBadRequest)
+		return
+	}
+
+	if channel.Kind != notify.KindEmail {
+		_, err := notify.New(channel.Kind, channel.URL, channel.Token, channel.Target)
+		if err != nil {
+			http.Error(w, localizer(r).T("Invalid channel: %s.", err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	db.Create(&channel)
+
+	http.Redirect(w, r, "/notifications", http.StatusFound)
+}
+
+func postChannelsIDHandler(w http.ResponseWriter, r *http.Request) {
+	channel, _, err := getChannelHelper(w, r)
+	if err != nil {
+		return
+	}
+
+	enabled := r.FormValue("enabled") == "on"
+	if enabled != channel.Enabled {
+		channel.Enabled = enabled
+		db.Save(&channel)
+	}
+
+	http.Redirect(w, r, "/notifications", http.StatusFound)
+}
+
+func postChannelTestHandler(w http.ResponseWriter, r *http.Request) {
+	channel, user, err := getChannelHelper(w, r)
+	if err != nil {
+		return
+	}
+
+	notifier, err := getChannelNotifier(channel, user)
+	if err == nil {
+		err = notifier.Notify(notify.Message{
+			Title: "WellBinge",
+			Body:  localizer(r).T("Notifications are working on this channel."),
+			URL:   baseUrl + "/notifications",
+		})
+	}
+	if err != nil {
+		log.Printf("Could not send test notification to channel %d: %v", channel.ID, err)
+		httpError(w, r, "Could not send test notification.", http.StatusBadGateway)
+		return
+	}
+
+	http.Redirect(w, r, "/notifications", http.StatusFound)
+}
+
+func postChannelDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	channel, _, err := getChannelHelper(w, r)
+	if err != nil {
+		return
+	}
+
+	db.Unscoped().Delete(&channel)
+
+	http.Redirect(w, r, "/notifications", http.StatusFound)
+}
@@ -0,0 +1,119 @@
+package app
+
+import (
+	"bytes"
+	"html/template"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const (
+	csrfCookie = "csrf_token"
+	csrfField  = "csrf_token"
+	csrfHeader = "X-CSRF-Token"
+)
+
+// Returns the value CSRF tokens are bound to: the session if there is one, the browser cookie otherwise
+func csrfBinding(r *http.Request) string {
+	cookie, err := r.Cookie("session_token")
+	if err == nil && cookie.Value != "" {
+		return "session:" + cookie.Value
+	}
+
+	cookie, err = r.Cookie(csrfCookie)
+	if err == nil && cookie.Value != "" {
+		return "browser:" + cookie.Value
+	}
+	return ""
+}
+
+// Middleware to reject state-changing requests without a valid CSRF token.
+// Forms send it in a hidden field, scripts in the X-CSRF-Token header.
+func csrfProtected(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, err := r.Cookie(csrfCookie)
+		if err != nil {
+			token, err := g.GenerateRandomToken(32)
+			if err != nil {
+				httpError(w, r, "Could not generate CSRF token.", http.StatusInternalServerError)
+				return
+			}
+
+			cookie := &http.Cookie{
+				Name:     csrfCookie,
+				Value:    token,
+				Path:     "/",
+				HttpOnly: true,
+				Secure:   true,
+				SameSite: http.SameSiteLaxMode,
+			}
+			http.SetCookie(w, cookie)
+			r.AddCookie(cookie)
+		}
+
+		// webhooks authenticate with their own token
+		if r.Method != http.MethodPost || strings.HasPrefix(r.URL.Path, "/hook/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token := r.Header.Get(csrfHeader)
+		if token == "" {
+			token = r.FormValue(csrfField)
+		}
+
+		if !g.CheckCSRFToken(csrfBinding(r), token) {
+			httpError(w, r, "Invalid CSRF token, please reload the page and try again.", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Returns the CSRF token to the service worker, which cannot read it from a page.
+// Other origins cannot read the response, as it has no CORS headers.
+func getCSRFHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, map[string]string{"token": g.CSRFToken(csrfBinding(r))})
+}
+
+// Renders a template with the given status, binding csrfField and csrfToken to the request.
+// Templates are cloned before they run, as executed ones cannot take new functions.
+func executeTemplate(w http.ResponseWriter, r *http.Request, status int, name string, data interface{}) {
+	tmpl := xt[localizer(r).Language()].Lookup(name)
+	if tmpl == nil {
+		log.Printf("Could not find template %s.", name)
+		httpError(w, r, "Could not render page.", http.StatusInternalServerError)
+		return
+	}
+
+	tmpl, err := tmpl.Clone()
+	if err != nil {
+		log.Printf("Could not clone %s: %v", name, err)
+		httpError(w, r, "Could not render page.", http.StatusInternalServerError)
+		return
+	}
+
+	token := g.CSRFToken(csrfBinding(r))
+	tmpl.Funcs(template.FuncMap{
+		"csrfField": func() template.HTML {
+			return template.HTML(`<input type="hidden" name="` + csrfField + `" value="` + template.HTMLEscapeString(token) + `" />`)
+		},
+		"csrfToken": func() string { return token },
+	})
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	if err != nil {
+		log.Printf("Could not render %s: %v", name, err)
+		httpError(w, r, "Could not render page.", http.StatusInternalServerError)
+		return
+	}
+
+	// pages hold the user's data, they must not outlive the session in any cache
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,122 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestCSRFRequired(t *testing.T) {
+	user := createTestUser(t, "csrfuser")
+	c := newTestClient(t)
+	c.login(user)
+
+	// forms without the field and scripts without the header are both refused
+	req := httptest.NewRequest(http.MethodPost, "/settings/language", strings.NewReader("language=it"))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(csrfHeader, "-")
+	if res := c.request(req); res.StatusCode != http.StatusForbidden {
+		t.Errorf("form without a token: status %d", res.StatusCode)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/sync", strings.NewReader(`{"acks":[]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(csrfHeader, "-")
+	if res := c.request(req); res.StatusCode != http.StatusForbidden {
+		t.Errorf("JSON without a token: status %d", res.StatusCode)
+	}
+
+	if res := c.postJSON("/sync", `{"acks":[]}`); res.StatusCode != http.StatusOK {
+		t.Errorf("JSON with the header: status %d", res.StatusCode)
+	}
+
+	// the token of another session does not work
+	other := newTestClient(t)
+	other.login(user)
+	otherReq := httptest.NewRequest(http.MethodGet, "/", nil)
+	otherReq.AddCookie(other.cookies["session_token"])
+	req = httptest.NewRequest(http.MethodPost, "/sync", strings.NewReader(`{"acks":[]}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(csrfHeader, g.CSRFToken(csrfBinding(otherReq)))
+	if res := c.request(req); res.StatusCode != http.StatusForbidden {
+		t.Errorf("token of another session: status %d", res.StatusCode)
+	}
+}
+
+func TestCSRFFieldAndToken(t *testing.T) {
+	user := createTestUser(t, "csrffield")
+	c := newTestClient(t)
+	c.login(user)
+
+	res := c.get("/settings")
+	body := readBody(t, res)
+	fields := regexp.MustCompile(`name="csrf_token" value="([^"]+)"`).FindAllStringSubmatch(body, -1)
+	if len(fields) == 0 || strings.Count(body, `method="post"`) != len(fields) {
+		t.Fatalf("%d CSRF fields for %d forms", len(fields), strings.Count(body, `method="post"`))
+	}
+
+	// the field, the meta tag and /csrf all carry the session's token
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(c.cookies["session_token"])
+	want := g.CSRFToken(csrfBinding(req))
+	if fields[0][1] != want || !strings.Contains(body, `<meta name="csrf-token" content="`+want+`">`) {
+		t.Errorf("page token %q, want %q", fields[0][1], want)
+	}
+
+	var token struct{ Token string }
+	json.NewDecoder(c.get("/csrf").Body).Decode(&token)
+	if token.Token != want {
+		t.Errorf("/csrf returned %q, want %q", token.Token, want)
+	}
+
+	req = httptest.NewRequest(http.MethodPost, "/settings/language", strings.NewReader(url.Values{"language": {"it"}, csrfField: {fields[0][1]}}.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set(csrfHeader, "")
+	if res := c.request(req); res.StatusCode != http.StatusFound {
+		t.Errorf("form with the field: status %d", res.StatusCode)
+	}
+}
+
+// Every form that posts must carry the field
+func TestTemplatesHaveCSRFField(t *testing.T) {
+	form := regexp.MustCompile(`(?i)<form\b[^>]*method="post"[^>]*>(\s*\{\{ csrfField \}\})?`)
+
+	files, _ := filepath.Glob("templates/*.tmpl")
+	for _, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, match := range form.FindAllSubmatch(content, -1) {
+			if len(match[1]) == 0 {
+				t.Errorf("%s: form without csrfField: %s", file, match[0])
+			}
+		}
+	}
+}
+
+func TestLogout(t *testing.T) {
+	user := createTestUser(t, "logoutuser")
+	c := newTestClient(t)
+	c.login(user)
+	token := c.cookies["session_token"].Value
+
+	// links and prefetches must not log out
+	c.get("/logout")
+	if c.userID() != user.ID {
+		t.Fatal("GET /logout ended the session")
+	}
+
+	if res := c.post("/logout", nil); res.StatusCode != http.StatusFound {
+		t.Errorf("POST /logout: status %d", res.StatusCode)
+	}
+	if _, err := ks.Get("session:" + token); err == nil {
+		t.Error("the session is still valid after logout")
+	}
+}
@@ -0,0 +1,217 @@
+package app
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Copy of a user's data, emailed before the account is purged
+type accountExport struct {
+	Username  string        `json:"username"`
+	Email     string        `json:"email"`
+	CreatedAt time.Time     `json:"created_at"`
+	Habits    []habitExport `json:"habits"`
+}
+
+type habitExport struct {
+	Name     string      `json:"name"`
+	Days     uint        `json:"days"`
+	Negative bool        `json:"negative"`
+	Disabled bool        `json:"disabled"`
+	Acks     []time.Time `json:"acks"`
+}
+
+const purgeInterval = time.Hour
+
+var deletionGracePeriod = 7 * 24 * time.Hour
+
+func loadDeletionConfig() {
+	s := os.Getenv("APP_DELETION_GRACE_PERIOD")
+	if s == "" {
+		return
+	}
+
+	d, err := time.ParseDuration(s)
+	if err != nil {
+		log.Fatal("Invalid APP_DELETION_GRACE_PERIOD: ", err)
+	}
+	deletionGracePeriod = d
+}
+
+func startAccountPurge() {
+	ticker := time.NewTicker(purgeInterval)
+	for {
+		purgeScheduledUsers()
+		<-ticker.C
+	}
+}
+
+func purgeScheduledUsers() {
+	var users []User
+	err := db.Model(&User{}).Where("deletion_scheduled <= ?", time.Now()).Find(&users).Error
+	if err != nil {
+		log.Println("Could not get users scheduled for deletion:", err)
+		return
+	}
+
+	for _, user := range users {
+		err = purgeUser(user)
+		if err != nil {
+			log.Printf("Could not delete user %d: %v", user.ID, err)
+		}
+	}
+}
+
+func exportUser(user User) (export accountExport, err error) {
+	var habits []Habit
+	err = db.Model(&Habit{}).Preload("Acks").Where(&Habit{UserID: user.ID}).Find(&habits).Error
+	if err != nil {
+		return
+	}
+
+	export = accountExport{
+		Username:  user.Username,
+		Email:     user.Email,
+		CreatedAt: user.CreatedAt,
+		Habits:    []habitExport{},
+	}
+
+	for _, habit := range habits {
+		h := habitExport{
+			Name:     habit.Name,
+			Days:     habit.Days,
+			Negative: habit.Negative,
+			Disabled: habit.Disabled,
+			Acks:     []time.Time{},
+		}
+		for _, ack := range habit.Acks {
+			h.Acks = append(h.Acks, ack.CreatedAt)
+		}
+		export.Habits = append(export.Habits, h)
+	}
+	return
+}
+
+func sendExportEmail(user User) error {
+	export, err := exportUser(user)
+	if err != nil {
+		return err
+	}
+
+	encoded, err := json.MarshalIndent(export, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	mail, err := renderEmail(userLocalizer(user), "account_deleted", map[string]interface{}{
+		"Username": user.Username,
+		"Export":   string(encoded),
+	})
+	if err != nil {
+		return err
+	}
+
+	// not owned by the account, so purgeUser does not delete it before it is sent
+	mail.To = []string{user.Email}
+	return sendEmail(0, mail, false)
+}
+
+// Hard deletes a user along with everything that belongs to them
+func purgeUser(user User) error {
+	if user.DeletionExport {
+		err := sendExportEmail(user)
+		if err != nil {
+			log.Printf("Could not send export email for %s.", user.Email)
+		}
+	}
+
+	// first, so the revocation event is removed with the rest of the audit log
+	revokeSessions(nil, user.ID, "")
+
+	err := db.Transaction(func(tx *gorm.DB) error {
+		habits := tx.Unscoped().Model(&Habit{}).Select("id").Where("user_id = ?", user.ID)
+		for _, model := range []interface{}{&Ack{}, &Webhook{}} {
+			err := tx.Unscoped().Where("habit_id IN (?)", habits).Delete(model).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, model := range []interface{}{&Habit{}, &PushSubscription{}, &Channel{}, &RecoveryCode{}, &Passkey{}, &Identity{}, &Invite{}, &AuditEvent{}, &OutboxEmail{}} {
+			err := tx.Unscoped().Where("user_id = ?", user.ID).Delete(model).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return tx.Unscoped().Delete(&user).Error
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Deleted user %d.", user.ID)
+	return nil
+}
+
+func postDeleteAccountHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !reauthenticate(w, r, user, r.FormValue("password")) {
+		return
+	}
+
+	user.DeletionExport = r.FormValue("export") == "on"
+	if deletionGracePeriod <= 0 {
+		err := purgeUser(user)
+		if err != nil {
+			httpError(w, r, "Could not delete account.", http.StatusInternalServerError)
+			return
+		}
+
+		http.SetCookie(w, g.GenerateEmptyCookie())
+		http.Redirect(w, r, "/", http.StatusFound)
+		return
+	}
+
+	scheduled := time.Now().Add(deletionGracePeriod)
+	user.DeletionScheduled = &scheduled
+	db.Save(&user)
+	audit(r, user.ID, auditDeletionScheduled, "for "+scheduled.Format("2006-01-02 15:04"))
+
+	err := sendTemplateEmail(user, "deletion_scheduled", map[string]interface{}{
+		"Username": user.Username,
+		"Date":     scheduled,
+	})
+	if err != nil {
+		log.Printf("Could not send deletion email for %s.", user.Email)
+	}
+
+	revokeSessions(r, user.ID, "")
+	http.SetCookie(w, g.GenerateEmptyCookie())
+	http.Redirect(w, r, "/", http.StatusFound)
+}
+
+func postCancelDeletionHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	user.DeletionScheduled = nil
+	user.DeletionExport = false
+	db.Save(&user)
+	audit(r, user.ID, auditDeletionCancelled, "")
+
+	http.Redirect(w, r, "/settings", http.StatusFound)
+}
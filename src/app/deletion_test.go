@@ -0,0 +1,177 @@
+package app
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setGracePeriod(t *testing.T, d time.Duration) {
+	previous := deletionGracePeriod
+	deletionGracePeriod = d
+	t.Cleanup(func() { deletionGracePeriod = previous })
+}
+
+// Creates a habit with an ack and a webhook, so purges have something to remove
+func createTestHabit(t *testing.T, user User, name string) Habit {
+	t.Helper()
+
+	habit := Habit{UserID: user.ID, Name: name, Days: 1}
+	err := db.Create(&habit).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Create(&Ack{HabitID: habit.ID, UserID: user.ID})
+	db.Create(&Webhook{HabitID: habit.ID, Token: name + "-hook"})
+	return habit
+}
+
+// Fails unless nothing of the user and the habit is left
+func assertPurged(t *testing.T, user User, habit Habit) {
+	t.Helper()
+
+	var count int64
+	db.Unscoped().Model(&User{}).Where("id = ?", user.ID).Count(&count)
+	if count != 0 {
+		t.Error("the user was not deleted")
+	}
+
+	for name, model := range map[string]interface{}{"acks": &Ack{}, "webhooks": &Webhook{}} {
+		db.Unscoped().Model(model).Where("habit_id = ?", habit.ID).Count(&count)
+		if count != 0 {
+			t.Errorf("%d %s left", count, name)
+		}
+	}
+	for name, model := range map[string]interface{}{"habits": &Habit{}, "audit events": &AuditEvent{}} {
+		db.Unscoped().Model(model).Where("user_id = ?", user.ID).Count(&count)
+		if count != 0 {
+			t.Errorf("%d %s left", count, name)
+		}
+	}
+}
+
+func TestDeleteAccountGracePeriod(t *testing.T) {
+	setGracePeriod(t, 48*time.Hour)
+	user := createTestUser(t, "delgrace")
+	habit := createTestHabit(t, user, "delgrace")
+
+	c := newTestClient(t)
+	c.login(user)
+	if res := c.post("/settings/delete", url.Values{"password": {"wrong"}}); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong password: status %d", res.StatusCode)
+	}
+
+	res := c.post("/settings/delete", url.Values{"password": {testPassword}, "export": {"on"}})
+	if res.StatusCode != http.StatusFound || c.userID() != 0 {
+		t.Fatalf("delete: status %d, still logged in as %d", res.StatusCode, c.userID())
+	}
+
+	var stored User
+	db.First(&stored, user.ID)
+	if stored.DeletionScheduled == nil || time.Until(*stored.DeletionScheduled) < 47*time.Hour || !stored.DeletionExport {
+		t.Fatalf("scheduled %v, export %t", stored.DeletionScheduled, stored.DeletionExport)
+	}
+	if emails := queuedEmails(t, user.Email); len(emails) != 1 {
+		t.Errorf("got %d emails, want the deletion notice", len(emails))
+	}
+
+	// within the grace period, nothing is purged and the user can cancel
+	purgeScheduledUsers()
+	var count int64
+	db.Model(&Habit{}).Where("id = ?", habit.ID).Count(&count)
+	if count != 1 {
+		t.Fatal("the habit was purged during the grace period")
+	}
+
+	c.login(user)
+	if res := c.post("/settings/delete/cancel", url.Values{}); res.StatusCode != http.StatusFound {
+		t.Fatalf("cancel: status %d", res.StatusCode)
+	}
+	var cancelled User
+	db.First(&cancelled, user.ID)
+	if cancelled.DeletionScheduled != nil || cancelled.DeletionExport {
+		t.Errorf("after cancelling: scheduled %v, export %t", cancelled.DeletionScheduled, cancelled.DeletionExport)
+	}
+
+	var events []string
+	db.Model(&AuditEvent{}).Where("user_id = ?", user.ID).Order("id").Pluck("event", &events)
+	if strings.Join(events, ",") != auditDeletionScheduled+","+auditSessionsRevoked+","+auditDeletionCancelled {
+		t.Errorf("audited %v", events)
+	}
+}
+
+func TestDeleteAccountImmediately(t *testing.T) {
+	setGracePeriod(t, 0)
+	user := createTestUser(t, "delnow")
+	habit := createTestHabit(t, user, "delnow")
+
+	c := newTestClient(t)
+	c.login(user)
+	res := c.post("/settings/delete", url.Values{"password": {testPassword}, "export": {"on"}})
+	if res.StatusCode != http.StatusFound || c.userID() != 0 {
+		t.Fatalf("delete: status %d, still logged in as %d", res.StatusCode, c.userID())
+	}
+	assertPurged(t, user, habit)
+
+	// the export outlives the account
+	emails := queuedEmails(t, user.Email)
+	if len(emails) != 1 || emails[0].UserID != 0 || !strings.Contains(emails[0].Body, `"name": "delnow"`) {
+		t.Errorf("export emails: %+v", emails)
+	}
+}
+
+func TestPurgeScheduledUsers(t *testing.T) {
+	due := createTestUser(t, "purgedue")
+	later := createTestUser(t, "purgelater")
+	dueHabit := createTestHabit(t, due, "purgedue")
+	laterHabit := createTestHabit(t, later, "purgelater")
+	audit(nil, due.ID, auditDeletionScheduled, "")
+
+	past, future := time.Now().Add(-time.Minute), time.Now().Add(time.Hour)
+	db.Model(&due).Update("deletion_scheduled", past)
+	db.Model(&later).Update("deletion_scheduled", future)
+
+	purgeScheduledUsers()
+	assertPurged(t, due, dueHabit)
+	if emails := queuedEmails(t, due.Email); len(emails) != 0 {
+		t.Errorf("sent %d emails without an export requested", len(emails))
+	}
+
+	var count int64
+	db.Model(&Ack{}).Where("habit_id = ?", laterHabit.ID).Count(&count)
+	if count != 1 {
+		t.Error("a user still in the grace period was purged")
+	}
+}
+
+func TestRemindersSkipInactiveAccounts(t *testing.T) {
+	active := createTestUser(t, "remactive")
+	disabled := createTestUser(t, "remoff")
+	leaving := createTestUser(t, "remleaving")
+	db.Model(&disabled).Update("disabled", true)
+	db.Model(&leaving).Update("deletion_scheduled", time.Now().Add(time.Hour))
+
+	want := map[uint]bool{}
+	for _, user := range []User{active, disabled, leaving} {
+		habit := Habit{UserID: user.ID, Name: "Overdue", Days: 1}
+		db.Create(&habit)
+		want[habit.ID] = user.ID == active.ID
+	}
+
+	habits, err := getOverdueHabits()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[uint]bool{}
+	for _, habit := range habits {
+		got[habit.ID] = true
+	}
+	for id, overdue := range want {
+		if got[id] != overdue {
+			t.Errorf("habit %d: overdue %t, want %t", id, got[id], overdue)
+		}
+	}
+}
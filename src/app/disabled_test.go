@@ -0,0 +1,67 @@
+package app
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDisabledUserLosesSessions(t *testing.T) {
+	user := createTestUser(t, "disabledsession")
+	c := newTestClient(t)
+	c.login(user)
+
+	if res := c.get("/habits"); res.StatusCode != http.StatusOK {
+		t.Fatalf("habits: status %d", res.StatusCode)
+	}
+
+	db.Model(&user).Update("disabled", true)
+
+	res := c.get("/habits")
+	if res.StatusCode != http.StatusFound || res.Header.Get("Location") != "/login" {
+		t.Errorf("habits after disabling: status %d, location %q", res.StatusCode, res.Header.Get("Location"))
+	}
+	if c.userID() != 0 {
+		t.Error("the session of a disabled user is still valid")
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r = r.WithContext(context.WithValue(r.Context(), userContextKey, user.ID))
+	if _, ok := getLoggedUser(r); ok {
+		t.Error("getLoggedUser returned a disabled user")
+	}
+}
+
+func TestDisabledUserWebhook(t *testing.T) {
+	user := createTestUser(t, "disabledhook")
+	habit := Habit{UserID: user.ID, Name: "Water", Days: 1}
+	db.Create(&habit)
+	db.Create(&Webhook{HabitID: habit.ID, Token: "disabled-hook-token"})
+
+	c := newTestClient(t)
+	if res := c.post("/hook/disabled-hook-token", nil); res.StatusCode >= 300 {
+		t.Fatalf("webhook: status %d", res.StatusCode)
+	}
+
+	db.Model(&user).Update("disabled", true)
+	db.Model(&habit).Update("last_ack", nil)
+	if res := c.post("/hook/disabled-hook-token", nil); res.StatusCode != http.StatusForbidden {
+		t.Errorf("webhook of a disabled user: status %d, want %d", res.StatusCode, http.StatusForbidden)
+	}
+}
+
+func TestDisabledUserTelegramChat(t *testing.T) {
+	user := createTestUser(t, "disabledtelegram")
+	chatID := int64(424242)
+	db.Model(&user).Update("telegram_chat_id", chatID)
+
+	if found, err := getUserByTelegramChat(chatID); err != nil || found.ID != user.ID {
+		t.Fatalf("getUserByTelegramChat = %d, %v", found.ID, err)
+	}
+
+	db.Model(&user).Update("disabled", true)
+	if _, err := getUserByTelegramChat(chatID); err == nil {
+		t.Error("the chat of a disabled user is still linked")
+	}
+}
@@ -0,0 +1,46 @@
+package app
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRenderEmail(t *testing.T) {
+	link := "https://example.com/reset-password-confirm?token=a&b=<c>"
+
+	tests := []struct {
+		language string
+		subject  string
+		text     string
+	}{
+		{"en", "Reset password", "Use the following link to reset your password:"},
+		{"it", "Reimposta la password", "Usa il link seguente per reimpostare la password:"},
+	}
+
+	for _, tt := range tests {
+		mail, err := renderEmail(tr.Localizer(tt.language), "reset", map[string]interface{}{"URL": link})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if mail.Subject != tt.subject {
+			t.Errorf("%s subject %q, want %q", tt.language, mail.Subject, tt.subject)
+		}
+
+		// the plain text keeps the link as is, the HTML escapes it
+		if !strings.HasPrefix(mail.Body, tt.text+"\n"+link+"\n") {
+			t.Errorf("%s text part %q", tt.language, mail.Body)
+		}
+		if !strings.Contains(mail.HTML, `href="https://example.com/reset-password-confirm?token=a&amp;b=%3cc%3e"`) ||
+			!strings.Contains(mail.HTML, tt.text) {
+			t.Errorf("%s HTML part %q", tt.language, mail.HTML)
+		}
+		if !strings.Contains(mail.HTML, "<html") || strings.Contains(mail.Body, "<p>") {
+			t.Errorf("%s parts are mixed up", tt.language)
+		}
+	}
+
+	if _, err := renderEmail(tr.Localizer("en"), "missing", map[string]interface{}{}); err == nil {
+		t.Error("renderEmail found a missing template")
+	}
+}
@@ -1,25 +1,31 @@
 package app
 
 import (
+	"bytes"
 	"context"
+	"crypto/rand"
 	"errors"
 	"fmt"
+	"html"
 	"log"
 	"net/http"
+	"net/mail"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/birabittoh/auth-boilerplate/src/email"
+	"github.com/birabittoh/auth-boilerplate/src/i18n"
 )
 
 type HabitDisplay struct {
 	ID       uint
 	Class    string
 	Name     string
-	LastAck  string
+	LastAck  *time.Time
 	Disabled bool
 }
 
@@ -30,9 +36,14 @@ const (
 	classGood = "good"
 	classWarn = "warn"
 	classBad  = "bad"
+
+	ackCooldown = 6 * time.Hour
 )
 
 var (
+	errAckCooldown  = errors.New("habit was acked too recently")
+	errAckDuplicate = errors.New("ack was already recorded")
+
 	validUsername  = regexp.MustCompile(`(?i)^[a-z0-9._-]+$`)
 	validEmail     = regexp.MustCompile(`^[a-z0-9._%+-]+@[a-z0-9.-]+\.[a-z]{2,}$`)
 	validHabitName = regexp.MustCompile(`(?i)^[a-z0-9._,\s)(-]+$`)
@@ -65,7 +76,8 @@ func checkHabitName(name string) bool {
 	return len(name) < 50 && validHabitName.MatchString(name)
 }
 
-func login(w http.ResponseWriter, userID uint, remember bool) {
+// Starts a session and records how the user got in
+func login(w http.ResponseWriter, r *http.Request, userID uint, remember bool, method string) {
 	var duration time.Duration
 	if remember {
 		duration = durationWeek
@@ -75,48 +87,239 @@ func login(w http.ResponseWriter, userID uint, remember bool) {
 
 	cookie, err := g.GenerateCookie(duration)
 	if err != nil {
-		http.Error(w, "Could not generate session cookie.", http.StatusInternalServerError)
+		httpError(w, r, "Could not generate session cookie.", http.StatusInternalServerError)
+		return
 	}
 
-	ks.Set("session:"+cookie.Value, userID, duration)
+	startSession(cookie.Value, userID, duration)
 	http.SetCookie(w, cookie)
+	audit(r, userID, auditLogin, describeLogin(method, remember))
 }
 
+// Picks the transport from APP_EMAIL_TRANSPORT: smtp (the default when APP_SMTP_HOST is set), file, maildir or stdout
 func loadEmailConfig() *email.Client {
+	kind := strings.ToLower(os.Getenv("APP_EMAIL_TRANSPORT"))
+	if kind == "" && os.Getenv("APP_SMTP_HOST") != "" {
+		kind = "smtp"
+	}
+
 	address := os.Getenv("APP_SMTP_EMAIL")
-	password := os.Getenv("APP_SMTP_PASSWORD")
-	host := os.Getenv("APP_SMTP_HOST")
-	port := os.Getenv("APP_SMTP_PORT")
+	if address == "" && kind != "smtp" {
+		address = "noreply@localhost" // development transports do not need a real sender
+	}
 
-	if address == "" || password == "" || host == "" {
-		log.Println("Missing email configuration.")
+	dir := os.Getenv("APP_EMAIL_DIR")
+
+	var transport email.Transport
+	switch kind {
+	case "smtp":
+		transport = loadSMTPConfig(address)
+	case "file":
+		if dir == "" {
+			dir = filepath.Join(dataDir, "mail")
+		}
+		transport = email.File{Dir: dir}
+	case "maildir":
+		if dir == "" {
+			dir = filepath.Join(dataDir, "maildir")
+		}
+		transport = email.Maildir{Dir: dir}
+	case "stdout":
+		transport = email.NewStdout()
+	case "":
+		log.Println("Email is disabled: set APP_SMTP_HOST or APP_EMAIL_TRANSPORT to send verification, reset and login emails.")
 		return nil
+	default:
+		log.Fatal("Unknown APP_EMAIL_TRANSPORT: ", kind)
+	}
+
+	log.Println("Email transport:", kind)
+	client := email.NewClient(loadSenderConfig(address), transport)
+
+	dkim := loadDKIMConfig(address)
+	if dkim != nil {
+		client.SignWith(dkim)
+	}
+	return client
+}
+
+// The From address comes from APP_SMTP_EMAIL; APP_EMAIL_ENVELOPE_FROM receives bounces
+func loadSenderConfig(address string) email.Sender {
+	sender := email.Sender{
+		Name:     os.Getenv("APP_EMAIL_FROM_NAME"),
+		Address:  address,
+		ReplyTo:  os.Getenv("APP_EMAIL_REPLY_TO"),
+		Envelope: os.Getenv("APP_EMAIL_ENVELOPE_FROM"),
+	}
+	if sender.Name == "" {
+		sender.Name = "WellBinge"
+	}
+
+	for key, value := range map[string]string{
+		"APP_SMTP_EMAIL":          sender.Address,
+		"APP_EMAIL_REPLY_TO":      sender.ReplyTo,
+		"APP_EMAIL_ENVELOPE_FROM": sender.Envelope,
+	} {
+		if value == "" {
+			continue
+		}
+		parsed, err := mail.ParseAddress(value)
+		if err != nil || parsed.Name != "" {
+			log.Fatal("Invalid "+key+": must be a plain email address, got ", value)
+		}
+	}
+	return sender
+}
+
+// DKIM signing is enabled by APP_DKIM_KEY and APP_DKIM_SELECTOR; the domain defaults to the one of the sender
+func loadDKIMConfig(address string) *email.DKIM {
+	keyPath := os.Getenv("APP_DKIM_KEY")
+	if keyPath == "" {
+		return nil
+	}
+
+	domain := os.Getenv("APP_DKIM_DOMAIN")
+	if domain == "" {
+		domain = email.Domain(address)
+	}
+
+	dkim, err := email.NewDKIM(domain, os.Getenv("APP_DKIM_SELECTOR"), keyPath)
+	if err != nil {
+		log.Fatal("Invalid DKIM configuration: ", err)
+	}
+
+	if domain != email.Domain(address) && !strings.HasSuffix(email.Domain(address), "."+domain) {
+		log.Printf("The DKIM domain %s does not align with the sender %s, so DMARC checks will fail.", domain, address)
+	}
+
+	log.Printf("Signing emails with DKIM selector %s._domainkey.%s", dkim.Selector, dkim.Domain)
+	return dkim
+}
+
+func loadSMTPConfig(address string) email.SMTP {
+	config := email.SMTP{
+		Host:     os.Getenv("APP_SMTP_HOST"),
+		Port:     os.Getenv("APP_SMTP_PORT"),
+		Username: os.Getenv("APP_SMTP_USERNAME"),
+		Password: os.Getenv("APP_SMTP_PASSWORD"),
+		Security: strings.ToLower(os.Getenv("APP_SMTP_SECURITY")),
+	}
+
+	if config.Host == "" || address == "" {
+		log.Fatal("The SMTP transport needs APP_SMTP_HOST and APP_SMTP_EMAIL.")
+	}
+
+	if config.Security == "" {
+		config.Security = email.SMTPStartTLS
+		if config.Port == "465" {
+			config.Security = email.SMTPTLS
+		}
 	}
 
-	if port == "" {
-		port = "587"
+	switch config.Security {
+	case email.SMTPStartTLS, email.SMTPPlain:
+		if config.Port == "" {
+			config.Port = "587"
+		}
+	case email.SMTPTLS:
+		if config.Port == "" {
+			config.Port = "465"
+		}
+	default:
+		log.Fatal("Invalid APP_SMTP_SECURITY: must be starttls, tls or none")
 	}
 
-	return email.NewClient(address, password, host, port)
+	if config.Username == "" {
+		config.Username = address
+	}
+	return config
 }
 
-func sendEmail(mail email.Email) error {
+// Returns the key used to sign tokens, generating it on first start
+func loadSigningKey() ([]byte, error) {
+	keyPath := filepath.Join(dataDir, secret)
+
+	key, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		key = make([]byte, 32)
+		_, err = rand.Read(key)
+		if err == nil {
+			err = os.WriteFile(keyPath, key, 0600)
+		}
+	}
+	return key, err
+}
+
+// Emails with links that log in or confirm something, redacted if they cannot be delivered
+var sensitiveEmails = map[string]bool{
+	"verify":        true,
+	"reset":         true,
+	"magic_link":    true,
+	"confirm_email": true,
+}
+
+// Queues an email in the outbox on behalf of a user, see startOutbox
+func sendEmail(userID uint, mail email.Email, sensitive bool) error {
 	if m == nil {
 		return errors.New("email client is not initialized")
 	}
-	return m.Send(mail)
+	return enqueueEmail(userID, mail, sensitive)
+}
+
+// Renders templates/email/<name>.tmpl, which defines the email-subject, email-text and email-html blocks
+func renderEmail(l *i18n.Localizer, name string, data map[string]interface{}) (mail email.Email, err error) {
+	tmpl := xt[l.Language()].Lookup("email/" + name + ".tmpl")
+	if tmpl == nil {
+		return mail, fmt.Errorf("no email template %s", name)
+	}
+
+	data["BaseURL"] = baseUrl
+	parts := map[string]*string{"email-subject": &mail.Subject, "email-text": &mail.Body}
+	for block, part := range parts {
+		var buf bytes.Buffer
+		err = tmpl.ExecuteTemplate(&buf, block, data)
+		if err != nil {
+			return
+		}
+		// html/template escapes everything, the plain text parts need the original characters back
+		*part = strings.TrimSpace(html.UnescapeString(buf.String()))
+	}
+
+	var buf bytes.Buffer
+	err = tmpl.Execute(&buf, data)
+	mail.HTML = strings.TrimSpace(buf.String())
+	return
+}
+
+// Sends an email to the user, in their language
+func sendTemplateEmail(user User, name string, data map[string]interface{}) error {
+	mail, err := renderEmail(userLocalizer(user), name, data)
+	if err != nil {
+		return err
+	}
+
+	mail.To = []string{user.Email}
+	return sendEmail(user.ID, mail, sensitiveEmails[name])
 }
 
-func sendResetEmail(address, token string) {
+func sendResetEmail(user User, token string) {
 	resetURL := fmt.Sprintf("%s/reset-password-confirm?token=%s", baseUrl, token)
-	err := sendEmail(email.Email{
-		To:      []string{address},
-		Subject: "Reset password",
-		Body:    fmt.Sprintf("Use the following link to reset your password:\n%s", resetURL),
-	})
+	err := sendTemplateEmail(user, "reset", map[string]interface{}{"URL": resetURL})
+	if err != nil {
+		log.Printf("Could not send reset email for %s: %v", user.Email, err)
+	}
+}
+
+// Creates a one-hour reset token for the user and emails the link
+func sendPasswordReset(user User) error {
+	resetToken, err := g.GenerateRandomToken(32)
 	if err != nil {
-		log.Printf("Could not send reset email for %s. Link: %s", address, resetURL)
+		return err
 	}
+
+	ks.Set("reset:"+resetToken, user.ID, time.Hour)
+	sendResetEmail(user, resetToken)
+	return nil
 }
 
 func readSessionCookie(r *http.Request) (userID *uint, err error) {
@@ -136,61 +339,76 @@ func loginRequired(next http.HandlerFunc) http.HandlerFunc {
 			return
 		}
 
+		// accounts disabled by an admin lose their open sessions too
+		var user User
+		err = db.Select("id", "disabled").First(&user, *userID).Error
+		if err != nil || user.Disabled {
+			cookie, _ := r.Cookie("session_token")
+			endSession(cookie.Value)
+			http.SetCookie(w, g.GenerateEmptyCookie())
+			http.Redirect(w, r, "/login", http.StatusFound)
+			return
+		}
+
 		ctx := context.WithValue(r.Context(), userContextKey, *userID)
 		next(w, r.WithContext(ctx))
 	}
 }
 
+// Middleware to throttle requests per client IP and, if field is set, per account
+func rateLimited(field string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		wait, ok := allowRequest("ip:" + clientIP(r))
+		if !ok {
+			tooManyRequests(w, r, wait)
+			return
+		}
+
+		if account := strings.ToLower(strings.TrimSpace(r.FormValue(field))); field != "" && account != "" {
+			wait, ok = allowRequest("account:" + account)
+			if !ok {
+				tooManyRequests(w, r, wait)
+				return
+			}
+		}
+
+		next(w, r)
+	}
+}
+
+// Middleware to check if the logged user is an admin
+func adminRequired(next http.HandlerFunc) http.HandlerFunc {
+	return loginRequired(func(w http.ResponseWriter, r *http.Request) {
+		user, ok := getLoggedUser(r)
+		if !ok || !user.Admin {
+			httpError(w, r, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		next(w, r)
+	})
+}
+
 func getLoggedUser(r *http.Request) (user User, ok bool) {
 	userID, ok := r.Context().Value(userContextKey).(uint)
 	if !ok {
 		return
 	}
 
-	if db.Find(&user, userID).Error != nil {
-		ok = true
-	}
-
+	err := db.First(&user, userID).Error
+	ok = err == nil && !user.Disabled
 	return user, ok
 }
 
-func formatDuration(t time.Time) (days int, s string) {
-	days = int(time.Since(t).Hours()) / 24
-
-	switch {
-	case days == 0:
-		s = "Today"
-	case days == 1:
-		s = "Yesterday"
-	case days <= 7:
-		s = fmt.Sprintf("%d day(s) ago", days)
-	case days <= 30:
-		weeks := days / 7
-		remainingDays := days % 7
-		if remainingDays == 0 {
-			s = fmt.Sprintf("%d week(s) ago", weeks)
-		} else {
-			s = fmt.Sprintf("%d week(s), %d day(s) ago", weeks, remainingDays)
-		}
-	default:
-		months := days / 30
-		remainingDays := days % 30
-		if remainingDays == 0 {
-			s = fmt.Sprintf("%d month(s) ago", months)
-		} else {
-			s = fmt.Sprintf("%d month(s), %d day(s) ago", months, remainingDays)
-		}
-	}
-	return
+// Whole days since t; how they read in each language is up to i18n.Localizer.Ago
+func daysSince(t time.Time) int {
+	return int(time.Since(t).Hours()) / 24
 }
 
 func toHabitDisplay(habit Habit) (d HabitDisplay) {
 	if habit.LastAck != nil {
-		var days int
-		days, d.LastAck = formatDuration(*habit.LastAck)
-		d.Class = getClassForAck(habit, days)
+		d.Class = getClassForAck(habit, daysSince(*habit.LastAck))
 	} else {
-		d.LastAck = "-"
 		if habit.Negative {
 			d.Class = classGood
 		} else if !habit.Disabled {
@@ -200,6 +418,7 @@ func toHabitDisplay(habit Habit) (d HabitDisplay) {
 
 	d.ID = habit.ID
 	d.Name = habit.Name
+	d.LastAck = habit.LastAck
 	d.Disabled = habit.Disabled
 	return
 }
@@ -239,26 +458,26 @@ func getHabitHelper(w http.ResponseWriter, r *http.Request) (habit Habit, err er
 	id := getID(r)
 	if id == 0 {
 		err = errors.New("no id")
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, r, "bad request", http.StatusBadRequest)
 		return
 	}
 
 	user, ok := getLoggedUser(r)
 	if !ok {
 		err = errors.New("no logged user")
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
 	habit, err = getHabit(id)
 	if err != nil {
-		http.Error(w, "not found", http.StatusNotFound)
+		httpError(w, r, "not found", http.StatusNotFound)
 		return
 	}
 
 	if habit.UserID != user.ID {
 		err = errors.New("forbidden")
-		http.Error(w, "forbidden", http.StatusForbidden)
+		httpError(w, r, "forbidden", http.StatusForbidden)
 	}
 	return
 }
@@ -282,9 +501,61 @@ func getAllHabits(userID uint) (positives []HabitDisplay, negatives []HabitDispl
 }
 
 func getID(r *http.Request) uint {
-	res, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	return getPathUint(r, "id")
+}
+
+func getPathUint(r *http.Request, name string) uint {
+	res, err := strconv.ParseUint(r.PathValue(name), 10, 64)
 	if err != nil {
 		return 0
 	}
 	return uint(res)
 }
+
+func ackHabit(habit *Habit) error {
+	return ackHabitAt(habit, time.Now(), "")
+}
+
+// Acks a habit at the given time, unless another ack is within ackCooldown of it.
+// A non-empty key makes the ack idempotent.
+func ackHabitAt(habit *Habit, at time.Time, key string) error {
+	var ack Ack
+	if key != "" {
+		ack.IdempotencyKey = &key
+		if db.Model(&Ack{}).Where("user_id = ? AND idempotency_key = ?", habit.UserID, key).First(&Ack{}).Error == nil {
+			return errAckDuplicate
+		}
+	}
+
+	if habit.LastAck != nil {
+		diff := at.Sub(*habit.LastAck)
+		if diff > -ackCooldown && diff < ackCooldown {
+			return errAckCooldown
+		}
+	}
+
+	var count int64
+	err := db.Model(&Ack{}).
+		Where("habit_id = ? AND created_at > ? AND created_at < ?", habit.ID, at.Add(-ackCooldown), at.Add(ackCooldown)).
+		Count(&count).Error
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return errAckCooldown
+	}
+
+	ack.HabitID = habit.ID
+	ack.UserID = habit.UserID
+	ack.CreatedAt = at
+	err = db.Create(&ack).Error
+	if err != nil {
+		return err
+	}
+
+	if habit.LastAck == nil || at.After(*habit.LastAck) {
+		habit.LastAck = &at
+		return db.Save(habit).Error
+	}
+	return nil
+}
@@ -1,35 +1,41 @@
 package app
 
 import (
+	"errors"
 	"net/http"
 	"strconv"
-	"time"
 )
 
 func getIndexHandler(w http.ResponseWriter, r *http.Request) {
-	xt.ExecuteTemplate(w, "index.tmpl", nil)
+	executeTemplate(w, r, http.StatusOK, "index.tmpl", nil)
+}
+
+// Served by the service worker in place of pages that cannot be loaded
+func getOfflineHandler(w http.ResponseWriter, r *http.Request) {
+	executeTemplate(w, r, http.StatusOK, "offline.tmpl", nil)
 }
 
 func getHabitsHandler(w http.ResponseWriter, r *http.Request) {
 	user, ok := getLoggedUser(r)
 	if !ok {
-		http.Error(w, "Could not find user in context.", http.StatusInternalServerError)
+		httpError(w, r, "Could not find user in context.", http.StatusInternalServerError)
 		return
 	}
 
 	positive, negative, err := getAllHabits(user.ID)
 	if err != nil {
-		http.Error(w, "Could not get user habits.", http.StatusInternalServerError)
+		httpError(w, r, "Could not get user habits.", http.StatusInternalServerError)
 		return
 	}
 
 	data := map[string]interface{}{
-		"User":     user,
-		"Positive": positive,
-		"Negative": negative,
+		"User":      user,
+		"Positive":  positive,
+		"Negative":  negative,
+		"CanInvite": canInvite(user),
 	}
 
-	xt.ExecuteTemplate(w, "habits.tmpl", data)
+	executeTemplate(w, r, http.StatusOK, "habits.tmpl", data)
 }
 
 func getHabitsIDHandler(w http.ResponseWriter, r *http.Request) {
@@ -38,17 +44,30 @@ func getHabitsIDHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	xt.ExecuteTemplate(w, "habits-id.tmpl", habit)
+	var webhooks []Webhook
+	err = db.Model(&Webhook{}).Where(&Webhook{HabitID: habit.ID}).Find(&webhooks).Error
+	if err != nil {
+		httpError(w, r, "Could not get habit webhooks.", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Habit":    habit,
+		"Webhooks": webhooks,
+		"BaseURL":  baseUrl,
+	}
+
+	executeTemplate(w, r, http.StatusOK, "habits-id.tmpl", data)
 }
 
 func getNewPositiveHandler(w http.ResponseWriter, r *http.Request) {
 	data := map[string]interface{}{"Negative": false}
-	xt.ExecuteTemplate(w, "new.tmpl", data)
+	executeTemplate(w, r, http.StatusOK, "new.tmpl", data)
 }
 
 func getNewNegativeHandler(w http.ResponseWriter, r *http.Request) {
 	data := map[string]interface{}{"Negative": true}
-	xt.ExecuteTemplate(w, "new.tmpl", data)
+	executeTemplate(w, r, http.StatusOK, "new.tmpl", data)
 }
 
 func postNewHandler(w http.ResponseWriter, r *http.Request) {
@@ -56,7 +75,7 @@ func postNewHandler(w http.ResponseWriter, r *http.Request) {
 	name := r.FormValue("name")
 
 	if !checkHabitName(name) {
-		http.Error(w, "Bad habit name.", http.StatusBadRequest)
+		httpError(w, r, "Bad habit name.", http.StatusBadRequest)
 		return
 	}
 
@@ -64,7 +83,7 @@ func postNewHandler(w http.ResponseWriter, r *http.Request) {
 	if !negative {
 		res, err := strconv.ParseUint(r.FormValue("days"), 10, 64)
 		if err != nil {
-			http.Error(w, "Bad days value.", http.StatusBadRequest)
+			httpError(w, r, "Bad days value.", http.StatusBadRequest)
 			return
 		}
 		days = uint(res)
@@ -72,7 +91,7 @@ func postNewHandler(w http.ResponseWriter, r *http.Request) {
 
 	user, ok := getLoggedUser(r)
 	if !ok {
-		http.Error(w, "Could not get logged user", http.StatusInternalServerError)
+		httpError(w, r, "Could not get logged user", http.StatusInternalServerError)
 		return
 	}
 
@@ -97,7 +116,7 @@ func postHabitsIDHandler(w http.ResponseWriter, r *http.Request) {
 	name := r.FormValue("name")
 	if name != habit.Name {
 		if !checkHabitName(name) {
-			http.Error(w, "Bad habit name.", http.StatusBadRequest)
+			httpError(w, r, "Bad habit name.", http.StatusBadRequest)
 			return
 		}
 		habit.Name = name
@@ -107,7 +126,7 @@ func postHabitsIDHandler(w http.ResponseWriter, r *http.Request) {
 	if !habit.Negative {
 		res, err := strconv.ParseUint(r.FormValue("days"), 10, 64)
 		if err != nil {
-			http.Error(w, "Bad days value.", http.StatusBadRequest)
+			httpError(w, r, "Bad days value.", http.StatusBadRequest)
 			return
 		}
 		days := uint(res)
@@ -133,13 +152,13 @@ func postHabitsIDHandler(w http.ResponseWriter, r *http.Request) {
 func postDeleteIDHandler(w http.ResponseWriter, r *http.Request) {
 	id := getID(r)
 	if id == 0 {
-		http.Error(w, "bad request", http.StatusBadRequest)
+		httpError(w, r, "bad request", http.StatusBadRequest)
 		return
 	}
 
 	user, ok := getLoggedUser(r)
 	if !ok {
-		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
 		return
 	}
 
@@ -154,30 +173,38 @@ func postAckIDHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	if habit.LastAck != nil {
-		if time.Since(*habit.LastAck) < 6*time.Hour {
-			http.Redirect(w, r, "/habits", http.StatusFound) // TODO: redirect to an error page instead
-			return
-		}
+	err = ackHabit(&habit)
+	if errors.Is(err, errAckCooldown) {
+		httpError(w, r, "Habit was acked too recently.", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Could not ack habit.", http.StatusInternalServerError)
+		return
 	}
-
-	db.Create(&Ack{HabitID: habit.ID})
-
-	now := time.Now()
-	habit.LastAck = &now
-	db.Save(&habit)
 
 	http.Redirect(w, r, "/habits", http.StatusFound)
 }
 
 func getRegisterHandler(w http.ResponseWriter, r *http.Request) {
-	xt.ExecuteTemplate(w, "auth-register.tmpl", nil)
+	data := map[string]interface{}{
+		"Mode":      getRegistrationMode(),
+		"Invite":    r.URL.Query().Get("invite"),
+		"MinLength": passwordPolicy.MinLength,
+	}
+
+	executeTemplate(w, r, http.StatusOK, "auth-register.tmpl", data)
 }
 
 func getLoginHandler(w http.ResponseWriter, r *http.Request) {
 	_, err := readSessionCookie(r)
 	if err != nil {
-		xt.ExecuteTemplate(w, "auth-login.tmpl", nil)
+		data := map[string]interface{}{"MagicLinks": magicLinks}
+		if o != nil {
+			data["OIDC"] = o.Name
+		}
+
+		executeTemplate(w, r, http.StatusOK, "auth-login.tmpl", data)
 		return
 	}
 
@@ -185,36 +212,52 @@ func getLoginHandler(w http.ResponseWriter, r *http.Request) {
 }
 
 func getResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
-	xt.ExecuteTemplate(w, "auth-reset_password.tmpl", nil)
+	executeTemplate(w, r, http.StatusOK, "auth-reset_password.tmpl", nil)
 }
 
 func postRegisterHandler(w http.ResponseWriter, r *http.Request) {
-	if !registrationEnabled {
-		http.Error(w, "Registration is currently disabled.", http.StatusForbidden)
+	mode := getRegistrationMode()
+	if mode == registrationClosed {
+		httpError(w, r, "Registration is currently disabled.", http.StatusForbidden)
 		return
 	}
 
 	username, err := sanitizeUsername(r.FormValue("username"))
 	if err != nil {
-		http.Error(w, "Invalid username.", http.StatusBadRequest)
+		httpError(w, r, "Invalid username.", http.StatusBadRequest)
 		return
 	}
 
 	email, err := sanitizeEmail(r.FormValue("email"))
 	if err != nil {
-		http.Error(w, "Invalid email.", http.StatusBadRequest)
+		httpError(w, r, "Invalid email.", http.StatusBadRequest)
 		return
 	}
 
 	_, err = getUserByName(username, 0)
 	if err == nil {
-		http.Error(w, "This username is already registered.", http.StatusConflict)
+		httpError(w, r, "This username is already registered.", http.StatusConflict)
+		return
+	}
+
+	problems := checkNewPassword(r, r.FormValue("password"), username, email)
+	if len(problems) > 0 {
+		data := map[string]interface{}{
+			"Mode":             mode,
+			"Invite":           r.FormValue("invite"),
+			"Username":         username,
+			"Email":            email,
+			"MinLength":        passwordPolicy.MinLength,
+			"PasswordProblems": problems,
+		}
+
+		executeTemplate(w, r, http.StatusBadRequest, "auth-register.tmpl", data)
 		return
 	}
 
-	hashedPassword, salt, err := g.HashPassword(r.FormValue("password"))
+	hashedPassword, err := g.HashPassword(r.FormValue("password"))
 	if err != nil {
-		http.Error(w, "Invalid password.", http.StatusBadRequest)
+		httpError(w, r, "Invalid password.", http.StatusBadRequest)
 		return
 	}
 
@@ -222,16 +265,35 @@ func postRegisterHandler(w http.ResponseWriter, r *http.Request) {
 		Username:     username,
 		Email:        email,
 		PasswordHash: hashedPassword,
-		Salt:         salt,
+		Language:     localizer(r).Language(), // for emails, until changed in the settings
+	}
+
+	var invite Invite
+	if mode == registrationInvite {
+		invite, err = useInvite(r.FormValue("invite"))
+		if err != nil {
+			httpError(w, r, "Invalid or expired invite code.", http.StatusForbidden)
+			return
+		}
+		user.InviteID = &invite.ID
 	}
 
 	db.Create(&user)
 	if user.ID == 0 {
-		http.Error(w, "This email is already registered.", http.StatusConflict)
+		if invite.ID != 0 {
+			releaseInvite(invite)
+		}
+		httpError(w, r, "This email is already registered.", http.StatusConflict)
+		return
+	}
+
+	sendVerificationEmail(user)
+	if requireVerification {
+		executeTemplate(w, r, http.StatusOK, "auth-verify_email.tmpl", map[string]interface{}{"Email": user.Email})
 		return
 	}
 
-	login(w, user.ID, false)
+	login(w, r, user.ID, false, "registration")
 	http.Redirect(w, r, "/login", http.StatusFound)
 }
 
@@ -241,17 +303,54 @@ func postLoginHandler(w http.ResponseWriter, r *http.Request) {
 	remember := r.FormValue("remember")
 
 	user, err := getUserByName(username, 0)
+	if err == nil && isLockedOut(user) {
+		httpError(w, r, "This account is temporarily locked, please try again later.", http.StatusTooManyRequests)
+		return
+	}
 
 	if err != nil || !g.CheckPassword(password, user.Salt, user.PasswordHash) {
-		http.Error(w, "Invalid credentials", http.StatusUnauthorized)
+		if err == nil {
+			recordLoginFailure(user, r, "wrong password")
+		}
+		httpError(w, r, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	if g.NeedsRehash(user.Salt, user.PasswordHash) {
+		hashedPassword, err := g.HashPassword(password)
+		if err == nil {
+			user.PasswordHash = hashedPassword
+			user.Salt = ""
+			db.Model(&user).Select("password_hash", "salt").Updates(&user)
+		}
+	}
+
+	if user.Disabled {
+		httpError(w, r, "This account has been disabled.", http.StatusForbidden)
+		return
+	}
+
+	if requireVerification && !user.Verified {
+		executeTemplate(w, r, http.StatusForbidden, "auth-verify_email.tmpl", map[string]interface{}{"Email": user.Email})
 		return
 	}
 
-	login(w, user.ID, remember == "on")
+	if hasTwoFactor(user) {
+		startTwoFactorLogin(w, r, user, remember == "on")
+		return
+	}
+
+	resetLoginFailures(user)
+	login(w, r, user.ID, remember == "on", "password")
 	http.Redirect(w, r, "/login", http.StatusFound)
 }
 
-func logoutHandler(w http.ResponseWriter, r *http.Request) {
+func postLogoutHandler(w http.ResponseWriter, r *http.Request) {
+	cookie, err := r.Cookie("session_token")
+	if err == nil {
+		endSession(cookie.Value)
+	}
+
 	http.SetCookie(w, g.GenerateEmptyCookie())
 	http.Redirect(w, r, "/login", http.StatusFound)
 }
@@ -267,14 +366,12 @@ func postResetPasswordHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	resetToken, err := g.GenerateRandomToken(32)
+	err := sendPasswordReset(user)
 	if err != nil {
-		http.Error(w, "Could not generate reset token.", http.StatusInternalServerError)
+		httpError(w, r, "Could not generate reset token.", http.StatusInternalServerError)
 		return
 	}
-
-	ks.Set("reset:"+resetToken, user.ID, time.Hour)
-	sendResetEmail(user.Email, resetToken)
+	audit(r, user.ID, auditPasswordResetSent, "")
 
 	http.Redirect(w, r, "/login", http.StatusFound)
 
@@ -284,36 +381,56 @@ func getResetPasswordConfirmHandler(w http.ResponseWriter, r *http.Request) {
 	token := r.URL.Query().Get("token")
 	_, err := ks.Get("reset:" + token)
 	if err != nil {
-		http.Error(w, "Token is invalid or expired.", http.StatusUnauthorized)
+		httpError(w, r, "Token is invalid or expired.", http.StatusUnauthorized)
 		return
 	}
 
-	xt.ExecuteTemplate(w, "auth-new_password.tmpl", nil)
+	executeTemplate(w, r, http.StatusOK, "auth-new_password.tmpl", map[string]interface{}{"MinLength": passwordPolicy.MinLength})
 }
 
 func postResetPasswordConfirmHandler(w http.ResponseWriter, r *http.Request) {
 	token := r.URL.Query().Get("token")
 	userID, err := ks.Get("reset:" + token)
 	if err != nil {
-		http.Error(w, "Token is invalid or expired.", http.StatusUnauthorized)
+		httpError(w, r, "Token is invalid or expired.", http.StatusUnauthorized)
 		return
 	}
 
 	var user User
 	db.First(&user, *userID)
 
+	// the token is kept, so it can be used once the lockout is over
+	if isLockedOut(user) {
+		httpError(w, r, "This account is temporarily locked, please try again later.", http.StatusTooManyRequests)
+		return
+	}
+
 	password := r.FormValue("password")
 
-	hashedPassword, salt, err := g.HashPassword(password)
+	problems := checkNewPassword(r, password, user.Username, user.Email)
+	if len(problems) > 0 {
+		data := map[string]interface{}{
+			"MinLength":        passwordPolicy.MinLength,
+			"PasswordProblems": problems,
+		}
+
+		executeTemplate(w, r, http.StatusBadRequest, "auth-new_password.tmpl", data)
+		return
+	}
+
+	hashedPassword, err := g.HashPassword(password)
 	if err != nil {
-		http.Error(w, "Invalid password.", http.StatusBadRequest)
+		httpError(w, r, "Invalid password.", http.StatusBadRequest)
 		return
 	}
 
 	user.PasswordHash = hashedPassword
-	user.Salt = salt
+	user.Salt = ""       // only legacy hashes have a separate salt
+	user.Verified = true // the reset link was delivered to this address
 	db.Save(&user)
-	ks.Delete(token)
+	ks.Delete("reset:" + token)
+	resetLoginFailures(user)
+	audit(r, user.ID, auditPasswordReset, "")
 
 	http.Redirect(w, r, "/login", http.StatusFound)
 }
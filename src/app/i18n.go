@@ -0,0 +1,140 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/birabittoh/auth-boilerplate/src/i18n"
+	"github.com/utking/extemplate"
+)
+
+type languageOption struct {
+	Tag  string
+	Name string
+}
+
+const (
+	defaultLanguage = "en"
+
+	localizerContextKey key = 1
+)
+
+var tr *i18n.Bundle
+
+func loadTranslations() {
+	var err error
+	tr, err = i18n.Load("locales", defaultLanguage)
+	if err != nil {
+		log.Fatal("Could not load translations: ", err)
+	}
+	log.Println("Languages:", strings.Join(tr.Languages(), ", "))
+}
+
+// Parses the templates once per language, so they can call the translation functions directly
+func loadTemplates() {
+	xt = map[string]*extemplate.Extemplate{}
+	for _, language := range tr.Languages() {
+		l := tr.Localizer(language)
+		x := extemplate.New().Funcs(template.FuncMap{
+			"t":        l.T,
+			"th":       translateHTML(l),
+			"n":        l.N,
+			"date":     l.Date,
+			"datetime": l.DateTime,
+			"ago":      l.Ago,
+			"lang":     l.Language,
+
+			// bound to the request by executeTemplate
+			"csrfField": func() template.HTML { return "" },
+			"csrfToken": func() string { return "" },
+		})
+
+		err := x.ParseDir("templates", []string{".tmpl"})
+		if err != nil {
+			log.Fatal(err)
+		}
+		xt[language] = x
+	}
+}
+
+// Like T, for messages with markup: the arguments are escaped, the catalogue is trusted
+func translateHTML(l *i18n.Localizer) func(string, ...interface{}) template.HTML {
+	return func(key string, args ...interface{}) template.HTML {
+		for i, arg := range args {
+			args[i] = template.HTMLEscapeString(fmt.Sprint(arg))
+		}
+		return template.HTML(l.T(key, args...))
+	}
+}
+
+// Middleware to pick the language of each request: the user's preference, then the browser's
+func localized(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/static/") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		var preference []string
+		if userID, err := readSessionCookie(r); err == nil {
+			db.Model(&User{}).Where("id = ?", *userID).Pluck("language", &preference)
+		}
+
+		l := tr.Localizer(append(preference, r.Header.Get("Accept-Language"))...)
+		w.Header().Set("Content-Language", l.Language())
+		w.Header().Add("Vary", "Accept-Language")
+
+		ctx := context.WithValue(r.Context(), localizerContextKey, l)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+func localizer(r *http.Request) *i18n.Localizer {
+	if r != nil {
+		if l, ok := r.Context().Value(localizerContextKey).(*i18n.Localizer); ok {
+			return l
+		}
+	}
+	return tr.Localizer()
+}
+
+// For messages sent outside of a request, like emails and notifications
+func userLocalizer(user User) *i18n.Localizer {
+	return tr.Localizer(user.Language)
+}
+
+// Like http.Error, with the message translated for the request
+func httpError(w http.ResponseWriter, r *http.Request, message string, code int) {
+	http.Error(w, localizer(r).T(message), code)
+}
+
+func languageOptions() (options []languageOption) {
+	for _, language := range tr.Languages() {
+		options = append(options, languageOption{Tag: language, Name: tr.Localizer(language).Name()})
+	}
+	return
+}
+
+func postSettingsLanguageHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	language := r.FormValue("language")
+	if language != "" && !slices.Contains(tr.Languages(), language) {
+		httpError(w, r, "Unsupported language.", http.StatusBadRequest)
+		return
+	}
+
+	user.Language = language
+	db.Model(&user).Update("language", language)
+
+	http.Redirect(w, r, "/settings", http.StatusFound)
+}
@@ -10,8 +10,11 @@ import (
 
 	"github.com/birabittoh/auth-boilerplate/src/auth"
 	"github.com/birabittoh/auth-boilerplate/src/email"
+	"github.com/birabittoh/auth-boilerplate/src/push"
+	"github.com/birabittoh/auth-boilerplate/src/telegram"
 	"github.com/birabittoh/myks"
 	"github.com/glebarez/sqlite"
+	"github.com/go-webauthn/webauthn/webauthn"
 	"github.com/joho/godotenv"
 	"github.com/utking/extemplate"
 	"gorm.io/gorm"
@@ -23,10 +26,32 @@ type User struct {
 	gorm.Model
 	Username     string `gorm:"unique"`
 	Email        string `gorm:"unique"`
+	PendingEmail string // new address waiting for confirmation
 	PasswordHash string
 	Salt         string
+	Verified     bool
+	Admin        bool
+	Disabled     bool
+	Language     string // empty follows the browser
+	InviteID     *uint  // invite used to register, if any
 
-	Habits []Habit
+	TOTPSecret   string
+	TOTPLastStep int64
+
+	WebAuthnHandle []byte `gorm:"unique"`
+
+	TelegramChatID *int64 `gorm:"unique"`
+
+	DeletionScheduled *time.Time
+	DeletionExport    bool
+
+	Habits            []Habit
+	PushSubscriptions []PushSubscription
+	Channels          []Channel
+	RecoveryCodes     []RecoveryCode
+	Passkeys          []Passkey
+	Identities        []Identity
+	Invites           []Invite
 }
 
 type Habit struct {
@@ -38,31 +63,123 @@ type Habit struct {
 	Negative bool
 	Disabled bool
 
-	User User
-	Acks []Ack
+	LastReminder *time.Time
+
+	User     User
+	Acks     []Ack
+	Webhooks []Webhook
 }
 
 type Ack struct {
 	gorm.Model
-	HabitID uint
+	HabitID        uint
+	UserID         uint    `gorm:"uniqueIndex:idx_acks_user_key"`
+	IdempotencyKey *string `gorm:"uniqueIndex:idx_acks_user_key"` // unique per user, as chosen by their devices
 
 	Habit Habit
 }
 
+type Webhook struct {
+	gorm.Model
+	HabitID  uint
+	Token    string `gorm:"unique"`
+	AllowGet bool   // GET requests ack too, so link previews and prefetching can trigger it
+	LastUsed *time.Time
+
+	Habit Habit
+}
+
+type RecoveryCode struct {
+	gorm.Model
+	UserID uint
+	Hash   string `gorm:"unique"`
+
+	User User
+}
+
+type Passkey struct {
+	gorm.Model
+	UserID       uint
+	Name         string
+	CredentialID []byte `gorm:"unique"`
+	Credential   string // JSON-encoded webauthn.Credential
+	LastUsed     *time.Time
+
+	User User
+}
+
+type Identity struct {
+	gorm.Model
+	UserID  uint
+	Issuer  string `gorm:"uniqueIndex:idx_identity"`
+	Subject string `gorm:"uniqueIndex:idx_identity"`
+	Email   string
+
+	User User
+}
+
+type Invite struct {
+	gorm.Model
+	UserID    uint
+	Code      string `gorm:"unique"`
+	MaxUses   uint   // 0 means unlimited
+	Uses      uint
+	ExpiresAt *time.Time
+
+	User User
+}
+
+type PushSubscription struct {
+	gorm.Model
+	UserID    uint
+	Endpoint  string `gorm:"unique"`
+	P256dh    string
+	Auth      string
+	UserAgent string
+	LastUsed  *time.Time
+
+	User User
+}
+
+type Channel struct {
+	gorm.Model
+	UserID  uint
+	Kind    string
+	URL     string
+	Token   string
+	Target  string
+	Enabled bool
+
+	User User
+}
+
+// Fingerprint of a pepper, to notice when its secret changes under the same id
+type Pepper struct {
+	ID          string `gorm:"primaryKey"`
+	Fingerprint string
+}
+
 const (
-	dataDir = "data"
-	dbName  = "app.db"
+	dataDir  = "data"
+	dbName   = "app.db"
+	vapidKey = "vapid.pem"
+	secret   = "secret.key"
 )
 
 var (
 	db *gorm.DB
 	g  *auth.Auth
 	m  *email.Client
-	xt *extemplate.Extemplate
+	wp *push.Client
+	tg *telegram.Bot
+	wa *webauthn.WebAuthn
+	o  *oidcClient
+	xt map[string]*extemplate.Extemplate // by language
 
 	baseUrl             string
 	port                string
-	registrationEnabled = true
+	requireVerification = false
+	userInvites         = false
 
 	ks           = myks.New[uint](0)
 	durationDay  = 24 * time.Hour
@@ -89,9 +206,33 @@ func Main() {
 
 	e := strings.ToLower(os.Getenv("APP_REGISTRATION_ENABLED"))
 	if e == "false" || e == "0" {
-		registrationEnabled = false
+		setRegistrationMode(registrationClosed)
+	} else if e == registrationInvite {
+		setRegistrationMode(registrationInvite)
+	}
+
+	e = strings.ToLower(os.Getenv("APP_USER_INVITES"))
+	if e == "true" || e == "1" {
+		userInvites = true
+	}
+
+	e = strings.ToLower(os.Getenv("APP_REQUIRE_VERIFICATION"))
+	if e == "true" || e == "1" {
+		requireVerification = true
+	}
+
+	e = strings.ToLower(os.Getenv("APP_MAGIC_LINKS"))
+	if e == "true" || e == "1" {
+		magicLinks = true
 	}
 
+	loadRateLimitConfig()
+	loadDeletionConfig()
+	loadPasswordPolicy()
+	loadAuditConfig()
+	loadOutboxConfig()
+	loadTranslations()
+
 	// Init auth and email
 	m = loadEmailConfig()
 	g = auth.NewAuth(os.Getenv("APP_PEPPER"), auth.DefaultMaxPasswordLength)
@@ -99,49 +240,202 @@ func Main() {
 		log.Fatal("Could not init authentication.")
 	}
 
+	err = loadPeppers()
+	if err != nil {
+		log.Fatal("Could not load peppers: ", err)
+	}
+
 	os.MkdirAll(dataDir, os.ModePerm)
+	g.SigningKey, err = loadSigningKey()
+	if err != nil {
+		log.Fatal("Could not load signing key: ", err)
+	}
+
 	dbPath := filepath.Join(dataDir, dbName) + "?_pragma=foreign_keys(1)"
 	db, err = gorm.Open(sqlite.Open(dbPath), &gorm.Config{})
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	db.AutoMigrate(&User{}, &Habit{}, &Ack{})
+	err = migrate()
+	if err != nil {
+		log.Fatal("Could not migrate the database: ", err)
+	}
 
-	// Init template engine
-	xt = extemplate.New()
-	err = xt.ParseDir("templates", []string{".tmpl"})
+	err = checkPepperFingerprints()
 	if err != nil {
-		log.Fatal(err)
+		log.Fatal("Could not check peppers: ", err)
 	}
 
-	// App
-	http.HandleFunc("GET /", getIndexHandler)
-	http.HandleFunc("GET /habits", loginRequired(getHabitsHandler))
-	http.HandleFunc("GET /habits/{id}", loginRequired(getHabitsIDHandler))
-	http.HandleFunc("GET /new-positive", loginRequired(getNewPositiveHandler))
-	http.HandleFunc("GET /new-negative", loginRequired(getNewNegativeHandler))
-	http.HandleFunc("POST /new", loginRequired(postNewHandler))
-	http.HandleFunc("POST /habits/{id}", loginRequired(postHabitsIDHandler))
-	http.HandleFunc("POST /delete/{id}", loginRequired(postDeleteIDHandler))
-	http.HandleFunc("POST /ack/{id}", loginRequired(postAckIDHandler))
+	loadAdmins()
 
-	// Auth
-	http.HandleFunc("GET /register", getRegisterHandler)
-	http.HandleFunc("GET /login", getLoginHandler)
-	http.HandleFunc("GET /reset-password", getResetPasswordHandler)
-	http.HandleFunc("GET /reset-password-confirm", getResetPasswordConfirmHandler)
-	http.HandleFunc("GET /logout", logoutHandler)
-	http.HandleFunc("POST /login", postLoginHandler)
-	http.HandleFunc("POST /register", postRegisterHandler)
-	http.HandleFunc("POST /reset-password", postResetPasswordHandler)
-	http.HandleFunc("POST /reset-password-confirm", postResetPasswordConfirmHandler)
+	if len(os.Args) > 1 && os.Args[1] == "peppers" {
+		printPepperReport()
+		return
+	}
+	checkPeppers()
 
-	// Static
-	http.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+	wp = loadPushConfig()
+	tg = loadTelegramConfig()
+	wa = loadWebAuthnConfig()
+	o = loadOIDCConfig()
+
+	// Init template engine
+	loadTemplates()
+
+	// Background jobs
+	go startReminders()
+	go startTelegramBot()
+	go startAccountPurge()
+	go startAuditRetention()
+	if m != nil {
+		go startOutbox()
+	}
 
 	// Start serving
 	log.Println("Port: " + port)
 	log.Println("Server started: " + baseUrl)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
+	log.Fatal(http.ListenAndServe(":"+port, newRouter()))
+}
+
+func newRouter() http.Handler {
+	mux := http.NewServeMux()
+
+	// App
+	mux.HandleFunc("GET /", getIndexHandler)
+	mux.HandleFunc("GET /habits", loginRequired(getHabitsHandler))
+	mux.HandleFunc("GET /habits/{id}", loginRequired(getHabitsIDHandler))
+	mux.HandleFunc("GET /new-positive", loginRequired(getNewPositiveHandler))
+	mux.HandleFunc("GET /new-negative", loginRequired(getNewNegativeHandler))
+	mux.HandleFunc("POST /new", loginRequired(postNewHandler))
+	mux.HandleFunc("POST /habits/{id}", loginRequired(postHabitsIDHandler))
+	mux.HandleFunc("POST /delete/{id}", loginRequired(postDeleteIDHandler))
+	mux.HandleFunc("POST /ack/{id}", loginRequired(postAckIDHandler))
+	mux.HandleFunc("POST /habits/{id}/webhooks", loginRequired(postWebhooksHandler))
+	mux.HandleFunc("POST /habits/{id}/webhooks/{webhookID}/delete", loginRequired(postWebhookDeleteHandler))
+
+	// Settings
+	mux.HandleFunc("GET /settings", loginRequired(getSettingsHandler))
+	mux.HandleFunc("GET /settings/security-log", loginRequired(getAuditHandler))
+	mux.HandleFunc("POST /settings/username", loginRequired(postSettingsUsernameHandler))
+	mux.HandleFunc("POST /settings/email", loginRequired(postSettingsEmailHandler))
+	mux.HandleFunc("POST /settings/password", loginRequired(postSettingsPasswordHandler))
+	mux.HandleFunc("POST /settings/language", loginRequired(postSettingsLanguageHandler))
+	mux.HandleFunc("POST /settings/delete", loginRequired(postDeleteAccountHandler))
+	mux.HandleFunc("POST /settings/delete/cancel", loginRequired(postCancelDeletionHandler))
+
+	// Admin
+	mux.HandleFunc("GET /admin", adminRequired(getAdminHandler))
+	mux.HandleFunc("POST /admin/registration", adminRequired(postAdminRegistrationHandler))
+	mux.HandleFunc("POST /admin/users/{id}/disable", adminRequired(postAdminDisableHandler))
+	mux.HandleFunc("POST /admin/outbox/{id}/retry", adminRequired(postAdminOutboxRetryHandler))
+	mux.HandleFunc("POST /admin/outbox/{id}/delete", adminRequired(postAdminOutboxDeleteHandler))
+	mux.HandleFunc("GET /admin/users/{id}/audit", adminRequired(getAdminAuditHandler))
+	mux.HandleFunc("POST /admin/users/{id}/enable", adminRequired(postAdminEnableHandler))
+	mux.HandleFunc("POST /admin/users/{id}/reset-password", adminRequired(postAdminResetPasswordHandler))
+	mux.HandleFunc("POST /admin/users/{id}/delete", adminRequired(postAdminDeleteHandler))
+
+	// Invites
+	mux.HandleFunc("GET /invites", loginRequired(getInvitesHandler))
+	mux.HandleFunc("POST /invites", loginRequired(postInvitesHandler))
+	mux.HandleFunc("POST /invites/{id}/delete", loginRequired(postInviteDeleteHandler))
+
+	// Webhooks
+	mux.HandleFunc("GET /hook/{token}", webhookHandler)
+	mux.HandleFunc("POST /hook/{token}", webhookHandler)
+
+	// Notifications
+	mux.HandleFunc("GET /notifications", loginRequired(getNotificationsHandler))
+	mux.HandleFunc("POST /push/subscribe", loginRequired(postPushSubscribeHandler))
+	mux.HandleFunc("POST /push/{id}/test", loginRequired(postPushTestHandler))
+	mux.HandleFunc("POST /push/{id}/delete", loginRequired(postPushDeleteHandler))
+	mux.HandleFunc("POST /channels", loginRequired(postChannelsHandler))
+	mux.HandleFunc("POST /channels/{id}", loginRequired(postChannelsIDHandler))
+	mux.HandleFunc("POST /channels/{id}/test", loginRequired(postChannelTestHandler))
+	mux.HandleFunc("POST /channels/{id}/delete", loginRequired(postChannelDeleteHandler))
+	mux.HandleFunc("POST /telegram/link", loginRequired(postTelegramLinkHandler))
+	mux.HandleFunc("POST /telegram/unlink", loginRequired(postTelegramUnlinkHandler))
+
+	// PWA
+	mux.HandleFunc("GET /sw.js", getServiceWorkerHandler)
+	mux.HandleFunc("GET /offline", getOfflineHandler)
+	mux.HandleFunc("POST /sync", loginRequired(postSyncHandler))
+
+	// Auth
+	mux.HandleFunc("GET /register", getRegisterHandler)
+	mux.HandleFunc("GET /login", getLoginHandler)
+	mux.HandleFunc("GET /reset-password", getResetPasswordHandler)
+	mux.HandleFunc("GET /reset-password-confirm", getResetPasswordConfirmHandler)
+	mux.HandleFunc("POST /logout", postLogoutHandler)
+	mux.HandleFunc("GET /csrf", getCSRFHandler)
+	mux.HandleFunc("POST /login", rateLimited("username", postLoginHandler))
+	mux.HandleFunc("POST /login/2fa", rateLimited("", postLoginTwoFactorHandler))
+	mux.HandleFunc("POST /register", rateLimited("email", postRegisterHandler))
+	mux.HandleFunc("POST /reset-password", rateLimited("email", postResetPasswordHandler))
+	mux.HandleFunc("POST /reset-password-confirm", rateLimited("", postResetPasswordConfirmHandler))
+	mux.HandleFunc("GET /login/email", getMagicLinkHandler)
+	mux.HandleFunc("GET /login/email/confirm", getMagicLinkConfirmHandler)
+	mux.HandleFunc("POST /login/email", rateLimited("email", postMagicLinkHandler))
+	mux.HandleFunc("POST /login/email/confirm", rateLimited("", postMagicLinkConfirmHandler))
+	mux.HandleFunc("GET /verify-email", getVerifyEmailHandler)
+	mux.HandleFunc("GET /confirm-email", getConfirmEmailHandler)
+	mux.HandleFunc("POST /verify-email/resend", rateLimited("email", postVerifyEmailResendHandler))
+	mux.HandleFunc("GET /2fa", loginRequired(getTwoFactorHandler))
+	mux.HandleFunc("POST /2fa/enable", loginRequired(postTwoFactorEnableHandler))
+	mux.HandleFunc("POST /2fa/disable", loginRequired(postTwoFactorDisableHandler))
+	mux.HandleFunc("POST /2fa/recovery-codes", loginRequired(postRecoveryCodesHandler))
+	mux.HandleFunc("GET /passkeys", loginRequired(getPasskeysHandler))
+	mux.HandleFunc("POST /passkeys/register/begin", loginRequired(postPasskeyRegisterBeginHandler))
+	mux.HandleFunc("POST /passkeys/register/finish", loginRequired(postPasskeyRegisterFinishHandler))
+	mux.HandleFunc("POST /passkeys/login/begin", rateLimited("", postPasskeyLoginBeginHandler))
+	mux.HandleFunc("POST /passkeys/login/finish", rateLimited("", postPasskeyLoginFinishHandler))
+	mux.HandleFunc("POST /passkeys/{id}/delete", loginRequired(postPasskeyDeleteHandler))
+	mux.HandleFunc("GET /oidc/login", getOIDCLoginHandler)
+	mux.HandleFunc("GET /oidc/callback", getOIDCCallbackHandler)
+	mux.HandleFunc("POST /oidc/link", loginRequired(postOIDCLinkHandler))
+	mux.HandleFunc("POST /oidc/{id}/unlink", loginRequired(postOIDCUnlinkHandler))
+
+	// Static
+	mux.Handle("GET /static/", http.StripPrefix("/static/", http.FileServer(http.Dir("static"))))
+
+	return localized(csrfProtected(mux))
+}
+
+func migrate() error {
+	// accounts created before email verification existed keep working
+	backfillVerified := db.Migrator().HasTable(&User{}) && !db.Migrator().HasColumn(&User{}, "Verified")
+	// webhooks used to allow GET unless restricted to POST
+	backfillAllowGet := db.Migrator().HasColumn(&Webhook{}, "post_only")
+
+	err := db.AutoMigrate(&User{}, &Habit{}, &Ack{}, &Webhook{}, &PushSubscription{}, &Channel{}, &RecoveryCode{}, &Passkey{}, &Identity{}, &Invite{}, &AuditEvent{}, &OutboxEmail{}, &Pepper{})
+	if err != nil {
+		return err
+	}
+
+	if backfillVerified {
+		err = db.Model(&User{}).Where("1 = 1").Update("verified", true).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	if backfillAllowGet {
+		err = db.Exec("UPDATE webhooks SET allow_get = NOT post_only").Error
+		if err != nil {
+			return err
+		}
+		err = db.Exec("ALTER TABLE webhooks DROP COLUMN post_only").Error
+		if err != nil {
+			return err
+		}
+	}
+
+	// group chats, linked before the bot was limited to private chats, have negative IDs
+	err = db.Model(&User{}).Where("telegram_chat_id < 0").Update("telegram_chat_id", nil).Error
+	if err != nil {
+		return err
+	}
+
+	// acks recorded before they had a user
+	return db.Exec("UPDATE acks SET user_id = (SELECT user_id FROM habits WHERE habits.id = acks.habit_id) WHERE user_id IS NULL OR user_id = 0").Error
 }
@@ -0,0 +1,205 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	registrationOpen   = "open"
+	registrationInvite = "invite"
+	registrationClosed = "closed"
+
+	maxUserInvites    = 5  // active codes a regular user can have
+	maxUserInviteUses = 10 // uses of a code minted by a regular user
+	maxUserInviteDays = 30 // lifetime of a code minted by a regular user
+)
+
+var (
+	errInvalidInvite = errors.New("invalid or expired invite code")
+
+	registrationModes = []string{registrationOpen, registrationInvite, registrationClosed}
+
+	registrationMu   sync.RWMutex // admins change the mode while requests read it
+	registrationMode = registrationOpen
+)
+
+func getRegistrationMode() string {
+	registrationMu.RLock()
+	defer registrationMu.RUnlock()
+	return registrationMode
+}
+
+func setRegistrationMode(mode string) {
+	registrationMu.Lock()
+	defer registrationMu.Unlock()
+	registrationMode = mode
+}
+
+func canInvite(user User) bool {
+	return user.Admin || userInvites
+}
+
+func isRegistrationMode(mode string) bool {
+	for _, m := range registrationModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// Consumes one use of an invite code, failing if it is expired or used up
+func useInvite(code string) (invite Invite, err error) {
+	code = strings.ToLower(strings.TrimSpace(code))
+	if code == "" {
+		return invite, errInvalidInvite
+	}
+
+	res := db.Model(&Invite{}).
+		Where("code = ? AND (max_uses = 0 OR uses < max_uses) AND (expires_at IS NULL OR expires_at > ?)", code, time.Now()).
+		Update("uses", gorm.Expr("uses + 1"))
+	if res.Error != nil || res.RowsAffected != 1 {
+		return invite, errInvalidInvite
+	}
+
+	err = db.Model(&Invite{}).Where(&Invite{Code: code}).First(&invite).Error
+	return
+}
+
+// Gives back a use taken by useInvite when the registration does not go through
+func releaseInvite(invite Invite) {
+	db.Model(&invite).Update("uses", gorm.Expr("uses - 1"))
+}
+
+func getInviteHelper(w http.ResponseWriter, r *http.Request) (invite Invite, err error) {
+	id := getID(r)
+	if id == 0 {
+		err = errors.New("no id")
+		httpError(w, r, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := getLoggedUser(r)
+	if !ok {
+		err = errors.New("no logged user")
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err = db.Model(&Invite{}).Where("id = ? AND user_id = ?", id, user.ID).First(&invite).Error
+	if err != nil {
+		httpError(w, r, "not found", http.StatusNotFound)
+	}
+	return
+}
+
+func getInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "Could not find user in context.", http.StatusInternalServerError)
+		return
+	}
+
+	if !canInvite(user) {
+		httpError(w, r, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var invites []Invite
+	err := db.Model(&Invite{}).Where(&Invite{UserID: user.ID}).Order("id DESC").Find(&invites).Error
+	if err != nil {
+		httpError(w, r, "Could not get invites.", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Invites": invites,
+		"BaseURL": baseUrl,
+		"Admin":   user.Admin,
+		"Mode":    getRegistrationMode(),
+		"MaxUses": maxUserInviteUses,
+		"MaxDays": maxUserInviteDays,
+		"Now":     time.Now(),
+	}
+
+	executeTemplate(w, r, http.StatusOK, "invites.tmpl", data)
+}
+
+func postInvitesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !canInvite(user) {
+		httpError(w, r, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	uses, err := strconv.ParseUint(r.FormValue("uses"), 10, 64)
+	if err != nil {
+		httpError(w, r, "Bad uses value.", http.StatusBadRequest)
+		return
+	}
+
+	days, err := strconv.ParseUint(r.FormValue("days"), 10, 64)
+	if err != nil {
+		httpError(w, r, "Bad days value.", http.StatusBadRequest)
+		return
+	}
+
+	if !user.Admin {
+		if uses == 0 || uses > maxUserInviteUses || days == 0 || days > maxUserInviteDays {
+			httpError(w, r, "Invites must have limited uses and expiry.", http.StatusBadRequest)
+			return
+		}
+
+		var active int64
+		db.Model(&Invite{}).
+			Where("user_id = ? AND (max_uses = 0 OR uses < max_uses) AND (expires_at IS NULL OR expires_at > ?)", user.ID, time.Now()).
+			Count(&active)
+		if active >= maxUserInvites {
+			httpError(w, r, "You have too many active invites.", http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	code, err := g.GenerateRandomToken(8)
+	if err != nil {
+		httpError(w, r, "Could not generate invite code.", http.StatusInternalServerError)
+		return
+	}
+
+	invite := Invite{
+		UserID:  user.ID,
+		Code:    code,
+		MaxUses: uint(uses),
+	}
+	if days > 0 {
+		expires := time.Now().Add(time.Duration(days) * durationDay)
+		invite.ExpiresAt = &expires
+	}
+
+	db.Create(&invite)
+
+	http.Redirect(w, r, "/invites", http.StatusFound)
+}
+
+func postInviteDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	invite, err := getInviteHelper(w, r)
+	if err != nil {
+		return
+	}
+
+	db.Unscoped().Delete(&invite)
+
+	http.Redirect(w, r, "/invites", http.StatusFound)
+}
@@ -0,0 +1,185 @@
+package app
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func setRegistrationModeForTest(t *testing.T, mode string) {
+	previous := getRegistrationMode()
+	setRegistrationMode(mode)
+	t.Cleanup(func() { setRegistrationMode(previous) })
+}
+
+func createTestInvite(t *testing.T, owner User, code string, maxUses uint, expires time.Duration) Invite {
+	t.Helper()
+
+	invite := Invite{UserID: owner.ID, Code: code, MaxUses: maxUses}
+	if expires != 0 {
+		at := time.Now().Add(expires)
+		invite.ExpiresAt = &at
+	}
+	err := db.Create(&invite).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return invite
+}
+
+func TestUseInvite(t *testing.T) {
+	owner := createTestUser(t, "inviteuse")
+	createTestInvite(t, owner, "unlimited", 0, 0)
+	createTestInvite(t, owner, "twice", 2, time.Hour)
+	createTestInvite(t, owner, "expired", 0, -time.Minute)
+
+	tests := []struct {
+		code string
+		ok   bool
+	}{
+		{"unlimited", true},
+		{"unlimited", true},
+		{" TWICE ", true},
+		{"twice", true},
+		{"twice", false}, // used up
+		{"expired", false},
+		{"unknown", false},
+		{"", false},
+	}
+
+	for i, tt := range tests {
+		invite, err := useInvite(tt.code)
+		if (err == nil) != tt.ok {
+			t.Errorf("%d: useInvite(%q) = %v", i, tt.code, err)
+		}
+		if err == nil && invite.UserID != owner.ID {
+			t.Errorf("%d: useInvite(%q) returned invite %d", i, tt.code, invite.ID)
+		}
+	}
+
+	var twice Invite
+	db.Where("code = ?", "twice").First(&twice)
+	if twice.Uses != 2 {
+		t.Errorf("twice has %d uses, want 2", twice.Uses)
+	}
+
+	releaseInvite(twice)
+	if _, err := useInvite("twice"); err != nil {
+		t.Errorf("a released use could not be taken again: %v", err)
+	}
+}
+
+func TestUserInviteLimits(t *testing.T) {
+	previous := userInvites
+	userInvites = true
+	t.Cleanup(func() { userInvites = previous })
+
+	user := createTestUser(t, "invitelim")
+	admin := createTestUser(t, "inviteadm")
+	db.Model(&admin).Update("admin", true)
+
+	c := newTestClient(t)
+	c.login(user)
+
+	tests := []struct {
+		uses, days string
+		status     int
+	}{
+		{"0", "7", http.StatusBadRequest},  // unlimited uses
+		{"5", "0", http.StatusBadRequest},  // no expiry
+		{"11", "7", http.StatusBadRequest}, // over maxUserInviteUses
+		{"5", "31", http.StatusBadRequest}, // over maxUserInviteDays
+		{"x", "7", http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		res := c.post("/invites", url.Values{"uses": {tt.uses}, "days": {tt.days}})
+		if res.StatusCode != tt.status {
+			t.Errorf("uses %s, days %s: status %d, want %d", tt.uses, tt.days, res.StatusCode, tt.status)
+		}
+	}
+
+	// used up and expired codes do not count
+	createTestInvite(t, user, "limusedup", 1, time.Hour)
+	db.Model(&Invite{}).Where("code = ?", "limusedup").Update("uses", 1)
+	createTestInvite(t, user, "limexpired", 1, -time.Hour)
+
+	for i := range maxUserInvites {
+		if res := c.post("/invites", url.Values{"uses": {"1"}, "days": {"1"}}); res.StatusCode != http.StatusFound {
+			t.Fatalf("invite %d: status %d", i, res.StatusCode)
+		}
+	}
+	if res := c.post("/invites", url.Values{"uses": {"1"}, "days": {"1"}}); res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("invite over the limit: status %d", res.StatusCode)
+	}
+
+	// admins are not limited
+	c.login(admin)
+	for range maxUserInvites + 1 {
+		if res := c.post("/invites", url.Values{"uses": {"0"}, "days": {"0"}}); res.StatusCode != http.StatusFound {
+			t.Fatalf("admin invite: status %d", res.StatusCode)
+		}
+	}
+
+	var unlimited Invite
+	db.Where("user_id = ?", admin.ID).First(&unlimited)
+	if unlimited.MaxUses != 0 || unlimited.ExpiresAt != nil {
+		t.Errorf("admin invite has %d uses and expiry %v", unlimited.MaxUses, unlimited.ExpiresAt)
+	}
+
+	userInvites = false
+	c.login(user)
+	if res := c.post("/invites", url.Values{"uses": {"1"}, "days": {"1"}}); res.StatusCode != http.StatusForbidden {
+		t.Errorf("user invites disabled: status %d", res.StatusCode)
+	}
+}
+
+func TestInviteOnlyRegistration(t *testing.T) {
+	setRegistrationModeForTest(t, registrationInvite)
+	owner := createTestUser(t, "inviteown")
+	invite := createTestInvite(t, owner, "onlyonce", 1, time.Hour)
+
+	register := func(username, address, code string) *http.Response {
+		return newTestClient(t).post("/register", url.Values{
+			"username": {username},
+			"email":    {address},
+			"password": {testPassword},
+			"invite":   {code},
+		})
+	}
+
+	if res := register("invitenone", "invitenone@example.com", ""); res.StatusCode != http.StatusForbidden {
+		t.Errorf("without a code: status %d", res.StatusCode)
+	}
+	if res := register("invitebad", "invitebad@example.com", "wrong"); res.StatusCode != http.StatusForbidden {
+		t.Errorf("with a bad code: status %d", res.StatusCode)
+	}
+
+	// the address is taken, so the registration fails and gives the use back
+	if res := register("invitedup", owner.Email, "onlyonce"); res.StatusCode != http.StatusConflict {
+		t.Errorf("taken email: status %d", res.StatusCode)
+	}
+	db.First(&invite, invite.ID)
+	if invite.Uses != 0 {
+		t.Errorf("failed registration kept %d uses", invite.Uses)
+	}
+
+	if res := register("invited", "invited@example.com", "onlyonce"); res.StatusCode != http.StatusFound {
+		t.Fatalf("with the code: status %d", res.StatusCode)
+	}
+	var user User
+	db.Where("username = ?", "invited").First(&user)
+	if user.InviteID == nil || *user.InviteID != invite.ID {
+		t.Errorf("user was invited by %v, want %d", user.InviteID, invite.ID)
+	}
+
+	if res := register("invited2", "invited2@example.com", "onlyonce"); res.StatusCode != http.StatusForbidden {
+		t.Errorf("used up code: status %d", res.StatusCode)
+	}
+
+	setRegistrationMode(registrationClosed)
+	createTestInvite(t, owner, "closedcode", 0, 0)
+	if res := register("inviteshut", "inviteshut@example.com", "closedcode"); res.StatusCode != http.StatusForbidden {
+		t.Errorf("closed registration: status %d", res.StatusCode)
+	}
+}
@@ -0,0 +1,52 @@
+package app
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/birabittoh/auth-boilerplate/src/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestLoginUpgradesBcrypt(t *testing.T) {
+	user := createTestUser(t, "bcryptupgrade")
+	salt := "0123456789abcdef"
+	legacy, err := auth.Bcrypt{Cost: bcrypt.MinCost}.Hash(testPassword+salt+g.LegacyPepper, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Model(&user).Updates(map[string]interface{}{"password_hash": legacy, "salt": salt})
+
+	c := newTestClient(t)
+	res := c.post("/login", url.Values{"username": {user.Username}, "password": {testPassword}})
+	if res.StatusCode != http.StatusFound || c.userID() != user.ID {
+		t.Fatalf("login: status %d", res.StatusCode)
+	}
+
+	db.First(&user, user.ID)
+	if user.Salt != "" || !auth.DefaultArgon2id.Recognizes(user.PasswordHash) || g.NeedsRehash(user.Salt, user.PasswordHash) {
+		t.Errorf("hash was not upgraded: %s", user.PasswordHash)
+	}
+	if !g.CheckPassword(testPassword, user.Salt, user.PasswordHash) {
+		t.Error("the upgraded hash rejects the password")
+	}
+}
+
+func TestLoginUnverified(t *testing.T) {
+	previous := requireVerification
+	requireVerification = true
+	t.Cleanup(func() { requireVerification = previous })
+
+	user := createTestUser(t, "unverified")
+	db.Model(&user).Update("verified", false)
+
+	c := newTestClient(t)
+	res := c.post("/login", url.Values{"username": {user.Username}, "password": {testPassword}})
+	if res.StatusCode != http.StatusForbidden || c.userID() != 0 {
+		t.Fatalf("login: status %d, logged in as %d", res.StatusCode, c.userID())
+	}
+	if cc := res.Header.Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("Cache-Control %q, want no-store", cc)
+	}
+}
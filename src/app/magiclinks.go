@@ -0,0 +1,144 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/birabittoh/myks"
+)
+
+const magicLinkDuration = 15 * time.Minute
+
+// What a login token grants, stored under the token
+type magicLink struct {
+	UserID   uint
+	Remember bool
+}
+
+var (
+	magicLinks   = false
+	mks          = myks.New[magicLink](time.Hour)
+	magicLinksMu sync.Mutex // makes consuming a token atomic
+)
+
+func sendMagicLinkEmail(user User, token string) {
+	loginURL := fmt.Sprintf("%s/login/email/confirm?token=%s", baseUrl, token)
+	err := sendTemplateEmail(user, "magic_link", map[string]interface{}{
+		"URL":     loginURL,
+		"Minutes": int(magicLinkDuration.Minutes()),
+	})
+	if err != nil {
+		log.Printf("Could not send login email for %s: %v", user.Email, err)
+	}
+}
+
+// Returns what a login token grants; the token cannot be used again
+func consumeMagicLink(token string) (link magicLink, ok bool) {
+	magicLinksMu.Lock()
+	defer magicLinksMu.Unlock()
+
+	l, err := mks.Get(token)
+	if err != nil {
+		return
+	}
+
+	mks.Delete(token)
+	return *l, true
+}
+
+func getMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if !magicLinks {
+		httpError(w, r, "Login by email is not available on this instance.", http.StatusNotFound)
+		return
+	}
+
+	executeTemplate(w, r, http.StatusOK, "auth-magic_link.tmpl", nil)
+}
+
+func postMagicLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if !magicLinks {
+		httpError(w, r, "Login by email is not available on this instance.", http.StatusNotFound)
+		return
+	}
+
+	// The same page is shown whether or not the address is registered
+	data := map[string]interface{}{"Sent": true}
+
+	address, err := sanitizeEmail(r.FormValue("email"))
+	if err != nil {
+		executeTemplate(w, r, http.StatusOK, "auth-magic_link.tmpl", data)
+		return
+	}
+
+	var user User
+	db.Where("email = ?", address).First(&user)
+	if user.ID == 0 || user.Disabled {
+		executeTemplate(w, r, http.StatusOK, "auth-magic_link.tmpl", data)
+		return
+	}
+
+	token, err := g.GenerateRandomToken(32)
+	if err != nil {
+		httpError(w, r, "Could not generate login token.", http.StatusInternalServerError)
+		return
+	}
+
+	mks.Set(token, magicLink{UserID: user.ID, Remember: r.FormValue("remember") == "on"}, magicLinkDuration)
+	sendMagicLinkEmail(user, token)
+
+	executeTemplate(w, r, http.StatusOK, "auth-magic_link.tmpl", data)
+}
+
+// Asks for a click before using the token, so link scanners in mail clients do not consume it
+func getMagicLinkConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	_, err := mks.Get(token)
+	if !magicLinks || err != nil {
+		httpError(w, r, "This login link is invalid or expired.", http.StatusUnauthorized)
+		return
+	}
+
+	executeTemplate(w, r, http.StatusOK, "auth-magic_link.tmpl", map[string]interface{}{"Token": token})
+}
+
+func postMagicLinkConfirmHandler(w http.ResponseWriter, r *http.Request) {
+	link, ok := consumeMagicLink(r.FormValue("token"))
+	if !magicLinks || !ok {
+		httpError(w, r, "This login link is invalid or expired.", http.StatusUnauthorized)
+		return
+	}
+
+	var user User
+	err := db.First(&user, link.UserID).Error
+	if err != nil {
+		httpError(w, r, "This login link is invalid or expired.", http.StatusUnauthorized)
+		return
+	}
+
+	if user.Disabled {
+		httpError(w, r, "This account has been disabled.", http.StatusForbidden)
+		return
+	}
+
+	if isLockedOut(user) {
+		httpError(w, r, "This account is temporarily locked, please try again later.", http.StatusTooManyRequests)
+		return
+	}
+
+	if !user.Verified {
+		user.Verified = true // the link was delivered to this address
+		db.Model(&user).Update("verified", true)
+	}
+
+	if hasTwoFactor(user) {
+		startTwoFactorLogin(w, r, user, link.Remember)
+		return
+	}
+
+	resetLoginFailures(user)
+	login(w, r, user.ID, link.Remember, "login link")
+	http.Redirect(w, r, "/habits", http.StatusFound)
+}
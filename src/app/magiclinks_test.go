@@ -0,0 +1,154 @@
+package app
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+)
+
+var magicLinkToken = regexp.MustCompile(`/login/email/confirm\?token=(\S+)`)
+
+func enableMagicLinks(t *testing.T) {
+	magicLinks = true
+	t.Cleanup(func() { magicLinks = false })
+}
+
+// Asks for a login link for the address, returning the token of the email it sent, if any
+func requestMagicLink(t *testing.T, address string, remember bool) string {
+	t.Helper()
+
+	before := len(queuedEmails(t, address))
+	form := url.Values{"email": {address}}
+	if remember {
+		form.Set("remember", "on")
+	}
+
+	res := newTestClient(t).post("/login/email", form)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("request link: status %d", res.StatusCode)
+	}
+
+	emails := queuedEmails(t, address)
+	if len(emails) == before {
+		return ""
+	}
+	m := magicLinkToken.FindStringSubmatch(emails[len(emails)-1].Body)
+	if m == nil {
+		t.Fatalf("no login link in %q", emails[len(emails)-1].Body)
+	}
+	return m[1]
+}
+
+func TestMagicLinkIssue(t *testing.T) {
+	enableMagicLinks(t)
+	user := createTestUser(t, "magicissue")
+	disabled := createTestUser(t, "magicoff")
+	db.Model(&disabled).Update("disabled", true)
+
+	if token := requestMagicLink(t, "nobody@example.com", false); token != "" {
+		t.Error("a link was sent to an unknown address")
+	}
+	if token := requestMagicLink(t, disabled.Email, false); token != "" {
+		t.Error("a link was sent to a disabled account")
+	}
+
+	tests := []struct {
+		remember bool
+	}{{false}, {true}}
+
+	for _, tt := range tests {
+		token := requestMagicLink(t, user.Email, tt.remember)
+		link, err := mks.Get(token)
+		if err != nil {
+			t.Fatalf("remember %t: the token was not stored", tt.remember)
+		}
+		if link.UserID != user.ID || link.Remember != tt.remember {
+			t.Errorf("remember %t: stored %+v", tt.remember, *link)
+		}
+	}
+
+	magicLinks = false
+	res := newTestClient(t).post("/login/email", url.Values{"email": {user.Email}})
+	if res.StatusCode != http.StatusNotFound {
+		t.Errorf("disabled feature: status %d", res.StatusCode)
+	}
+}
+
+func TestConsumeMagicLink(t *testing.T) {
+	mks.Set("consume-once", magicLink{UserID: 7, Remember: true}, time.Minute)
+	mks.Set("consume-expired", magicLink{UserID: 7}, time.Nanosecond)
+	time.Sleep(time.Millisecond)
+
+	tests := []struct {
+		token string
+		ok    bool
+	}{
+		{"consume-once", true},
+		{"consume-once", false}, // single use
+		{"consume-expired", false},
+		{"consume-unknown", false},
+	}
+
+	for _, tt := range tests {
+		link, ok := consumeMagicLink(tt.token)
+		if ok != tt.ok {
+			t.Errorf("%s: ok %t, want %t", tt.token, ok, tt.ok)
+		}
+		if ok && (link.UserID != 7 || !link.Remember) {
+			t.Errorf("%s: got %+v", tt.token, link)
+		}
+	}
+}
+
+func TestMagicLinkConfirm(t *testing.T) {
+	enableMagicLinks(t)
+	user := createTestUser(t, "magicconfirm")
+	db.Model(&user).Update("verified", false)
+
+	token := requestMagicLink(t, user.Email, true)
+	c := newTestClient(t)
+
+	// opening the link does not use it, so mail scanners cannot
+	res := c.get("/login/email/confirm?token=" + token)
+	if res.StatusCode != http.StatusOK || c.userID() != 0 {
+		t.Fatalf("open link: status %d, logged in as %d", res.StatusCode, c.userID())
+	}
+
+	res = c.post("/login/email/confirm", url.Values{"token": {token}})
+	if res.StatusCode != http.StatusFound || c.userID() != user.ID {
+		t.Fatalf("confirm: status %d, logged in as %d", res.StatusCode, c.userID())
+	}
+	if cookie := c.cookies["session_token"]; cookie.Expires.Before(time.Now().Add(2 * durationDay)) {
+		t.Errorf("remembered session expires at %s", cookie.Expires)
+	}
+
+	var stored User
+	db.First(&stored, user.ID)
+	if !stored.Verified {
+		t.Error("the link did not verify the address")
+	}
+
+	again := newTestClient(t)
+	res = again.post("/login/email/confirm", url.Values{"token": {token}})
+	if res.StatusCode != http.StatusUnauthorized || again.userID() != 0 {
+		t.Errorf("second use: status %d", res.StatusCode)
+	}
+	if res := again.get("/login/email/confirm?token=" + token); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("open a used link: status %d", res.StatusCode)
+	}
+}
+
+func TestMagicLinkConfirmLockedOut(t *testing.T) {
+	enableMagicLinks(t)
+	user := createTestUser(t, "magiclocked")
+	token := requestMagicLink(t, user.Email, false)
+	lockAccount(user)
+
+	c := newTestClient(t)
+	res := c.post("/login/email/confirm", url.Values{"token": {token}})
+	if res.StatusCode != http.StatusTooManyRequests || c.userID() != 0 {
+		t.Errorf("locked account: status %d, logged in as %d", res.StatusCode, c.userID())
+	}
+}
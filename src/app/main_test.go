@@ -0,0 +1,167 @@
+package app
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/birabittoh/auth-boilerplate/src/auth"
+	"github.com/birabittoh/auth-boilerplate/src/email"
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+const testPassword = "Plum-Kettle-Orbit7"
+
+func TestMain(tm *testing.M) {
+	// templates and locales are read from the working directory
+	err := os.Chdir("../..")
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	log.SetOutput(io.Discard)
+	db, err = gorm.Open(sqlite.Open("file:test?mode=memory&cache=shared&_pragma=foreign_keys(1)"), &gorm.Config{Logger: logger.Discard})
+	if err != nil {
+		log.Fatal(err)
+	}
+	err = migrate()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	g = auth.NewAuth("test pepper", auth.DefaultMaxPasswordLength)
+	g.SigningKey = []byte("test signing key")
+	rateLimitRequests = 0
+	lockoutAttempts = 0
+
+	loadTranslations()
+	loadTemplates()
+
+	// emails stay in the outbox, the worker is not started
+	m = email.NewClient(email.Sender{Address: "app@example.com"}, email.NewStdout())
+
+	os.Exit(tm.Run())
+}
+
+// Creates a user with testPassword; names must be unique across the package's tests
+func createTestUser(t *testing.T, username string) User {
+	t.Helper()
+
+	hash, err := g.HashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user := User{Username: username, Email: username + "@example.com", PasswordHash: hash, Verified: true}
+	err = db.Create(&user).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return user
+}
+
+// A browser for the app's router: it keeps cookies and sends the CSRF token with every POST
+type testClient struct {
+	t       *testing.T
+	handler http.Handler
+	cookies map[string]*http.Cookie
+}
+
+func newTestClient(t *testing.T) *testClient {
+	return &testClient{
+		t:       t,
+		handler: newRouter(),
+		cookies: map[string]*http.Cookie{csrfCookie: {Name: csrfCookie, Value: "test-" + t.Name()}},
+	}
+}
+
+// Starts a session for the user, as if they had logged in
+func (c *testClient) login(user User) {
+	token := fmt.Sprintf("test-session-%d-%d", user.ID, time.Now().UnixNano())
+	startSession(token, user.ID, time.Hour)
+	c.cookies["session_token"] = &http.Cookie{Name: "session_token", Value: token}
+}
+
+func (c *testClient) request(req *http.Request) *http.Response {
+	c.t.Helper()
+
+	for _, cookie := range c.cookies {
+		req.AddCookie(cookie)
+	}
+	if req.Method == http.MethodPost && req.Header.Get(csrfHeader) == "" {
+		req.Header.Set(csrfHeader, g.CSRFToken(csrfBinding(req)))
+	}
+
+	rec := httptest.NewRecorder()
+	c.handler.ServeHTTP(rec, req)
+
+	res := rec.Result()
+	for _, cookie := range res.Cookies() {
+		if cookie.MaxAge < 0 || (cookie.Value == "" && cookie.Expires.Before(time.Now())) {
+			delete(c.cookies, cookie.Name)
+		} else {
+			c.cookies[cookie.Name] = cookie
+		}
+	}
+	return res
+}
+
+func (c *testClient) get(target string) *http.Response {
+	return c.request(httptest.NewRequest(http.MethodGet, target, nil))
+}
+
+func (c *testClient) post(target string, form url.Values) *http.Response {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.request(req)
+}
+
+func (c *testClient) postJSON(target string, body string) *http.Response {
+	req := httptest.NewRequest(http.MethodPost, target, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return c.request(req)
+}
+
+// Returns the ID of the user the client is logged in as, 0 if none
+func (c *testClient) userID() uint {
+	cookie, ok := c.cookies["session_token"]
+	if !ok {
+		return 0
+	}
+
+	userID, err := ks.Get("session:" + cookie.Value)
+	if err != nil {
+		return 0
+	}
+	return *userID
+}
+
+func readBody(t *testing.T, res *http.Response) string {
+	t.Helper()
+
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(body)
+}
+
+// Returns the emails queued for the address, oldest first
+func queuedEmails(t *testing.T, to string) (emails []OutboxEmail) {
+	t.Helper()
+
+	err := db.Where(&OutboxEmail{To: to}).Order("id").Find(&emails).Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	return
+}
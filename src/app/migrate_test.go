@@ -0,0 +1,65 @@
+package app
+
+import "testing"
+
+func TestMigrateBackfillsVerified(t *testing.T) {
+	existing := createTestUser(t, "migrateexisting")
+	db.Model(&existing).Update("verified", false)
+
+	err := db.Exec("ALTER TABLE users DROP COLUMN verified").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var user User
+	db.First(&user, existing.ID)
+	if !user.Verified {
+		t.Error("users that existed before verification are not verified")
+	}
+
+	// later runs leave unverified users alone
+	newcomer := createTestUser(t, "migratenewcomer")
+	db.Model(&newcomer).Update("verified", false)
+	err = migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user = User{}
+	db.First(&user, newcomer.ID)
+	if user.ID == 0 || user.Verified {
+		t.Error("migrate verified a new user")
+	}
+}
+
+func TestMigrateKeepsWebhookMethods(t *testing.T) {
+	user := createTestUser(t, "migratehook")
+	habit := Habit{UserID: user.ID, Name: "Water", Days: 1}
+	db.Create(&habit)
+
+	err := db.Exec("ALTER TABLE webhooks ADD COLUMN post_only numeric").Error
+	if err != nil {
+		t.Fatal(err)
+	}
+	db.Exec("INSERT INTO webhooks (habit_id, token, allow_get, post_only) VALUES (?, 'migrate-get', false, false), (?, 'migrate-post', false, true)", habit.ID, habit.ID)
+
+	err = migrate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Migrator().HasColumn(&Webhook{}, "post_only") {
+		t.Error("post_only was not dropped")
+	}
+
+	for token, allowGet := range map[string]bool{"migrate-get": true, "migrate-post": false} {
+		var webhook Webhook
+		db.Where("token = ?", token).First(&webhook)
+		if webhook.ID == 0 || webhook.AllowGet != allowGet {
+			t.Errorf("%s: allow GET %t, want %t", token, webhook.AllowGet, allowGet)
+		}
+	}
+}
@@ -0,0 +1,387 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// Single sign-on through an OpenID Connect provider
+type oidcClient struct {
+	Name     string
+	issuer   string
+	config   oauth2.Config
+	verifier *oidc.IDTokenVerifier
+}
+
+type oidcClaims struct {
+	Subject           string `json:"sub"`
+	Email             string `json:"email"`
+	EmailVerified     bool   `json:"email_verified"`
+	PreferredUsername string `json:"preferred_username"`
+	Nonce             string `json:"nonce"`
+}
+
+const (
+	oidcCookie   = "oidc_state"
+	oidcPurpose  = "oidc-login"
+	oidcDuration = 10 * time.Minute
+)
+
+func loadOIDCConfig() *oidcClient {
+	issuer := os.Getenv("APP_OIDC_ISSUER")
+	clientID := os.Getenv("APP_OIDC_CLIENT_ID")
+	if issuer == "" || clientID == "" {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	provider, err := oidc.NewProvider(ctx, issuer)
+	if err != nil {
+		log.Println("Could not init OIDC provider:", err)
+		return nil
+	}
+
+	name := os.Getenv("APP_OIDC_NAME")
+	if name == "" {
+		name = "SSO"
+	}
+
+	scopes := []string{oidc.ScopeOpenID, "profile", "email"}
+	if s := os.Getenv("APP_OIDC_SCOPES"); s != "" {
+		scopes = strings.Fields(s)
+	}
+
+	return &oidcClient{
+		Name:   name,
+		issuer: issuer,
+		config: oauth2.Config{
+			ClientID:     clientID,
+			ClientSecret: os.Getenv("APP_OIDC_CLIENT_SECRET"),
+			Endpoint:     provider.Endpoint(),
+			RedirectURL:  baseUrl + "/oidc/callback",
+			Scopes:       scopes,
+		},
+		verifier: provider.Verifier(&oidc.Config{ClientID: clientID}),
+	}
+}
+
+// Derives a valid, unused username from the provider's claims
+func usernameFromClaims(claims oidcClaims) (string, error) {
+	base := claims.PreferredUsername
+	if base == "" {
+		base, _, _ = strings.Cut(claims.Email, "@")
+	}
+
+	var b strings.Builder
+	for _, c := range strings.ToLower(base) {
+		if validUsername.MatchString(string(c)) {
+			b.WriteRune(c)
+		}
+	}
+
+	base = b.String()
+	if len(base) > maxUsernameLength {
+		base = base[:maxUsernameLength]
+	}
+	for len(base) < minUsernameLength {
+		base += "_"
+	}
+
+	username := base
+	for i := 0; i < 10; i++ {
+		_, err := getUserByName(username, 0)
+		if err != nil {
+			return sanitizeUsername(username)
+		}
+
+		suffix := strconv.Itoa(rand.IntN(1000))
+		username = base[:min(len(base), maxUsernameLength-len(suffix))] + suffix
+	}
+	return "", errors.New("could not find a free username")
+}
+
+var (
+	errIdentityTaken = errors.New("identity is linked to another account")
+	errEmailTaken    = errors.New("email belongs to an existing account")
+)
+
+// Returns the user linked to the identity, creating an account for new identities if registration is open.
+// New identities are linked to the account with the same email only when both the provider and
+// this instance verified it; otherwise the owner has to link the identity from the settings.
+// Accounts created here get the language the user was browsing in.
+func getOIDCUser(r *http.Request, claims oidcClaims, language string) (user User, err error) {
+	var identity Identity
+	err = db.Model(&Identity{}).Where(&Identity{Issuer: o.issuer, Subject: claims.Subject}).First(&identity).Error
+	if err == nil {
+		err = db.First(&user, identity.UserID).Error
+		return
+	}
+
+	address, err := sanitizeEmail(claims.Email)
+	if err != nil {
+		return
+	}
+
+	if db.Where("email = ?", address).First(&user).Error == nil {
+		if !claims.EmailVerified || !user.Verified {
+			return User{}, errEmailTaken
+		}
+
+		err = linkIdentity(user, claims)
+		if err == nil {
+			audit(r, user.ID, auditIdentityLinked, address)
+		}
+		return
+	}
+
+	if getRegistrationMode() != registrationOpen {
+		return user, errors.New("registration is disabled")
+	}
+
+	user.Username, err = usernameFromClaims(claims)
+	if err != nil {
+		return
+	}
+
+	user.Email = address
+	user.Verified = claims.EmailVerified
+	user.Language = language
+	err = db.Create(&user).Error
+	if err != nil {
+		return
+	}
+
+	if !user.Verified {
+		sendVerificationEmail(user)
+	}
+
+	err = linkIdentity(user, claims)
+	return
+}
+
+func linkIdentity(user User, claims oidcClaims) error {
+	var identity Identity
+	err := db.Where(&Identity{Issuer: o.issuer, Subject: claims.Subject}).First(&identity).Error
+	if err == nil {
+		if identity.UserID != user.ID {
+			return errIdentityTaken
+		}
+		return nil
+	}
+
+	address, _ := sanitizeEmail(claims.Email)
+	return db.Create(&Identity{
+		UserID:  user.ID,
+		Issuer:  o.issuer,
+		Subject: claims.Subject,
+		Email:   address,
+	}).Error
+}
+
+// Sends the browser to the provider; a non-zero linkUserID links the identity to that account instead of logging in
+func startOIDC(w http.ResponseWriter, r *http.Request, remember string, linkUserID uint) {
+	if o == nil {
+		httpError(w, r, "Single sign-on is not available on this instance.", http.StatusNotFound)
+		return
+	}
+
+	state, err := g.GenerateRandomToken(16)
+	if err != nil {
+		httpError(w, r, "Could not generate state.", http.StatusInternalServerError)
+		return
+	}
+
+	nonce, err := g.GenerateRandomToken(16)
+	if err != nil {
+		httpError(w, r, "Could not generate nonce.", http.StatusInternalServerError)
+		return
+	}
+
+	verifier := oauth2.GenerateVerifier()
+
+	subject := strings.Join([]string{state, nonce, verifier, remember, strconv.FormatUint(uint64(linkUserID), 10)}, ":")
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcCookie,
+		Value:    g.SignToken(oidcPurpose, subject, time.Now().Add(oidcDuration)),
+		Path:     "/oidc",
+		MaxAge:   int(oidcDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	url := o.config.AuthCodeURL(state, oidc.Nonce(nonce), oauth2.S256ChallengeOption(verifier))
+	http.Redirect(w, r, url, http.StatusFound)
+}
+
+func getOIDCLoginHandler(w http.ResponseWriter, r *http.Request) {
+	startOIDC(w, r, r.URL.Query().Get("remember"), 0)
+}
+
+func postOIDCLinkHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	startOIDC(w, r, "", user.ID)
+}
+
+func postOIDCUnlinkHandler(w http.ResponseWriter, r *http.Request) {
+	id := getID(r)
+	if id == 0 {
+		httpError(w, r, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var count int64
+	db.Model(&Identity{}).Where(&Identity{UserID: user.ID}).Count(&count)
+	if user.PasswordHash == "" && count <= 1 {
+		httpError(w, r, "Set a password before unlinking your only way to login.", http.StatusBadRequest)
+		return
+	}
+
+	res := db.Unscoped().Delete(&Identity{}, "id = ? AND user_id = ?", id, user.ID)
+	if res.RowsAffected > 0 {
+		audit(r, user.ID, auditIdentityUnlinked, "")
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusFound)
+}
+
+// Verifies the provider's response and returns its claims, along with the remember flag and the account to link, if any
+func exchangeOIDCCode(w http.ResponseWriter, r *http.Request) (claims oidcClaims, remember string, linkUserID uint, ok bool) {
+	cookie, err := r.Cookie(oidcCookie)
+	if err != nil {
+		httpError(w, r, "Login expired, please try again.", http.StatusBadRequest)
+		return
+	}
+	http.SetCookie(w, &http.Cookie{Name: oidcCookie, Path: "/oidc", MaxAge: -1})
+
+	subject, err := g.VerifyToken(oidcPurpose, cookie.Value)
+	parts := strings.Split(subject, ":")
+	if err != nil || len(parts) != 5 || parts[0] != r.URL.Query().Get("state") {
+		httpError(w, r, "Login expired, please try again.", http.StatusBadRequest)
+		return
+	}
+	nonce, verifier := parts[1], parts[2]
+	remember = parts[3]
+	link, _ := strconv.ParseUint(parts[4], 10, 64)
+
+	if e := r.URL.Query().Get("error"); e != "" {
+		http.Error(w, localizer(r).T("Single sign-on failed: %s", e), http.StatusUnauthorized)
+		return
+	}
+
+	token, err := o.config.Exchange(r.Context(), r.URL.Query().Get("code"), oauth2.VerifierOption(verifier))
+	if err != nil {
+		log.Println("Could not exchange OIDC code:", err)
+		httpError(w, r, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	rawIDToken, found := token.Extra("id_token").(string)
+	if !found {
+		httpError(w, r, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	idToken, err := o.verifier.Verify(r.Context(), rawIDToken)
+	if err != nil {
+		log.Println("Could not verify OIDC token:", err)
+		httpError(w, r, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	err = idToken.Claims(&claims)
+	if err != nil || claims.Nonce != nonce || claims.Subject == "" {
+		httpError(w, r, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	return claims, remember, uint(link), true
+}
+
+func getOIDCCallbackHandler(w http.ResponseWriter, r *http.Request) {
+	if o == nil {
+		httpError(w, r, "Single sign-on is not available on this instance.", http.StatusNotFound)
+		return
+	}
+
+	claims, remember, linkUserID, ok := exchangeOIDCCode(w, r)
+	if !ok {
+		return
+	}
+
+	if linkUserID != 0 {
+		userID, err := readSessionCookie(r)
+		if err != nil || *userID != linkUserID {
+			httpError(w, r, "Login expired, please try again.", http.StatusUnauthorized)
+			return
+		}
+
+		var user User
+		db.First(&user, linkUserID)
+		err = linkIdentity(user, claims)
+		if errors.Is(err, errIdentityTaken) {
+			httpError(w, r, "This identity is already linked to another account.", http.StatusConflict)
+			return
+		}
+		if err != nil {
+			log.Printf("Could not link OIDC subject %s: %v", claims.Subject, err)
+			httpError(w, r, "Could not link identity.", http.StatusInternalServerError)
+			return
+		}
+
+		audit(r, user.ID, auditIdentityLinked, claims.Email)
+		http.Redirect(w, r, "/settings", http.StatusFound)
+		return
+	}
+
+	user, err := getOIDCUser(r, claims, localizer(r).Language())
+	if errors.Is(err, errEmailTaken) {
+		http.Error(w, localizer(r).T("An account with this email already exists: login to it and link %s from the settings.", o.Name), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		log.Printf("Could not get user for OIDC subject %s: %v", claims.Subject, err)
+		httpError(w, r, "There is no account for this identity.", http.StatusForbidden)
+		return
+	}
+
+	if user.Disabled {
+		httpError(w, r, "This account has been disabled.", http.StatusForbidden)
+		return
+	}
+
+	if requireVerification && !user.Verified {
+		executeTemplate(w, r, http.StatusForbidden, "auth-verify_email.tmpl", map[string]interface{}{"Email": user.Email})
+		return
+	}
+
+	if hasTwoFactor(user) {
+		startTwoFactorLogin(w, r, user, remember == "on")
+		return
+	}
+
+	login(w, r, user.ID, remember == "on", "single sign-on")
+	http.Redirect(w, r, "/habits", http.StatusFound)
+}
@@ -0,0 +1,364 @@
+package app
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A minimal OpenID Connect provider: the test decides who logs in by calling authorize
+type mockProvider struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	claims    map[string]interface{}
+	challenge string
+}
+
+const mockClientID = "wellbinge-test"
+
+func newMockProvider(t *testing.T) *mockProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	p := &mockProvider{key: key, codes: map[string]mockGrant{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"issuer":                                p.URL,
+			"authorization_endpoint":                p.URL + "/authorize",
+			"token_endpoint":                        p.URL + "/token",
+			"jwks_uri":                              p.URL + "/jwks",
+			"id_token_signing_alg_values_supported": []string{"RS256"},
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kty": "RSA",
+			"alg": "RS256",
+			"use": "sig",
+			"kid": "test",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("POST /token", p.token)
+
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+// Plays the provider's login page: returns the code the browser would bring back to the callback
+func (p *mockProvider) authorize(t *testing.T, location string, claims map[string]interface{}) string {
+	t.Helper()
+
+	u, err := url.Parse(location)
+	if err != nil || !strings.HasPrefix(location, p.URL+"/authorize") {
+		t.Fatalf("not redirected to the provider: %q", location)
+	}
+
+	q := u.Query()
+	if q.Get("client_id") != mockClientID || q.Get("code_challenge_method") != "S256" {
+		t.Fatalf("bad authorization request: %v", q)
+	}
+
+	claims["nonce"] = q.Get("nonce")
+	code := "code-" + q.Get("state")
+
+	p.mu.Lock()
+	p.codes[code] = mockGrant{claims: claims, challenge: q.Get("code_challenge")}
+	p.mu.Unlock()
+
+	return "/oidc/callback?" + url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+}
+
+func (p *mockProvider) token(w http.ResponseWriter, r *http.Request) {
+	p.mu.Lock()
+	grant, ok := p.codes[r.FormValue("code")]
+	delete(p.codes, r.FormValue("code"))
+	p.mu.Unlock()
+
+	sum := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims := map[string]interface{}{
+		"iss": p.URL,
+		"aud": mockClientID,
+		"iat": time.Now().Unix(),
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range grant.claims {
+		claims[k] = v
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"access_token": "access",
+		"token_type":   "Bearer",
+		"expires_in":   3600,
+		"id_token":     p.sign(claims),
+	})
+}
+
+func (p *mockProvider) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	sum := sha256.Sum256([]byte(signed))
+	signature, _ := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func setupOIDC(t *testing.T) *mockProvider {
+	p := newMockProvider(t)
+	t.Setenv("APP_OIDC_ISSUER", p.URL)
+	t.Setenv("APP_OIDC_CLIENT_ID", mockClientID)
+
+	o = loadOIDCConfig()
+	if o == nil {
+		t.Fatal("could not load the OIDC configuration")
+	}
+	t.Cleanup(func() { o = nil })
+	return p
+}
+
+// Runs a whole single sign-on round trip, starting from the response that redirected to the provider
+func ssoRoundTrip(t *testing.T, c *testClient, p *mockProvider, start *http.Response, claims map[string]interface{}) *http.Response {
+	t.Helper()
+
+	if start.StatusCode != http.StatusFound {
+		t.Fatalf("starting single sign-on: status %d", start.StatusCode)
+	}
+	return c.get(p.authorize(t, start.Header.Get("Location"), claims))
+}
+
+func TestOIDCRegistersNewIdentity(t *testing.T) {
+	p := setupOIDC(t)
+	c := newTestClient(t)
+
+	claims := map[string]interface{}{"sub": "new-1", "email": "Newcomer@Example.com", "email_verified": true, "preferred_username": "newcomer"}
+	res := ssoRoundTrip(t, c, p, c.get("/oidc/login"), claims)
+	if res.StatusCode != http.StatusFound || res.Header.Get("Location") != "/habits" {
+		t.Fatalf("callback: status %d, location %q", res.StatusCode, res.Header.Get("Location"))
+	}
+
+	var user User
+	if err := db.Where("email = ?", "newcomer@example.com").First(&user).Error; err != nil {
+		t.Fatal("no account was created:", err)
+	}
+	if !user.Verified || user.Username != "newcomer" || user.PasswordHash != "" {
+		t.Errorf("unexpected account: %+v", user)
+	}
+	if c.userID() != user.ID {
+		t.Errorf("logged in as %d, want %d", c.userID(), user.ID)
+	}
+
+	// the same identity logs into the same account
+	c2 := newTestClient(t)
+	ssoRoundTrip(t, c2, p, c2.get("/oidc/login"), map[string]interface{}{"sub": "new-1", "email": "changed@example.com"})
+	if c2.userID() != user.ID {
+		t.Errorf("second login as %d, want %d", c2.userID(), user.ID)
+	}
+}
+
+func TestOIDCLinksByVerifiedEmail(t *testing.T) {
+	p := setupOIDC(t)
+	user := createTestUser(t, "emaillinked")
+	c := newTestClient(t)
+
+	claims := map[string]interface{}{"sub": "by-email", "email": user.Email, "email_verified": true}
+	res := ssoRoundTrip(t, c, p, c.get("/oidc/login"), claims)
+	if res.StatusCode != http.StatusFound || c.userID() != user.ID {
+		t.Fatalf("callback: status %d, logged in as %d", res.StatusCode, c.userID())
+	}
+
+	var identity Identity
+	if err := db.Where("subject = ?", "by-email").First(&identity).Error; err != nil || identity.UserID != user.ID {
+		t.Errorf("identity linked to %d, %v", identity.UserID, err)
+	}
+}
+
+func TestOIDCDoesNotLinkUnverifiedEmail(t *testing.T) {
+	p := setupOIDC(t)
+	victim := createTestUser(t, "victim")
+	unverified := createTestUser(t, "victimunverified")
+	db.Model(&unverified).Update("verified", false)
+
+	tests := []struct {
+		subject  string
+		email    string
+		verified bool
+	}{
+		{"attacker", victim.Email, false},    // the provider did not verify it
+		{"squatter", unverified.Email, true}, // the account never proved it owns the address
+	}
+
+	for _, tt := range tests {
+		c := newTestClient(t)
+		claims := map[string]interface{}{"sub": tt.subject, "email": tt.email, "email_verified": tt.verified}
+		res := ssoRoundTrip(t, c, p, c.get("/oidc/login"), claims)
+		if res.StatusCode != http.StatusConflict {
+			t.Errorf("%s: status %d, want %d", tt.subject, res.StatusCode, http.StatusConflict)
+		}
+		if c.userID() != 0 {
+			t.Errorf("%s: logged in as %d", tt.subject, c.userID())
+		}
+
+		var count int64
+		db.Model(&Identity{}).Where("subject = ?", tt.subject).Count(&count)
+		if count != 0 {
+			t.Errorf("%s: the identity was linked to the existing account", tt.subject)
+		}
+	}
+}
+
+func TestOIDCUnverifiedEmail(t *testing.T) {
+	p := setupOIDC(t)
+	previous := requireVerification
+	requireVerification = true
+	t.Cleanup(func() { requireVerification = previous })
+
+	c := newTestClient(t)
+	claims := map[string]interface{}{"sub": "unverified-1", "email": "oidcunverified@example.com", "preferred_username": "oidcunver"}
+	res := ssoRoundTrip(t, c, p, c.get("/oidc/login"), claims)
+	if res.StatusCode != http.StatusForbidden || c.userID() != 0 {
+		t.Fatalf("callback: status %d, logged in as %d", res.StatusCode, c.userID())
+	}
+	if cc := res.Header.Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("Cache-Control %q, want no-store", cc)
+	}
+}
+
+func TestOIDCLinkFromSettings(t *testing.T) {
+	p := setupOIDC(t)
+	user := createTestUser(t, "linker")
+	c := newTestClient(t)
+	c.login(user)
+
+	claims := map[string]interface{}{"sub": "linked-1", "email": "elsewhere@example.com", "email_verified": true}
+	res := ssoRoundTrip(t, c, p, c.post("/oidc/link", nil), claims)
+	if res.StatusCode != http.StatusFound || res.Header.Get("Location") != "/settings" {
+		t.Fatalf("link callback: status %d, location %q", res.StatusCode, res.Header.Get("Location"))
+	}
+
+	var identity Identity
+	if err := db.Where("subject = ?", "linked-1").First(&identity).Error; err != nil || identity.UserID != user.ID {
+		t.Fatalf("identity not linked to %d: %+v, %v", user.ID, identity, err)
+	}
+
+	c2 := newTestClient(t)
+	ssoRoundTrip(t, c2, p, c2.get("/oidc/login"), map[string]interface{}{"sub": "linked-1"})
+	if c2.userID() != user.ID {
+		t.Errorf("logged in as %d, want %d", c2.userID(), user.ID)
+	}
+
+	if body := readBody(t, c.get("/settings")); !strings.Contains(body, "elsewhere@example.com") {
+		t.Error("the settings do not list the linked identity")
+	}
+
+	// another account cannot claim the same identity
+	other := createTestUser(t, "linker2")
+	c3 := newTestClient(t)
+	c3.login(other)
+	res = ssoRoundTrip(t, c3, p, c3.post("/oidc/link", nil), map[string]interface{}{"sub": "linked-1"})
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("linking a taken identity: status %d, want %d", res.StatusCode, http.StatusConflict)
+	}
+
+	// the link has to finish in the session that started it
+	c4 := newTestClient(t)
+	c4.login(other)
+	start := c4.post("/oidc/link", nil)
+	delete(c4.cookies, "session_token")
+	res = ssoRoundTrip(t, c4, p, start, map[string]interface{}{"sub": "linked-2"})
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("linking without the session: status %d, want %d", res.StatusCode, http.StatusUnauthorized)
+	}
+
+	c.post(fmt.Sprintf("/oidc/%d/unlink", identity.ID), nil)
+	if db.First(&Identity{}, identity.ID).Error == nil {
+		t.Error("the identity was not unlinked")
+	}
+}
+
+func TestOIDCKeepsLastLogin(t *testing.T) {
+	setupOIDC(t)
+	user := User{Username: "ssoonly", Email: "ssoonly@example.com", Verified: true}
+	db.Create(&user)
+	identity := Identity{UserID: user.ID, Issuer: o.issuer, Subject: "ssoonly"}
+	db.Create(&identity)
+
+	c := newTestClient(t)
+	c.login(user)
+	if res := c.post(fmt.Sprintf("/oidc/%d/unlink", identity.ID), nil); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("unlinking the only way to login: status %d, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+}
+
+func TestOIDCRequiresSecondFactor(t *testing.T) {
+	p := setupOIDC(t)
+	user := createTestUser(t, "sso2fa")
+	user.TOTPSecret = "JBSWY3DPEHPK3PXP"
+	db.Save(&user)
+	db.Create(&Identity{UserID: user.ID, Issuer: p.URL, Subject: "sso2fa"})
+
+	c := newTestClient(t)
+	res := ssoRoundTrip(t, c, p, c.get("/oidc/login"), map[string]interface{}{"sub": "sso2fa"})
+	if res.StatusCode != http.StatusOK || !strings.Contains(readBody(t, res), `name="token"`) {
+		t.Fatalf("callback: status %d, want the two-factor form", res.StatusCode)
+	}
+	if c.userID() != 0 {
+		t.Errorf("logged in as %d before the second factor", c.userID())
+	}
+}
+
+func TestOIDCRejectsBadResponses(t *testing.T) {
+	p := setupOIDC(t)
+	c := newTestClient(t)
+
+	// a state that does not match the cookie
+	start := c.get("/oidc/login")
+	callback := p.authorize(t, start.Header.Get("Location"), map[string]interface{}{"sub": "x"})
+	if res := c.get(strings.Replace(callback, "state=", "state=x", 1)); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("bad state: status %d", res.StatusCode)
+	}
+
+	// a token issued for another login
+	start = c.get("/oidc/login")
+	callback = p.authorize(t, start.Header.Get("Location"), map[string]interface{}{"sub": "x"})
+	code, _ := url.ParseQuery(strings.TrimPrefix(callback, "/oidc/callback?"))
+	p.mu.Lock()
+	p.codes[code.Get("code")].claims["nonce"] = "replayed"
+	p.mu.Unlock()
+	if res := c.get(callback); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("bad nonce: status %d", res.StatusCode)
+	}
+
+	if c.userID() != 0 {
+		t.Errorf("logged in as %d", c.userID())
+	}
+}
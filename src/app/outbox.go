@@ -0,0 +1,259 @@
+package app
+
+import (
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/birabittoh/auth-boilerplate/src/email"
+)
+
+// OutboxEmail is an email waiting to be delivered by the outbox worker
+type OutboxEmail struct {
+	ID          uint `gorm:"primarykey"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	UserID      uint   `gorm:"index"` // zero for emails that outlive their account
+	To          string // comma-separated
+	Subject     string
+	Body        string
+	HTML        string
+	Sensitive   bool   // carries a login or confirmation token
+	Status      string `gorm:"index"`
+	Attempts    uint
+	NextAttempt time.Time
+	LastError   string
+	SentAt      *time.Time
+}
+
+type outboxStats struct {
+	Pending int64
+	Sent    int64
+	Failed  int64
+}
+
+const (
+	outboxPending = "pending"
+	outboxSent    = "sent"
+	outboxFailed  = "failed" // gave up after outboxMaxAttempts, kept for admins
+
+	outboxInterval   = 30 * time.Second
+	outboxBatchSize  = 20
+	outboxBaseDelay  = time.Minute
+	outboxMaxDelay   = 6 * time.Hour
+	outboxSentMaxAge = 7 * 24 * time.Hour
+)
+
+var (
+	outboxMaxAttempts uint = 8
+
+	outboxWake = make(chan struct{}, 1)
+)
+
+func loadOutboxConfig() {
+	s := os.Getenv("APP_EMAIL_MAX_ATTEMPTS")
+	if s == "" {
+		return
+	}
+
+	n, err := strconv.ParseUint(s, 10, 32)
+	if err != nil || n == 0 {
+		log.Fatal("Invalid APP_EMAIL_MAX_ATTEMPTS: must be a positive number")
+	}
+	outboxMaxAttempts = uint(n)
+}
+
+// Queues an email for the outbox worker, so requests never wait for the mail server
+func enqueueEmail(userID uint, mail email.Email, sensitive bool) error {
+	entry := OutboxEmail{
+		UserID:      userID,
+		To:          strings.Join(mail.To, ","),
+		Subject:     mail.Subject,
+		Body:        mail.Body,
+		HTML:        mail.HTML,
+		Sensitive:   sensitive,
+		Status:      outboxPending,
+		NextAttempt: time.Now(),
+	}
+
+	err := db.Create(&entry).Error
+	if err == nil {
+		wakeOutbox()
+	}
+	return err
+}
+
+// Queues notification emails of a user, for notify.Email
+type outboxMailer struct {
+	userID uint
+}
+
+func (o outboxMailer) Send(mail email.Email) error {
+	return sendEmail(o.userID, mail, false)
+}
+
+func wakeOutbox() {
+	select {
+	case outboxWake <- struct{}{}:
+	default: // the worker is already awake
+	}
+}
+
+// Delay before the next attempt, doubling after each failure
+func outboxBackoff(attempts uint) time.Duration {
+	delay := outboxBaseDelay
+	for i := uint(1); i < attempts && delay < outboxMaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, outboxMaxDelay)
+}
+
+func startOutbox() {
+	ticker := time.NewTicker(outboxInterval)
+	for {
+		deliverOutbox()
+		cleanOutbox()
+
+		select {
+		case <-ticker.C:
+		case <-outboxWake:
+		}
+	}
+}
+
+// Sends the due emails in batches; the cursor on id makes sure no email is tried twice in a run
+func deliverOutbox() {
+	var lastID uint
+	for {
+		var entries []OutboxEmail
+		err := db.Model(&OutboxEmail{}).
+			Where("status = ? AND next_attempt <= ? AND id > ?", outboxPending, time.Now(), lastID).
+			Order("id").Limit(outboxBatchSize).Find(&entries).Error
+		if err != nil {
+			log.Println("Could not read the email outbox:", err)
+			return
+		}
+
+		if len(entries) == 0 {
+			return
+		}
+
+		for _, entry := range entries {
+			lastID = entry.ID
+			err = deliverOutboxEmail(entry)
+			if err != nil {
+				// the same email would be sent again at every run, wait for the database
+				log.Printf("Could not update email %d in the outbox: %v", entry.ID, err)
+				return
+			}
+		}
+	}
+}
+
+// Tries to send an email, returning an error if its new state could not be saved
+func deliverOutboxEmail(entry OutboxEmail) error {
+	err := m.Send(email.Email{
+		To:      strings.Split(entry.To, ","),
+		Subject: entry.Subject,
+		Body:    entry.Body,
+		HTML:    entry.HTML,
+	})
+
+	if err == nil {
+		now := time.Now()
+		return db.Model(&entry).Updates(map[string]interface{}{
+			"status":     outboxSent,
+			"attempts":   entry.Attempts + 1,
+			"sent_at":    &now,
+			"last_error": "",
+			"body":       "", // drop links and tokens once delivered
+			"html":       "",
+		}).Error
+	}
+
+	entry.Attempts++
+	entry.LastError = err.Error()
+	if entry.Attempts >= outboxMaxAttempts {
+		entry.Status = outboxFailed
+		log.Printf("Giving up on email %d after %d attempts: %v", entry.ID, entry.Attempts, err)
+
+		// admins can read failed emails, but not the tokens in them
+		if entry.Sensitive {
+			entry.Body = ""
+			entry.HTML = ""
+		}
+	} else {
+		entry.NextAttempt = time.Now().Add(outboxBackoff(entry.Attempts))
+	}
+
+	return db.Model(&entry).Select("status", "attempts", "last_error", "next_attempt", "body", "html").Updates(&entry).Error
+}
+
+func cleanOutbox() {
+	err := db.Where("status = ? AND sent_at < ?", outboxSent, time.Now().Add(-outboxSentMaxAge)).Delete(&OutboxEmail{}).Error
+	if err != nil {
+		log.Println("Could not clean the email outbox:", err)
+	}
+}
+
+func getOutboxStats() (stats outboxStats) {
+	db.Model(&OutboxEmail{}).Where("status = ?", outboxPending).Count(&stats.Pending)
+	db.Model(&OutboxEmail{}).Where("status = ?", outboxSent).Count(&stats.Sent)
+	db.Model(&OutboxEmail{}).Where("status = ?", outboxFailed).Count(&stats.Failed)
+	return
+}
+
+func getFailedEmails() (entries []OutboxEmail, err error) {
+	err = db.Model(&OutboxEmail{}).Where("status = ?", outboxFailed).Order("id DESC").Find(&entries).Error
+	return
+}
+
+func getOutboxEmailHelper(w http.ResponseWriter, r *http.Request) (entry OutboxEmail, err error) {
+	id := getID(r)
+	if id == 0 {
+		httpError(w, r, "bad request", http.StatusBadRequest)
+		return entry, errors.New("no id")
+	}
+
+	err = db.Model(&OutboxEmail{}).Where("id = ? AND status = ?", id, outboxFailed).First(&entry).Error
+	if err != nil {
+		httpError(w, r, "not found", http.StatusNotFound)
+	}
+	return
+}
+
+func postAdminOutboxRetryHandler(w http.ResponseWriter, r *http.Request) {
+	entry, err := getOutboxEmailHelper(w, r)
+	if err != nil {
+		return
+	}
+
+	if entry.Sensitive {
+		httpError(w, r, "This email was redacted and cannot be sent again.", http.StatusConflict)
+		return
+	}
+
+	db.Model(&entry).Updates(map[string]interface{}{
+		"status":       outboxPending,
+		"attempts":     0,
+		"next_attempt": time.Now(),
+	})
+	wakeOutbox()
+
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
+
+func postAdminOutboxDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	entry, err := getOutboxEmailHelper(w, r)
+	if err != nil {
+		return
+	}
+
+	db.Delete(&entry)
+
+	http.Redirect(w, r, "/admin", http.StatusFound)
+}
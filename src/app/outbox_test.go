@@ -0,0 +1,162 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/birabittoh/auth-boilerplate/src/email"
+	"github.com/birabittoh/auth-boilerplate/src/notify"
+	"gorm.io/gorm"
+)
+
+type failingTransport struct{}
+
+func (failingTransport) Send(from string, to []string, msg []byte) error {
+	return errors.New("connection refused")
+}
+
+func TestOutboxRedactsFailedTokens(t *testing.T) {
+	user := createTestUser(t, "outboxredact")
+	err := sendPasswordReset(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sendLockoutEmail(user, "203.0.113.1")
+
+	emails := queuedEmails(t, user.Email)
+	if len(emails) != 2 || !emails[0].Sensitive || emails[1].Sensitive {
+		t.Fatalf("got %d emails, want a sensitive reset and a plain lockout", len(emails))
+	}
+	if !strings.Contains(emails[0].Body, "token=") {
+		t.Fatal("the reset email has no token")
+	}
+
+	client := m
+	m = email.NewClient(email.Sender{Address: "app@example.com"}, failingTransport{})
+	defer func() { m = client }()
+
+	for _, entry := range emails {
+		entry.Attempts = outboxMaxAttempts - 1
+		deliverOutboxEmail(entry)
+	}
+
+	emails = queuedEmails(t, user.Email)
+	if emails[0].Status != outboxFailed || emails[0].Body != "" || emails[0].HTML != "" {
+		t.Errorf("failed reset email: status %s, body kept %t", emails[0].Status, emails[0].Body != "")
+	}
+	if emails[1].Status != outboxFailed || emails[1].Body == "" {
+		t.Errorf("failed lockout email: status %s, body kept %t", emails[1].Status, emails[1].Body != "")
+	}
+
+	admin := createTestUser(t, "outboxredactadmin")
+	db.Model(&admin).Update("admin", true)
+	c := newTestClient(t)
+	c.login(admin)
+	if res := c.post(fmt.Sprintf("/admin/outbox/%d/retry", emails[0].ID), nil); res.StatusCode != http.StatusConflict {
+		t.Errorf("retry of a redacted email: status %d, want %d", res.StatusCode, http.StatusConflict)
+	}
+}
+
+func TestNotifyEmailUsesOutbox(t *testing.T) {
+	user := createTestUser(t, "outboxnotify")
+	notifier, err := getChannelNotifier(Channel{Kind: notify.KindEmail}, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = notifier.Notify(notify.Message{Title: "Water", Body: "Time to drink."})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emails := queuedEmails(t, user.Email)
+	if len(emails) != 1 || emails[0].UserID != user.ID || emails[0].Subject != "Water" {
+		t.Fatalf("notification was not queued for user %d: %+v", user.ID, emails)
+	}
+}
+
+func TestPurgeUserDeletesEmails(t *testing.T) {
+	user := createTestUser(t, "outboxpurge")
+	err := sendPasswordReset(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user.DeletionExport = true
+	err = purgeUser(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emails := queuedEmails(t, user.Email)
+	if len(emails) != 1 || emails[0].UserID != 0 || emails[0].Sensitive {
+		t.Fatalf("got %d emails after the purge, want only the export", len(emails))
+	}
+}
+
+type countingTransport struct {
+	sent *int
+}
+
+func (c countingTransport) Send(from string, to []string, msg []byte) error {
+	*c.sent++
+	return nil
+}
+
+func TestDeliverOutboxStopsOnUpdateError(t *testing.T) {
+	user := createTestUser(t, "outboxstuck")
+	for _, subject := range []string{"First", "Second"} {
+		err := enqueueEmail(user.ID, email.Email{To: []string{user.Email}, Subject: subject, Body: "Hi."}, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var sent int
+	client := m
+	m = email.NewClient(email.Sender{Address: "app@example.com"}, countingTransport{&sent})
+	defer func() { m = client }()
+
+	// the outbox cannot be updated, so every run would pick the same emails again
+	err := db.Callback().Update().Before("gorm:update").Register("test:fail_outbox", func(tx *gorm.DB) {
+		if tx.Statement.Table == "outbox_emails" {
+			tx.AddError(errors.New("database is locked"))
+		}
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		deliverOutbox()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("deliverOutbox did not return")
+	}
+	db.Callback().Update().Remove("test:fail_outbox")
+
+	if sent != 1 {
+		t.Errorf("sent %d emails, want to stop after the first", sent)
+	}
+
+	var due int64
+	db.Model(&OutboxEmail{}).Where("status = ? AND next_attempt <= ?", outboxPending, time.Now()).Count(&due)
+	sent = 0
+	deliverOutbox()
+	if int64(sent) != due {
+		t.Errorf("sent %d emails, want each of the %d due once", sent, due)
+	}
+	for _, entry := range queuedEmails(t, user.Email) {
+		if entry.Status != outboxSent {
+			t.Errorf("%s: status %s", entry.Subject, entry.Status)
+		}
+	}
+}
@@ -0,0 +1,362 @@
+package app
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/birabittoh/myks"
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+)
+
+// Adapts a User and its passkeys to webauthn.User
+type webauthnUser struct {
+	user     User
+	passkeys []Passkey
+}
+
+const (
+	webauthnCookie     = "webauthn_session"
+	webauthnDuration   = 5 * time.Minute
+	maxPasskeyNameSize = 50
+)
+
+var wks = myks.New[webauthn.SessionData](time.Hour)
+
+func loadWebAuthnConfig() *webauthn.WebAuthn {
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		log.Println("Could not init passkeys:", err)
+		return nil
+	}
+
+	w, err := webauthn.New(&webauthn.Config{
+		RPID:          u.Hostname(),
+		RPDisplayName: "WellBinge",
+		RPOrigins:     []string{u.Scheme + "://" + u.Host},
+	})
+	if err != nil {
+		log.Println("Could not init passkeys:", err)
+		return nil
+	}
+	return w
+}
+
+func (u webauthnUser) WebAuthnID() []byte {
+	return u.user.WebAuthnHandle
+}
+
+func (u webauthnUser) WebAuthnName() string {
+	return u.user.Username
+}
+
+func (u webauthnUser) WebAuthnDisplayName() string {
+	return u.user.Username
+}
+
+func (u webauthnUser) WebAuthnCredentials() (credentials []webauthn.Credential) {
+	for _, passkey := range u.passkeys {
+		credential, err := passkey.credential()
+		if err == nil {
+			credentials = append(credentials, credential)
+		}
+	}
+	return
+}
+
+func (p Passkey) credential() (credential webauthn.Credential, err error) {
+	err = json.Unmarshal([]byte(p.Credential), &credential)
+	return
+}
+
+func getWebAuthnUser(user User) (webauthnUser, error) {
+	if len(user.WebAuthnHandle) == 0 {
+		user.WebAuthnHandle = make([]byte, 32)
+		_, err := rand.Read(user.WebAuthnHandle)
+		if err != nil {
+			return webauthnUser{}, err
+		}
+
+		err = db.Model(&user).Update("web_authn_handle", user.WebAuthnHandle).Error
+		if err != nil {
+			return webauthnUser{}, err
+		}
+	}
+
+	var passkeys []Passkey
+	err := db.Model(&Passkey{}).Where(&Passkey{UserID: user.ID}).Find(&passkeys).Error
+	return webauthnUser{user: user, passkeys: passkeys}, err
+}
+
+// Looks up the owner of a discoverable credential
+func findWebAuthnUser(rawID, userHandle []byte) (webauthn.User, error) {
+	var user User
+	err := db.Model(&User{}).Where("web_authn_handle = ?", userHandle).First(&user).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return getWebAuthnUser(user)
+}
+
+func saveWebAuthnSession(w http.ResponseWriter, session *webauthn.SessionData) error {
+	token, err := g.GenerateRandomToken(32)
+	if err != nil {
+		return err
+	}
+
+	wks.Set(token, *session, webauthnDuration)
+	http.SetCookie(w, &http.Cookie{
+		Name:     webauthnCookie,
+		Value:    token,
+		Path:     "/passkeys",
+		MaxAge:   int(webauthnDuration.Seconds()),
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteStrictMode,
+	})
+	return nil
+}
+
+// Returns the pending ceremony for this browser; each session can only be used once
+func popWebAuthnSession(r *http.Request) (*webauthn.SessionData, error) {
+	cookie, err := r.Cookie(webauthnCookie)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := wks.Get(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+
+	wks.Delete(cookie.Value)
+	return session, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func getPasskeysHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "Could not find user in context.", http.StatusInternalServerError)
+		return
+	}
+
+	var passkeys []Passkey
+	err := db.Model(&Passkey{}).Where(&Passkey{UserID: user.ID}).Find(&passkeys).Error
+	if err != nil {
+		httpError(w, r, "Could not get passkeys.", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Passkeys":  passkeys,
+		"Available": wa != nil,
+	}
+
+	executeTemplate(w, r, http.StatusOK, "passkeys.tmpl", data)
+}
+
+func postPasskeyRegisterBeginHandler(w http.ResponseWriter, r *http.Request) {
+	if wa == nil {
+		httpError(w, r, "Passkeys are not available on this instance.", http.StatusNotFound)
+		return
+	}
+
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	wu, err := getWebAuthnUser(user)
+	if err != nil {
+		httpError(w, r, "Could not get passkeys.", http.StatusInternalServerError)
+		return
+	}
+
+	creation, session, err := wa.BeginRegistration(wu,
+		webauthn.WithResidentKeyRequirement(protocol.ResidentKeyRequirementRequired),
+		webauthn.WithExclusions(webauthn.Credentials(wu.WebAuthnCredentials()).CredentialDescriptors()),
+	)
+	if err == nil {
+		err = saveWebAuthnSession(w, session)
+	}
+	if err != nil {
+		httpError(w, r, "Could not start passkey registration.", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, creation)
+}
+
+func postPasskeyRegisterFinishHandler(w http.ResponseWriter, r *http.Request) {
+	if wa == nil {
+		httpError(w, r, "Passkeys are not available on this instance.", http.StatusNotFound)
+		return
+	}
+
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	session, err := popWebAuthnSession(r)
+	if err != nil {
+		httpError(w, r, "Registration expired, please try again.", http.StatusBadRequest)
+		return
+	}
+
+	wu, err := getWebAuthnUser(user)
+	if err != nil {
+		httpError(w, r, "Could not get passkeys.", http.StatusInternalServerError)
+		return
+	}
+
+	credential, err := wa.FinishRegistration(wu, *session, r)
+	if err != nil {
+		httpError(w, r, "Could not verify passkey.", http.StatusBadRequest)
+		return
+	}
+
+	encoded, err := json.Marshal(credential)
+	if err != nil {
+		httpError(w, r, "Could not save passkey.", http.StatusInternalServerError)
+		return
+	}
+
+	name := strings.TrimSpace(r.URL.Query().Get("name"))
+	if name == "" {
+		name = "Passkey"
+	}
+	if len(name) > maxPasskeyNameSize {
+		name = name[:maxPasskeyNameSize]
+	}
+
+	passkey := Passkey{
+		UserID:       user.ID,
+		Name:         name,
+		CredentialID: credential.ID,
+		Credential:   string(encoded),
+	}
+
+	err = db.Create(&passkey).Error
+	if err != nil {
+		httpError(w, r, "This passkey is already registered.", http.StatusConflict)
+		return
+	}
+	audit(r, user.ID, auditPasskeyAdded, passkey.Name)
+
+	writeJSON(w, map[string]string{"redirect": "/passkeys"})
+}
+
+func postPasskeyLoginBeginHandler(w http.ResponseWriter, r *http.Request) {
+	if wa == nil {
+		httpError(w, r, "Passkeys are not available on this instance.", http.StatusNotFound)
+		return
+	}
+
+	assertion, session, err := wa.BeginDiscoverableLogin(
+		webauthn.WithUserVerification(protocol.VerificationRequired),
+	)
+	if err == nil {
+		err = saveWebAuthnSession(w, session)
+	}
+	if err != nil {
+		httpError(w, r, "Could not start passkey login.", http.StatusInternalServerError)
+		return
+	}
+
+	writeJSON(w, assertion)
+}
+
+func postPasskeyLoginFinishHandler(w http.ResponseWriter, r *http.Request) {
+	if wa == nil {
+		httpError(w, r, "Passkeys are not available on this instance.", http.StatusNotFound)
+		return
+	}
+
+	session, err := popWebAuthnSession(r)
+	if err != nil {
+		httpError(w, r, "Login expired, please try again.", http.StatusBadRequest)
+		return
+	}
+
+	u, credential, err := wa.FinishPasskeyLogin(findWebAuthnUser, *session, r)
+	if err != nil {
+		httpError(w, r, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+	user := u.(webauthnUser).user
+
+	if isLockedOut(user) {
+		httpError(w, r, "This account is temporarily locked, please try again later.", http.StatusTooManyRequests)
+		return
+	}
+
+	var passkey Passkey
+	err = db.Model(&Passkey{}).Where("user_id = ? AND credential_id = ?", user.ID, credential.ID).First(&passkey).Error
+	if err != nil {
+		recordLoginFailure(user, r, "unknown passkey")
+		httpError(w, r, "Invalid credentials", http.StatusUnauthorized)
+		return
+	}
+
+	if credential.Authenticator.CloneWarning {
+		log.Printf("Passkey %d of user %d might be cloned.", passkey.ID, user.ID)
+	}
+
+	// keep the updated sign counter and backup state
+	encoded, err := json.Marshal(credential)
+	if err == nil {
+		now := time.Now()
+		passkey.Credential = string(encoded)
+		passkey.LastUsed = &now
+		db.Save(&passkey)
+	}
+
+	if user.Disabled {
+		httpError(w, r, "This account has been disabled.", http.StatusForbidden)
+		return
+	}
+
+	if requireVerification && !user.Verified {
+		httpError(w, r, "Please verify your email address first.", http.StatusForbidden)
+		return
+	}
+
+	resetLoginFailures(user)
+	login(w, r, user.ID, r.URL.Query().Get("remember") == "on", "passkey")
+	writeJSON(w, map[string]string{"redirect": "/habits"})
+}
+
+func postPasskeyDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	id := getID(r)
+	if id == 0 {
+		httpError(w, r, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	res := db.Unscoped().Delete(&Passkey{}, "id = ? AND user_id = ?", id, user.ID)
+	if res.RowsAffected > 0 {
+		audit(r, user.ID, auditPasskeyRemoved, "")
+	}
+
+	http.Redirect(w, r, "/passkeys", http.StatusFound)
+}
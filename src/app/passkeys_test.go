@@ -0,0 +1,273 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/go-webauthn/webauthn/protocol/webauthncose"
+)
+
+const testOrigin = "http://localhost:3000"
+
+// A software authenticator holding a single ES256 passkey, like a platform authenticator would
+type softAuthenticator struct {
+	key          *ecdsa.PrivateKey
+	credentialID []byte
+	userHandle   []byte
+	counter      uint32
+	origin       string
+	rpID         string
+}
+
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttestedData = 0x40
+)
+
+var b64 = base64.RawURLEncoding
+
+func newSoftAuthenticator(t *testing.T) *softAuthenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	id := make([]byte, 16)
+	rand.Read(id)
+	return &softAuthenticator{key: key, credentialID: id, origin: testOrigin, rpID: "localhost"}
+}
+
+func (a *softAuthenticator) clientData(kind, challenge string) []byte {
+	data, _ := json.Marshal(map[string]interface{}{
+		"type":        kind,
+		"challenge":   challenge,
+		"origin":      a.origin,
+		"crossOrigin": false,
+	})
+	return data
+}
+
+func (a *softAuthenticator) authenticatorData(flags byte, attested []byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+	data := append(rpIDHash[:], flags)
+	data = binary.BigEndian.AppendUint32(data, a.counter)
+	return append(data, attested...)
+}
+
+// Answers navigator.credentials.create with a "none" attestation
+func (a *softAuthenticator) create(t *testing.T, options []byte) string {
+	t.Helper()
+
+	var creation struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+			User      struct {
+				ID string `json:"id"`
+			} `json:"user"`
+		} `json:"publicKey"`
+	}
+	err := json.Unmarshal(options, &creation)
+	if err != nil {
+		t.Fatalf("bad creation options %s: %v", options, err)
+	}
+
+	a.userHandle, err = b64.DecodeString(creation.PublicKey.User.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicKey, err := webauthncbor.Marshal(webauthncose.EC2PublicKeyData{
+		PublicKeyData: webauthncose.PublicKeyData{KeyType: int64(webauthncose.EllipticKey), Algorithm: int64(webauthncose.AlgES256)},
+		Curve:         int64(webauthncose.P256),
+		XCoord:        a.key.X.FillBytes(make([]byte, 32)),
+		YCoord:        a.key.Y.FillBytes(make([]byte, 32)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attested := make([]byte, 16) // AAGUID
+	attested = binary.BigEndian.AppendUint16(attested, uint16(len(a.credentialID)))
+	attested = append(attested, a.credentialID...)
+	attested = append(attested, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": a.authenticatorData(flagUserPresent|flagUserVerified|flagAttestedData, attested),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":    b64.EncodeToString(a.credentialID),
+		"rawId": b64.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    b64.EncodeToString(a.clientData("webauthn.create", creation.PublicKey.Challenge)),
+			"attestationObject": b64.EncodeToString(attestation),
+		},
+	})
+	return string(body)
+}
+
+// Answers navigator.credentials.get with a signed assertion
+func (a *softAuthenticator) get(t *testing.T, options []byte) string {
+	t.Helper()
+
+	var assertion struct {
+		PublicKey struct {
+			Challenge string `json:"challenge"`
+		} `json:"publicKey"`
+	}
+	err := json.Unmarshal(options, &assertion)
+	if err != nil {
+		t.Fatalf("bad assertion options %s: %v", options, err)
+	}
+
+	a.counter++
+	authData := a.authenticatorData(flagUserPresent|flagUserVerified, nil)
+	clientData := a.clientData("webauthn.get", assertion.PublicKey.Challenge)
+
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(authData, clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body, _ := json.Marshal(map[string]interface{}{
+		"id":    b64.EncodeToString(a.credentialID),
+		"rawId": b64.EncodeToString(a.credentialID),
+		"type":  "public-key",
+		"response": map[string]interface{}{
+			"clientDataJSON":    b64.EncodeToString(clientData),
+			"authenticatorData": b64.EncodeToString(authData),
+			"signature":         b64.EncodeToString(signature),
+			"userHandle":        b64.EncodeToString(a.userHandle),
+		},
+	})
+	return string(body)
+}
+
+func setupWebAuthn(t *testing.T) {
+	previous := baseUrl
+	baseUrl = testOrigin
+	wa = loadWebAuthnConfig()
+	if wa == nil {
+		t.Fatal("could not load the WebAuthn configuration")
+	}
+
+	t.Cleanup(func() {
+		baseUrl = previous
+		wa = nil
+	})
+}
+
+// Registers a passkey for the user through the app's endpoints
+func registerPasskey(t *testing.T, user User, name string) *softAuthenticator {
+	t.Helper()
+
+	a := newSoftAuthenticator(t)
+	c := newTestClient(t)
+	c.login(user)
+
+	res := c.postJSON("/passkeys/register/begin", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("register begin: status %d", res.StatusCode)
+	}
+
+	res = c.postJSON("/passkeys/register/finish?name="+url.QueryEscape(name), a.create(t, []byte(readBody(t, res))))
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("register finish: status %d: %s", res.StatusCode, readBody(t, res))
+	}
+	return a
+}
+
+// Logs in with the authenticator, returning the client and the final response
+func loginWithPasskey(t *testing.T, a *softAuthenticator) (*testClient, *http.Response) {
+	t.Helper()
+
+	c := newTestClient(t)
+	res := c.postJSON("/passkeys/login/begin", "")
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("login begin: status %d", res.StatusCode)
+	}
+
+	return c, c.postJSON("/passkeys/login/finish", a.get(t, []byte(readBody(t, res))))
+}
+
+func TestPasskeyRegistrationAndLogin(t *testing.T) {
+	setupWebAuthn(t)
+	user := createTestUser(t, "passkey")
+
+	a := registerPasskey(t, user, "Laptop")
+
+	var passkey Passkey
+	err := db.Where(&Passkey{UserID: user.ID}).First(&passkey).Error
+	if err != nil || passkey.Name != "Laptop" || string(passkey.CredentialID) != string(a.credentialID) {
+		t.Fatalf("passkey not saved: %+v, %v", passkey, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		c, res := loginWithPasskey(t, a)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("login finish: status %d: %s", res.StatusCode, readBody(t, res))
+		}
+		if c.userID() != user.ID {
+			t.Errorf("logged in as %d, want %d", c.userID(), user.ID)
+		}
+	}
+
+	db.First(&passkey, passkey.ID)
+	credential, err := passkey.credential()
+	if err != nil || credential.Authenticator.SignCount != a.counter || passkey.LastUsed == nil {
+		t.Errorf("sign counter %d, last used %v, want %d and a time", credential.Authenticator.SignCount, passkey.LastUsed, a.counter)
+	}
+}
+
+func TestPasskeyRejectsBadAssertions(t *testing.T) {
+	setupWebAuthn(t)
+	user := createTestUser(t, "passkeybad")
+	a := registerPasskey(t, user, "Phone")
+
+	// a different key for the same credential
+	stolen := *a
+	stolen.key = newSoftAuthenticator(t).key
+	if c, res := loginWithPasskey(t, &stolen); res.StatusCode != http.StatusUnauthorized || c.userID() != 0 {
+		t.Errorf("wrong key: status %d, user %d", res.StatusCode, c.userID())
+	}
+
+	// another origin, like a phishing site
+	phished := *a
+	phished.origin = "https://wellbinge.example.net"
+	if c, res := loginWithPasskey(t, &phished); res.StatusCode != http.StatusUnauthorized || c.userID() != 0 {
+		t.Errorf("wrong origin: status %d, user %d", res.StatusCode, c.userID())
+	}
+
+	// each challenge can only be answered once
+	c := newTestClient(t)
+	options := readBody(t, c.postJSON("/passkeys/login/begin", ""))
+	assertion := a.get(t, []byte(options))
+	c.postJSON("/passkeys/login/finish", assertion)
+	c.cookies = map[string]*http.Cookie{csrfCookie: c.cookies[csrfCookie], webauthnCookie: c.cookies[webauthnCookie]}
+	if res := c.postJSON("/passkeys/login/finish", assertion); res.StatusCode != http.StatusBadRequest {
+		t.Errorf("replayed assertion: status %d, want %d", res.StatusCode, http.StatusBadRequest)
+	}
+
+	db.Model(&user).Update("disabled", true)
+	if c, res := loginWithPasskey(t, a); res.StatusCode != http.StatusForbidden || c.userID() != 0 {
+		t.Errorf("disabled user: status %d, user %d", res.StatusCode, c.userID())
+	}
+}
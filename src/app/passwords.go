@@ -0,0 +1,53 @@
+package app
+
+import (
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/birabittoh/auth-boilerplate/src/auth"
+)
+
+var passwordPolicy = auth.PasswordPolicy{MinLength: 8, MinStrength: 2}
+
+func loadPasswordPolicy() {
+	if s := os.Getenv("APP_PASSWORD_MIN_LENGTH"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal("Invalid APP_PASSWORD_MIN_LENGTH: ", err)
+		}
+		passwordPolicy.MinLength = n
+	}
+	passwordPolicy.MinLength = max(passwordPolicy.MinLength, auth.MinPasswordLength)
+
+	if s := os.Getenv("APP_PASSWORD_MIN_STRENGTH"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || n > 4 {
+			log.Fatal("Invalid APP_PASSWORD_MIN_STRENGTH: must be between 0 and 4")
+		}
+		passwordPolicy.MinStrength = n
+	}
+
+	if s := os.Getenv("APP_BREACHED_PASSWORDS"); s != "" {
+		breached, err := auth.NewBreached(s)
+		if err != nil {
+			log.Fatal("Invalid APP_BREACHED_PASSWORDS: ", err)
+		}
+		passwordPolicy.Breached = breached
+	}
+}
+
+// Returns what is wrong with a new password, translated; a failed breach lookup does not block it
+func checkNewPassword(r *http.Request, password string, userInputs ...string) (messages []string) {
+	problems, err := passwordPolicy.Check(password, userInputs...)
+	if err != nil {
+		log.Println("Could not check breached passwords:", err)
+	}
+
+	l := localizer(r)
+	for _, problem := range problems {
+		messages = append(messages, l.T(problem.Message, problem.Args...))
+	}
+	return
+}
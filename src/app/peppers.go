@@ -0,0 +1,168 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/birabittoh/auth-boilerplate/src/auth"
+	"gorm.io/gorm"
+)
+
+// Number of users whose password hash uses a pepper
+type pepperUsage struct {
+	Name    string
+	Users   int64
+	Current bool
+	Known   bool
+}
+
+const (
+	pepperLegacy = "legacy"
+	pepperNone   = "none"
+
+	pepperFingerprintMessage = "well-binge pepper fingerprint"
+)
+
+// Reads APP_PEPPERS ("id:secret,id:secret", current pepper first), or APP_PEPPER as pepper "1"
+func loadPeppers() error {
+	spec := os.Getenv("APP_PEPPERS")
+	if spec == "" && os.Getenv("APP_PEPPER") != "" {
+		spec = "1:" + os.Getenv("APP_PEPPER")
+	}
+
+	for _, entry := range strings.Split(spec, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+
+		id, secret, ok := strings.Cut(entry, ":")
+		if !ok || !auth.ValidKeyID(id) || secret == "" {
+			return fmt.Errorf("invalid pepper %q, expected <id>:<secret>", id)
+		}
+		if _, ok := g.Peppers[id]; ok {
+			return fmt.Errorf("duplicate pepper %q", id)
+		}
+
+		g.Peppers[id] = secret
+		if g.CurrentPepper == "" {
+			g.CurrentPepper = id
+		}
+	}
+	return nil
+}
+
+func pepperFingerprint(secret string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(pepperFingerprintMessage))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Records the fingerprint of new peppers and fails if a known id now has a different secret,
+// since every password keyed with it would stop working
+func checkPepperFingerprints() error {
+	for id, secret := range g.Peppers {
+		fingerprint := pepperFingerprint(secret)
+
+		var stored Pepper
+		err := db.Where(&Pepper{ID: id}).First(&stored).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			err = db.Create(&Pepper{ID: id, Fingerprint: fingerprint}).Error
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		if !hmac.Equal([]byte(stored.Fingerprint), []byte(fingerprint)) {
+			return fmt.Errorf("pepper %q does not match the secret it had before, give the new secret a new id", id)
+		}
+	}
+	return nil
+}
+
+// Counts users by the pepper their password hash is keyed with
+func getPepperUsage() (usage []pepperUsage, err error) {
+	var users []User
+	err = db.Model(&User{}).Select("password_hash", "salt").Where("password_hash != ''").Find(&users).Error
+	if err != nil {
+		return
+	}
+
+	counts := map[string]int64{}
+	for _, user := range users {
+		id, legacy := g.PepperOf(user.Salt, user.PasswordHash)
+		switch {
+		case legacy:
+			id = pepperLegacy
+		case id == "":
+			id = pepperNone
+		}
+		counts[id]++
+	}
+
+	for id := range g.Peppers {
+		if _, ok := counts[id]; !ok {
+			counts[id] = 0
+		}
+	}
+
+	for name, n := range counts {
+		_, known := g.Peppers[name]
+		usage = append(usage, pepperUsage{
+			Name:    name,
+			Users:   n,
+			Current: name == g.CurrentPepper,
+			Known:   known || name == pepperLegacy || name == pepperNone,
+		})
+	}
+
+	sort.Slice(usage, func(i, j int) bool { return usage[i].Name < usage[j].Name })
+	return
+}
+
+// Warns about users who cannot login because their pepper was removed
+func checkPeppers() {
+	usage, err := getPepperUsage()
+	if err != nil {
+		log.Println("Could not check peppers:", err)
+		return
+	}
+
+	for _, u := range usage {
+		if !u.Known {
+			log.Printf("%d user(s) have passwords keyed with pepper %q, which is not configured: they cannot login.", u.Users, u.Name)
+		}
+	}
+}
+
+// Prints how many users are still on each pepper, for the "peppers" command
+func printPepperReport() {
+	usage, err := getPepperUsage()
+	if err != nil {
+		log.Fatal("Could not get pepper usage: ", err)
+	}
+
+	for _, u := range usage {
+		note := ""
+		switch {
+		case u.Current:
+			note = " (current)"
+		case !u.Known:
+			note = " (not configured)"
+		case u.Users > 0:
+			note = " (upgraded at next login)"
+		}
+		fmt.Printf("%-10s %6d%s\n", u.Name, u.Users, note)
+	}
+}
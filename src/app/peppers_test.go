@@ -0,0 +1,105 @@
+package app
+
+import (
+	"testing"
+
+	"github.com/birabittoh/auth-boilerplate/src/auth"
+)
+
+func TestLoadPeppers(t *testing.T) {
+	peppers, current := g.Peppers, g.CurrentPepper
+	defer func() { g.Peppers, g.CurrentPepper = peppers, current }()
+
+	tests := []struct {
+		peppers string
+		pepper  string
+		current string
+		count   int
+		ok      bool
+	}{
+		{"", "", "", 0, true},
+		{"b:second, a:first", "", "b", 2, true},
+		{"", "secret", "1", 1, true},
+		{"a:first", "ignored", "a", 1, true},
+		{"a:first,a:again", "", "", 0, false},
+		{"a", "", "", 0, false},
+		{"a:", "", "", 0, false},
+		{"not-valid:secret", "", "", 0, false},
+	}
+
+	for _, tt := range tests {
+		g.Peppers, g.CurrentPepper = map[string]string{}, ""
+		t.Setenv("APP_PEPPERS", tt.peppers)
+		t.Setenv("APP_PEPPER", tt.pepper)
+
+		err := loadPeppers()
+		if (err == nil) != tt.ok {
+			t.Errorf("APP_PEPPERS=%q APP_PEPPER=%q: error %v", tt.peppers, tt.pepper, err)
+			continue
+		}
+		if tt.ok && (g.CurrentPepper != tt.current || len(g.Peppers) != tt.count) {
+			t.Errorf("APP_PEPPERS=%q APP_PEPPER=%q: current %q of %d, want %q of %d",
+				tt.peppers, tt.pepper, g.CurrentPepper, len(g.Peppers), tt.current, tt.count)
+		}
+	}
+}
+
+func TestPepperUsage(t *testing.T) {
+	peppers, current := g.Peppers, g.CurrentPepper
+	defer func() { g.Peppers, g.CurrentPepper = peppers, current }()
+
+	g.Peppers = map[string]string{"pepperone": "secret"}
+	g.CurrentPepper = "pepperone"
+	user := createTestUser(t, "pepperusage")
+	hash, _ := auth.DefaultArgon2id.Hash("unused", "pepperlost")
+	db.Create(&User{Username: "pepperlost", Email: "pepperlost@example.com", PasswordHash: hash})
+
+	usage, err := getPepperUsage()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	found := map[string]pepperUsage{}
+	for _, u := range usage {
+		found[u.Name] = u
+	}
+	if u := found["pepperone"]; u.Users != 1 || !u.Current || !u.Known {
+		t.Errorf("current pepper usage %+v for user %d", u, user.ID)
+	}
+	if u := found["pepperlost"]; u.Users != 1 || u.Known {
+		t.Errorf("removed pepper usage %+v", u)
+	}
+}
+
+func TestPepperFingerprints(t *testing.T) {
+	peppers := g.Peppers
+	defer func() { g.Peppers = peppers }()
+
+	g.Peppers = map[string]string{"fpone": "first", "fptwo": "second"}
+	err := checkPepperFingerprints()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = checkPepperFingerprints()
+	if err != nil {
+		t.Errorf("unchanged peppers: %v", err)
+	}
+
+	var stored Pepper
+	db.Where(&Pepper{ID: "fpone"}).First(&stored)
+	if stored.Fingerprint == "" || stored.Fingerprint == "first" {
+		t.Errorf("stored fingerprint %q", stored.Fingerprint)
+	}
+
+	// removing a pepper is fine, changing its secret is not
+	g.Peppers = map[string]string{"fpone": "first"}
+	err = checkPepperFingerprints()
+	if err != nil {
+		t.Errorf("removed pepper: %v", err)
+	}
+	g.Peppers = map[string]string{"fpone": "changed"}
+	err = checkPepperFingerprints()
+	if err == nil {
+		t.Error("a changed secret was accepted")
+	}
+}
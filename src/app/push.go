@@ -0,0 +1,237 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/birabittoh/auth-boilerplate/src/notify"
+	"github.com/birabittoh/auth-boilerplate/src/push"
+)
+
+// Delivers messages to every push device of a user
+type pushNotifier struct {
+	userID uint
+}
+
+type pushSubscriptionRequest struct {
+	Endpoint string `json:"endpoint"`
+	Keys     struct {
+		P256dh string `json:"p256dh"`
+		Auth   string `json:"auth"`
+	} `json:"keys"`
+}
+
+const pushTTL = 12 * time.Hour
+
+func loadPushConfig() *push.Client {
+	keyPath := filepath.Join(dataDir, vapidKey)
+
+	key, err := os.ReadFile(keyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		key, err = push.GenerateKey()
+		if err == nil {
+			err = os.WriteFile(keyPath, key, 0600)
+		}
+	}
+	if err != nil {
+		log.Println("Could not load VAPID key:", err)
+		return nil
+	}
+
+	subject := os.Getenv("APP_VAPID_SUBJECT")
+	if subject == "" {
+		subject = baseUrl
+		if address := os.Getenv("APP_SMTP_EMAIL"); address != "" {
+			subject = "mailto:" + address
+		}
+	}
+
+	client, err := push.NewClient(key, subject)
+	if err != nil {
+		log.Println("Could not init push notifications:", err)
+		return nil
+	}
+	return client
+}
+
+func sendPush(subscription *PushSubscription, message notify.Message) error {
+	if wp == nil {
+		return errors.New("push client is not initialized")
+	}
+
+	payload, err := json.Marshal(message)
+	if err != nil {
+		return err
+	}
+
+	err = wp.Send(push.Subscription{
+		Endpoint: subscription.Endpoint,
+		P256dh:   subscription.P256dh,
+		Auth:     subscription.Auth,
+	}, payload, pushTTL)
+	if errors.Is(err, push.ErrGone) {
+		db.Unscoped().Delete(subscription)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	subscription.LastUsed = &now
+	return db.Save(subscription).Error
+}
+
+// Sends a message to every device of a user, returning the first error
+func (n pushNotifier) Notify(message notify.Message) (err error) {
+	var subscriptions []PushSubscription
+	err = db.Model(&PushSubscription{}).Where(&PushSubscription{UserID: n.userID}).Find(&subscriptions).Error
+	if err != nil {
+		return
+	}
+
+	for i := range subscriptions {
+		e := sendPush(&subscriptions[i], message)
+		if e != nil && err == nil {
+			err = e
+		}
+	}
+	return
+}
+
+func getPushSubscriptionHelper(w http.ResponseWriter, r *http.Request) (subscription PushSubscription, err error) {
+	id := getID(r)
+	if id == 0 {
+		err = errors.New("no id")
+		httpError(w, r, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	user, ok := getLoggedUser(r)
+	if !ok {
+		err = errors.New("no logged user")
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	err = db.Model(&PushSubscription{}).Where("id = ? AND user_id = ?", id, user.ID).First(&subscription).Error
+	if err != nil {
+		httpError(w, r, "not found", http.StatusNotFound)
+	}
+	return
+}
+
+func getNotificationsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "Could not find user in context.", http.StatusInternalServerError)
+		return
+	}
+
+	var subscriptions []PushSubscription
+	err := db.Model(&PushSubscription{}).Where(&PushSubscription{UserID: user.ID}).Find(&subscriptions).Error
+	if err != nil {
+		httpError(w, r, "Could not get push subscriptions.", http.StatusInternalServerError)
+		return
+	}
+
+	var channels []Channel
+	err = db.Model(&Channel{}).Where(&Channel{UserID: user.ID}).Find(&channels).Error
+	if err != nil {
+		httpError(w, r, "Could not get notification channels.", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Subscriptions":  subscriptions,
+		"Channels":       channels,
+		"Kinds":          channelKinds,
+		"TelegramLinked": user.TelegramChatID != nil,
+	}
+	if wp != nil {
+		data["PublicKey"] = wp.PublicKey
+	}
+	if tg != nil {
+		data["TelegramBot"] = tg.Username
+	}
+
+	executeTemplate(w, r, http.StatusOK, "notifications.tmpl", data)
+}
+
+func postPushSubscribeHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req pushSubscriptionRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req)
+	if err != nil || req.Endpoint == "" || req.Keys.P256dh == "" || req.Keys.Auth == "" {
+		httpError(w, r, "Invalid subscription.", http.StatusBadRequest)
+		return
+	}
+
+	err = push.CheckEndpoint(req.Endpoint)
+	if err != nil {
+		httpError(w, r, "Invalid subscription.", http.StatusBadRequest)
+		return
+	}
+
+	var subscription PushSubscription
+	db.Where(&PushSubscription{Endpoint: req.Endpoint}).First(&subscription)
+	if subscription.ID != 0 && subscription.UserID != user.ID {
+		httpError(w, r, "This device is already subscribed by another account.", http.StatusConflict)
+		return
+	}
+
+	subscription.UserID = user.ID
+	subscription.Endpoint = req.Endpoint
+	subscription.P256dh = req.Keys.P256dh
+	subscription.Auth = req.Keys.Auth
+	subscription.UserAgent = r.UserAgent()
+
+	err = db.Save(&subscription).Error
+	if err != nil {
+		httpError(w, r, "Could not save subscription.", http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func postPushTestHandler(w http.ResponseWriter, r *http.Request) {
+	subscription, err := getPushSubscriptionHelper(w, r)
+	if err != nil {
+		return
+	}
+
+	err = sendPush(&subscription, notify.Message{
+		Title: "WellBinge",
+		Body:  localizer(r).T("Push notifications are working on this device."),
+		URL:   baseUrl + "/notifications",
+	})
+	if err != nil {
+		log.Printf("Could not send test push to subscription %d: %v", subscription.ID, err)
+		httpError(w, r, "Could not send test notification.", http.StatusBadGateway)
+		return
+	}
+
+	http.Redirect(w, r, "/notifications", http.StatusFound)
+}
+
+func postPushDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	subscription, err := getPushSubscriptionHelper(w, r)
+	if err != nil {
+		return
+	}
+
+	db.Unscoped().Delete(&subscription)
+
+	http.Redirect(w, r, "/notifications", http.StatusFound)
+}
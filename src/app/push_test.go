@@ -0,0 +1,42 @@
+package app
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestPushSubscribe(t *testing.T) {
+	owner := createTestUser(t, "pushowner")
+	other := createTestUser(t, "pushother")
+
+	subscribe := func(user User, endpoint string) int {
+		c := newTestClient(t)
+		c.login(user)
+		res := c.postJSON("/push/subscribe", `{"endpoint":"`+endpoint+`","keys":{"p256dh":"key","auth":"secret"}}`)
+		return res.StatusCode
+	}
+
+	const endpoint = "https://93.184.215.14/push/device"
+	if status := subscribe(owner, endpoint); status != http.StatusNoContent {
+		t.Fatalf("subscribe: status %d", status)
+	}
+	if status := subscribe(owner, endpoint); status != http.StatusNoContent {
+		t.Errorf("subscribing the same device again: status %d", status)
+	}
+
+	// subscriptions are looked up by endpoint, so another account must not take it over
+	if status := subscribe(other, endpoint); status != http.StatusConflict {
+		t.Errorf("another account's endpoint: status %d, want %d", status, http.StatusConflict)
+	}
+	var subscription PushSubscription
+	db.Where(&PushSubscription{Endpoint: endpoint}).First(&subscription)
+	if subscription.UserID != owner.ID {
+		t.Errorf("subscription moved to user %d", subscription.UserID)
+	}
+
+	for _, endpoint := range []string{"http://93.184.215.14/push", "https://127.0.0.1/push", "https://169.254.169.254/latest/meta-data", "https://[fd00::1]/push"} {
+		if status := subscribe(other, endpoint); status != http.StatusBadRequest {
+			t.Errorf("subscribe to %s: status %d, want %d", endpoint, status, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/birabittoh/myks"
+)
+
+// Requests counted in the current window, plus the backoff state once the limit was exceeded
+type rateLimit struct {
+	Start   time.Time
+	Count   int
+	Strikes uint
+	Until   time.Time
+}
+
+const (
+	rateLimitWindow = time.Minute
+	maxBackoff      = time.Hour
+)
+
+var (
+	rateLimitRequests = 10
+	lockoutAttempts   = 5
+	lockoutDuration   = 15 * time.Minute
+	trustProxy        = false
+
+	rks   = myks.New[rateLimit](time.Hour)
+	rksMu sync.Mutex
+)
+
+func loadRateLimitConfig() {
+	if s := os.Getenv("APP_RATE_LIMIT"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal("Invalid APP_RATE_LIMIT: ", err)
+		}
+		rateLimitRequests = n
+	}
+
+	if s := os.Getenv("APP_LOCKOUT_ATTEMPTS"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			log.Fatal("Invalid APP_LOCKOUT_ATTEMPTS: ", err)
+		}
+		lockoutAttempts = n
+	}
+
+	if s := os.Getenv("APP_LOCKOUT_DURATION"); s != "" {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			log.Fatal("Invalid APP_LOCKOUT_DURATION: ", err)
+		}
+		lockoutDuration = d
+	}
+
+	e := strings.ToLower(os.Getenv("APP_TRUST_PROXY"))
+	trustProxy = e == "true" || e == "1"
+}
+
+// Returns the address of the client, as seen by the last proxy when behind one
+func clientIP(r *http.Request) string {
+	if trustProxy {
+		forwarded := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+		if ip := strings.TrimSpace(forwarded[len(forwarded)-1]); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Doubles the block duration every time the limit is exceeded again
+func backoff(strikes uint) time.Duration {
+	d := rateLimitWindow << min(strikes-1, 6)
+	return min(d, maxBackoff)
+}
+
+// Counts a request for key, returning how long to wait if it is over the limit
+func allowRequest(key string) (time.Duration, bool) {
+	if rateLimitRequests <= 0 {
+		return 0, true
+	}
+
+	rksMu.Lock()
+	defer rksMu.Unlock()
+
+	now := time.Now()
+	limit := rateLimit{Start: now}
+	if l, err := rks.Get(key); err == nil {
+		limit = *l
+	}
+
+	if now.Before(limit.Until) {
+		return limit.Until.Sub(now), false
+	}
+
+	if now.Sub(limit.Start) >= rateLimitWindow {
+		limit.Start = now
+		limit.Count = 0
+	}
+
+	limit.Count++
+	allowed := limit.Count <= rateLimitRequests
+	if !allowed {
+		limit.Strikes++
+		limit.Until = now.Add(backoff(limit.Strikes))
+		limit.Start = limit.Until
+		limit.Count = 0
+	}
+
+	// strikes are forgotten after a quiet period
+	rks.Set(key, limit, limit.Until.Sub(now)+maxBackoff)
+	return limit.Until.Sub(now), allowed
+}
+
+func tooManyRequests(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(wait.Seconds())+1))
+	httpError(w, r, "Too many requests, please try again later.", http.StatusTooManyRequests)
+}
+
+func isLockedOut(user User) bool {
+	_, err := ks.Get(fmt.Sprintf("lockout:%d", user.ID))
+	return err == nil
+}
+
+// Counts a failed login, locking the account once there are too many in a row
+func recordLoginFailure(user User, r *http.Request, reason string) {
+	audit(r, user.ID, auditLoginFailed, reason)
+	if lockoutAttempts <= 0 {
+		return
+	}
+
+	key := fmt.Sprintf("login-failures:%d", user.ID)
+	failures := uint(1)
+	if n, err := ks.Get(key); err == nil {
+		failures = *n + 1
+	}
+
+	if failures < uint(lockoutAttempts) {
+		ks.Set(key, failures, lockoutDuration)
+		return
+	}
+
+	ks.Delete(key)
+	ks.Set(fmt.Sprintf("lockout:%d", user.ID), user.ID, lockoutDuration)
+	log.Printf("Locked user %d after %d failed logins from %s.", user.ID, failures, clientIP(r))
+	audit(r, user.ID, auditLockout, fmt.Sprintf("for %s", lockoutDuration))
+	sendLockoutEmail(user, clientIP(r))
+}
+
+func resetLoginFailures(user User) {
+	ks.Delete(fmt.Sprintf("login-failures:%d", user.ID))
+}
+
+func sendLockoutEmail(user User, ip string) {
+	err := sendTemplateEmail(user, "lockout", map[string]interface{}{
+		"Username": user.Username,
+		"Minutes":  int(lockoutDuration.Minutes()),
+		"Attempts": lockoutAttempts,
+		"IP":       ip,
+	})
+	if err != nil {
+		log.Printf("Could not send lockout email to %s.", user.Email)
+	}
+}
@@ -0,0 +1,220 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Sets the limits for the duration of the test; TestMain disables them
+func setLimits(t *testing.T, requests, attempts int) {
+	previousRequests, previousAttempts := rateLimitRequests, lockoutAttempts
+	rateLimitRequests, lockoutAttempts = requests, attempts
+	t.Cleanup(func() {
+		rateLimitRequests, lockoutAttempts = previousRequests, previousAttempts
+	})
+}
+
+// Moves the state of key back in time, as if d had passed
+func rewindRateLimit(t *testing.T, key string, d time.Duration) {
+	t.Helper()
+
+	limit, err := rks.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	limit.Start = limit.Start.Add(-d)
+	limit.Until = limit.Until.Add(-d)
+	rks.Set(key, *limit, time.Hour)
+}
+
+func lockAccount(user User) {
+	ks.Set(fmt.Sprintf("lockout:%d", user.ID), user.ID, time.Minute)
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		strikes uint
+		want    time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{3, 4 * time.Minute},
+		{6, 32 * time.Minute},
+		{7, time.Hour},
+		{20, time.Hour},
+	}
+
+	for _, tt := range tests {
+		if got := backoff(tt.strikes); got != tt.want {
+			t.Errorf("backoff(%d) = %s, want %s", tt.strikes, got, tt.want)
+		}
+	}
+}
+
+func TestAllowRequest(t *testing.T) {
+	setLimits(t, 3, 0)
+	const key = "test:allow"
+
+	tests := []struct {
+		name    string
+		rewind  time.Duration // before the request
+		allowed bool
+		wait    time.Duration
+	}{
+		{"first", 0, true, 0},
+		{"second", 0, true, 0},
+		{"third", 0, true, 0},
+		{"over the limit", 0, false, time.Minute},
+		{"while blocked", 30 * time.Second, false, 30 * time.Second},
+		{"block over", 30 * time.Second, true, 0},
+		{"new window", 0, true, 0},
+		{"new window", 0, true, 0},
+		{"over again", 0, false, 2 * time.Minute},
+		{"block over again", 2 * time.Minute, true, 0},
+		{"window expired", rateLimitWindow, true, 0},
+		{"window expired", 0, true, 0},
+		{"window expired", 0, true, 0},
+		{"over a third time", 0, false, 4 * time.Minute},
+	}
+
+	for _, tt := range tests {
+		if tt.rewind > 0 {
+			rewindRateLimit(t, key, tt.rewind)
+		}
+
+		wait, allowed := allowRequest(key)
+		if allowed != tt.allowed {
+			t.Fatalf("%s: allowed %t, want %t", tt.name, allowed, tt.allowed)
+		}
+		if !allowed && (wait > tt.wait || wait < tt.wait-time.Second) {
+			t.Errorf("%s: wait %s, want %s", tt.name, wait, tt.wait)
+		}
+	}
+
+	setLimits(t, 0, 0)
+	for range 10 {
+		if _, allowed := allowRequest(key); !allowed {
+			t.Fatal("a request was refused with rate limiting disabled")
+		}
+	}
+}
+
+func TestLockout(t *testing.T) {
+	setLimits(t, 0, 3)
+	user := createTestUser(t, "lockout")
+	r := httptest.NewRequest(http.MethodPost, "/login", nil)
+
+	tests := []struct {
+		failures int
+		reset    bool // before the failures
+		locked   bool
+	}{
+		{2, false, false},
+		{0, true, false},
+		{2, false, false}, // the reset forgot the first two
+		{1, false, true},
+	}
+
+	for i, tt := range tests {
+		if tt.reset {
+			resetLoginFailures(user)
+		}
+		for range tt.failures {
+			recordLoginFailure(user, r, "wrong password")
+		}
+		if isLockedOut(user) != tt.locked {
+			t.Fatalf("step %d: locked %t, want %t", i, isLockedOut(user), tt.locked)
+		}
+	}
+
+	emails := queuedEmails(t, user.Email)
+	if len(emails) != 1 || !strings.Contains(emails[0].Body, "locked") {
+		t.Errorf("got %d emails, want the lockout one", len(emails))
+	}
+
+	var failures int64
+	db.Model(&AuditEvent{}).Where("user_id = ? AND event = ?", user.ID, auditLoginFailed).Count(&failures)
+	if failures != 5 {
+		t.Errorf("%d failures audited, want 5", failures)
+	}
+}
+
+func TestRateLimited(t *testing.T) {
+	setLimits(t, 2, 0)
+	handler := rateLimited("username", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})
+
+	send := func(ip, username string) *httptest.ResponseRecorder {
+		form := url.Values{"username": {username}}
+		req := httptest.NewRequest(http.MethodPost, "/login", strings.NewReader(form.Encode()))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = ip + ":1234"
+		rec := httptest.NewRecorder()
+		handler(rec, req)
+		return rec
+	}
+
+	tests := []struct {
+		name     string
+		ip       string
+		username string
+		status   int
+	}{
+		{"first", "198.51.100.1", "RateLimited", http.StatusNoContent},
+		{"second", "198.51.100.1", "other", http.StatusNoContent},
+		{"same IP", "198.51.100.1", "another", http.StatusTooManyRequests},
+		{"another IP", "198.51.100.2", "ratelimited ", http.StatusNoContent},
+		{"same account", "198.51.100.3", "ratelimited", http.StatusTooManyRequests},
+		{"no account", "198.51.100.4", "", http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		rec := send(tt.ip, tt.username)
+		if rec.Code != tt.status {
+			t.Errorf("%s: status %d, want %d", tt.name, rec.Code, tt.status)
+		}
+		if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: no Retry-After", tt.name)
+		}
+	}
+}
+
+func TestLockoutOnEveryLoginPath(t *testing.T) {
+	setupWebAuthn(t)
+	user := createTestUser(t, "lockedpaths")
+	a := registerPasskey(t, user, "Phone")
+	lockAccount(user)
+
+	c := newTestClient(t)
+	res := c.post("/login", url.Values{"username": {user.Username}, "password": {testPassword}})
+	if res.StatusCode != http.StatusTooManyRequests || c.userID() != 0 {
+		t.Errorf("password login: status %d", res.StatusCode)
+	}
+
+	c, res = loginWithPasskey(t, a)
+	if res.StatusCode != http.StatusTooManyRequests || c.userID() != 0 {
+		t.Errorf("passkey login: status %d", res.StatusCode)
+	}
+
+	ks.Set("reset:locked-reset", user.ID, time.Minute)
+	c = newTestClient(t)
+	res = c.post("/reset-password-confirm?token=locked-reset", url.Values{"password": {"Fresh-Kettle-Orbit9"}})
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("password reset: status %d", res.StatusCode)
+	}
+	if _, err := ks.Get("reset:locked-reset"); err != nil {
+		t.Error("the reset token was used up while locked")
+	}
+
+	var stored User
+	db.First(&stored, user.ID)
+	if stored.PasswordHash != user.PasswordHash {
+		t.Error("the password was reset while locked")
+	}
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"log"
+	"time"
+
+	"github.com/birabittoh/auth-boilerplate/src/notify"
+)
+
+const (
+	reminderInterval = time.Hour
+	reminderCooldown = 24 * time.Hour
+)
+
+func startReminders() {
+	ticker := time.NewTicker(reminderInterval)
+	for {
+		sendReminders()
+		<-ticker.C
+	}
+}
+
+func isOverdue(habit Habit) bool {
+	if habit.Negative || habit.Disabled {
+		return false
+	}
+	return toHabitDisplay(habit).Class == classBad
+}
+
+// Returns every overdue habit that was not reminded about in the last reminderCooldown,
+// skipping disabled accounts and those scheduled for deletion
+func getOverdueHabits() (overdue []Habit, err error) {
+	var habits []Habit
+	err = db.Model(&Habit{}).
+		Where("negative = ? AND disabled = ?", false, false).
+		Where("last_reminder IS NULL OR last_reminder < ?", time.Now().Add(-reminderCooldown)).
+		Where("user_id IN (?)", db.Model(&User{}).Select("id").Where("disabled = ? AND deletion_scheduled IS NULL", false)).
+		Find(&habits).Error
+	if err != nil {
+		return
+	}
+
+	for _, habit := range habits {
+		if isOverdue(habit) {
+			overdue = append(overdue, habit)
+		}
+	}
+	return
+}
+
+func sendReminders() {
+	habits, err := getOverdueHabits()
+	if err != nil {
+		log.Println("Could not get overdue habits:", err)
+		return
+	}
+
+	for _, habit := range habits {
+		err = notifyUser(habit.UserID, reminderMessage(habit))
+		if err != nil {
+			log.Printf("Could not send reminder for habit %d: %v", habit.ID, err)
+		}
+
+		now := time.Now()
+		habit.LastReminder = &now
+		db.Save(&habit)
+	}
+}
+
+func reminderMessage(habit Habit) notify.Message {
+	var user User
+	db.First(&user, habit.UserID)
+	l := userLocalizer(user)
+
+	body := l.T("You never did this.")
+	if habit.LastAck != nil {
+		body = l.T("Last time: %s.", l.Ago(*habit.LastAck))
+	}
+
+	return notify.Message{
+		Title: l.T("Time for \"%s\"", habit.Name),
+		Body:  body,
+		URL:   baseUrl + "/habits",
+	}
+}
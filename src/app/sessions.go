@@ -0,0 +1,90 @@
+package app
+
+import (
+	"sync"
+	"time"
+)
+
+// Sessions live in ks as "session:<token>"; this index lists the tokens of each user,
+// so they can be ended without walking the keystore
+var (
+	sessions   = map[uint]map[string]session{} // user ID -> token -> session
+	sessionsMu sync.Mutex
+)
+
+type session struct {
+	started time.Time
+	expires time.Time
+}
+
+func startSession(token string, userID uint, duration time.Duration) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	now := time.Now()
+	tokens := sessions[userID]
+	if tokens == nil {
+		tokens = map[string]session{}
+		sessions[userID] = tokens
+	}
+	for t, s := range tokens {
+		if s.expires.Before(now) {
+			delete(tokens, t)
+		}
+	}
+
+	tokens[token] = session{started: now, expires: now.Add(duration)}
+	ks.Set("session:"+token, userID, duration)
+}
+
+// Returns when the session with the given token was started, if it is still active
+func sessionStarted(token string) (started time.Time, ok bool) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	userID, err := ks.Get("session:" + token)
+	if err != nil {
+		return
+	}
+
+	s, ok := sessions[*userID][token]
+	return s.started, ok
+}
+
+// Ends a single session, like on logout
+func endSession(token string) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	userID, err := ks.Get("session:" + token)
+	if err == nil {
+		delete(sessions[*userID], token)
+		if len(sessions[*userID]) == 0 {
+			delete(sessions, *userID)
+		}
+	}
+	ks.Delete("session:" + token)
+}
+
+// Ends every session of a user except the one with the given token, if set; returns how many were active
+func endUserSessions(userID uint, except string) (ended int) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+
+	for token := range sessions[userID] {
+		if except != "" && token == except {
+			continue
+		}
+
+		if _, err := ks.Get("session:" + token); err == nil {
+			ended++
+		}
+		ks.Delete("session:" + token)
+		delete(sessions[userID], token)
+	}
+
+	if len(sessions[userID]) == 0 {
+		delete(sessions, userID)
+	}
+	return
+}
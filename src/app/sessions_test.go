@@ -0,0 +1,64 @@
+package app
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestEndUserSessions(t *testing.T) {
+	startSession("alice-1", 1001, time.Hour)
+	startSession("alice-2", 1001, time.Hour)
+	startSession("bob-1", 1002, time.Hour)
+
+	if n := endUserSessions(1001, "alice-2"); n != 1 {
+		t.Fatalf("ended %d sessions, want 1", n)
+	}
+	if _, err := ks.Get("session:alice-1"); err == nil {
+		t.Error("alice-1 is still valid")
+	}
+	if _, err := ks.Get("session:alice-2"); err != nil {
+		t.Error("the excepted session was ended")
+	}
+	if _, err := ks.Get("session:bob-1"); err != nil {
+		t.Error("another user's session was ended")
+	}
+
+	endSession("alice-2")
+	if _, err := ks.Get("session:alice-2"); err == nil {
+		t.Error("alice-2 is still valid after logout")
+	}
+	if n := endUserSessions(1001, ""); n != 0 {
+		t.Errorf("ended %d sessions after logout, want 0", n)
+	}
+	endUserSessions(1002, "")
+}
+
+func TestSessionsConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				token := fmt.Sprintf("race-%d-%d", i, j)
+				startSession(token, uint(i%2)+1010, time.Hour)
+				ks.Set("reset:"+token, 1, time.Hour)
+				if j%3 == 0 {
+					endSession(token)
+				}
+				if j%10 == 0 {
+					endUserSessions(uint(i%2)+1010, "")
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	endUserSessions(1010, "")
+	endUserSessions(1011, "")
+	if len(sessions[1010])+len(sessions[1011]) != 0 {
+		t.Error("sessions are left in the index")
+	}
+}
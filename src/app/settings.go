@@ -0,0 +1,184 @@
+package app
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// How recently accounts without a password must have logged in to make sensitive changes
+const reauthWindow = 10 * time.Minute
+
+// Ends every session of a user, except the one with the given token if set
+func revokeSessions(r *http.Request, userID uint, except string) {
+	revoked := endUserSessions(userID, except)
+	if revoked > 0 {
+		audit(r, userID, auditSessionsRevoked, fmt.Sprintf("%d sessions", revoked))
+	}
+}
+
+// Confirms the user before a sensitive change, writing the error if it fails.
+// Accounts without a password (single sign-on, or reset by an admin) must have signed in recently instead.
+func reauthenticate(w http.ResponseWriter, r *http.Request, user User, password string) bool {
+	if user.PasswordHash != "" {
+		if g.CheckPassword(password, user.Salt, user.PasswordHash) {
+			return true
+		}
+		httpError(w, r, "Invalid password.", http.StatusUnauthorized)
+		return false
+	}
+
+	cookie, err := r.Cookie("session_token")
+	if err == nil {
+		started, ok := sessionStarted(cookie.Value)
+		if ok && time.Since(started) < reauthWindow {
+			return true
+		}
+	}
+	httpError(w, r, "Log in again to confirm this change.", http.StatusUnauthorized)
+	return false
+}
+
+func renderSettings(w http.ResponseWriter, r *http.Request, status int, user User, passwordProblems []string) {
+	var identities []Identity
+	db.Where(&Identity{UserID: user.ID}).Find(&identities)
+
+	data := map[string]interface{}{
+		"User":             user,
+		"HasPassword":      user.PasswordHash != "",
+		"ReauthMinutes":    int(reauthWindow / time.Minute),
+		"GracePeriod":      int((deletionGracePeriod + durationDay - 1) / durationDay),
+		"MinLength":        passwordPolicy.MinLength,
+		"PasswordProblems": passwordProblems,
+		"Languages":        languageOptions(),
+		"Identities":       identities,
+	}
+	if o != nil {
+		data["OIDC"] = o.Name
+	}
+
+	executeTemplate(w, r, status, "settings.tmpl", data)
+}
+
+func getSettingsHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "Could not find user in context.", http.StatusInternalServerError)
+		return
+	}
+
+	renderSettings(w, r, http.StatusOK, user, nil)
+}
+
+func postSettingsUsernameHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	username, err := sanitizeUsername(r.FormValue("username"))
+	if err != nil {
+		httpError(w, r, "Invalid username.", http.StatusBadRequest)
+		return
+	}
+
+	_, err = getUserByName(username, user.ID)
+	if err == nil {
+		httpError(w, r, "This username is already registered.", http.StatusConflict)
+		return
+	}
+
+	if username != user.Username {
+		audit(r, user.ID, auditUsernameChanged, fmt.Sprintf("from %s to %s", user.Username, username))
+		user.Username = username
+		db.Save(&user)
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusFound)
+}
+
+func postSettingsEmailHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !reauthenticate(w, r, user, r.FormValue("password")) {
+		return
+	}
+
+	address, err := sanitizeEmail(r.FormValue("email"))
+	if err != nil {
+		httpError(w, r, "Invalid email.", http.StatusBadRequest)
+		return
+	}
+
+	// the current address stays until the new one is confirmed
+	if address == user.Email {
+		db.Model(&user).Update("pending_email", "")
+		http.Redirect(w, r, "/settings", http.StatusFound)
+		return
+	}
+
+	var count int64
+	db.Model(&User{}).Where("email = ?", address).Count(&count)
+	if count > 0 {
+		httpError(w, r, "This email is already registered.", http.StatusConflict)
+		return
+	}
+
+	err = db.Model(&user).Update("pending_email", address).Error
+	if err != nil {
+		httpError(w, r, "Could not change email.", http.StatusInternalServerError)
+		return
+	}
+	user.PendingEmail = address
+	audit(r, user.ID, auditEmailChangeRequested, "to "+address)
+
+	sendEmailChangeEmails(user)
+
+	http.Redirect(w, r, "/settings", http.StatusFound)
+}
+
+func postSettingsPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !reauthenticate(w, r, user, r.FormValue("current_password")) {
+		return
+	}
+
+	if r.FormValue("password") != r.FormValue("confirm_password") {
+		httpError(w, r, "Passwords do not match.", http.StatusBadRequest)
+		return
+	}
+
+	problems := checkNewPassword(r, r.FormValue("password"), user.Username, user.Email)
+	if len(problems) > 0 {
+		renderSettings(w, r, http.StatusBadRequest, user, problems)
+		return
+	}
+
+	hashedPassword, err := g.HashPassword(r.FormValue("password"))
+	if err != nil {
+		httpError(w, r, "Invalid password.", http.StatusBadRequest)
+		return
+	}
+
+	user.PasswordHash = hashedPassword
+	user.Salt = "" // only legacy hashes have a separate salt
+	db.Save(&user)
+	audit(r, user.ID, auditPasswordChanged, "")
+
+	cookie, err := r.Cookie("session_token")
+	if err == nil {
+		revokeSessions(r, user.ID, cookie.Value)
+	}
+
+	http.Redirect(w, r, "/settings", http.StatusFound)
+}
@@ -0,0 +1,115 @@
+package app
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+var confirmLink = regexp.MustCompile(`/confirm-email\?token=[^\s"]+`)
+
+func TestEmailChange(t *testing.T) {
+	user := createTestUser(t, "emailchange")
+	c := newTestClient(t)
+	c.login(user)
+
+	res := c.post("/settings/email", url.Values{"email": {"emailchange-new@example.com"}, "password": {"wrong"}})
+	if res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("wrong password: status %d", res.StatusCode)
+	}
+
+	res = c.post("/settings/email", url.Values{"email": {"emailchange-new@example.com"}, "password": {testPassword}})
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("change email: status %d", res.StatusCode)
+	}
+
+	// the old address stays in use, and is told about the change
+	db.First(&user, user.ID)
+	if user.Email != "emailchange@example.com" || !user.Verified || user.PendingEmail != "emailchange-new@example.com" {
+		t.Errorf("after the request: email %q, verified %v, pending %q", user.Email, user.Verified, user.PendingEmail)
+	}
+	notices := queuedEmails(t, "emailchange@example.com")
+	if len(notices) != 1 || !strings.Contains(notices[0].Body, "emailchange-new@example.com") || confirmLink.MatchString(notices[0].Body) {
+		t.Errorf("notice to the old address: %+v", notices)
+	}
+
+	confirmations := queuedEmails(t, "emailchange-new@example.com")
+	if len(confirmations) != 1 {
+		t.Fatalf("%d confirmation emails", len(confirmations))
+	}
+	link := confirmLink.FindString(confirmations[0].Body)
+
+	res = newTestClient(t).get(link)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("confirm: status %d", res.StatusCode)
+	}
+	db.First(&user, user.ID)
+	if user.Email != "emailchange-new@example.com" || !user.Verified || user.PendingEmail != "" {
+		t.Errorf("after confirming: email %q, verified %v, pending %q", user.Email, user.Verified, user.PendingEmail)
+	}
+
+	if res := newTestClient(t).get(link); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("confirming twice: status %d", res.StatusCode)
+	}
+}
+
+func TestEmailChangeSupersededLink(t *testing.T) {
+	user := createTestUser(t, "emailsuperseded")
+	taken := createTestUser(t, "emailtaken")
+	c := newTestClient(t)
+	c.login(user)
+
+	res := c.post("/settings/email", url.Values{"email": {taken.Email}, "password": {testPassword}})
+	if res.StatusCode != http.StatusConflict {
+		t.Errorf("registered address: status %d", res.StatusCode)
+	}
+
+	c.post("/settings/email", url.Values{"email": {"emailsuperseded-first@example.com"}, "password": {testPassword}})
+	first := confirmLink.FindString(queuedEmails(t, "emailsuperseded-first@example.com")[0].Body)
+	c.post("/settings/email", url.Values{"email": {"emailsuperseded-second@example.com"}, "password": {testPassword}})
+
+	if res := newTestClient(t).get(first); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("superseded link: status %d", res.StatusCode)
+	}
+	db.First(&user, user.ID)
+	if user.Email != "emailsuperseded@example.com" {
+		t.Errorf("email changed to %q by a superseded link", user.Email)
+	}
+}
+
+func TestReauthenticateWithoutPassword(t *testing.T) {
+	user := createTestUser(t, "nopassword")
+	db.Model(&user).Update("password_hash", "")
+
+	c := newTestClient(t)
+	c.login(user)
+	form := url.Values{"email": {"nopassword-new@example.com"}}
+	if res := c.post("/settings/email", form); res.StatusCode != http.StatusFound {
+		t.Errorf("fresh login: status %d", res.StatusCode)
+	}
+
+	// a session older than the window, like a stolen cookie
+	old := newTestClient(t)
+	old.login(user)
+	token := old.cookies["session_token"].Value
+	sessionsMu.Lock()
+	s := sessions[user.ID][token]
+	s.started = time.Now().Add(-reauthWindow - time.Minute)
+	sessions[user.ID][token] = s
+	sessionsMu.Unlock()
+
+	for _, target := range []string{"/settings/email", "/settings/password", "/settings/delete"} {
+		form := url.Values{"email": {"nopassword-other@example.com"}, "password": {"Brand-New-Secret-42"}, "confirm_password": {"Brand-New-Secret-42"}}
+		if res := old.post(target, form); res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%s with an old session: status %d, want %d", target, res.StatusCode, http.StatusUnauthorized)
+		}
+	}
+
+	db.First(&user, user.ID)
+	if user.PendingEmail != "nopassword-new@example.com" || user.PasswordHash != "" || user.DeletionScheduled != nil {
+		t.Errorf("an old session changed the account: %+v", user)
+	}
+}
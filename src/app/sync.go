@@ -0,0 +1,104 @@
+package app
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+type syncAck struct {
+	Key     string    `json:"key"`
+	HabitID uint      `json:"habit_id"`
+	AckedAt time.Time `json:"acked_at"`
+}
+
+type syncRequest struct {
+	Acks []syncAck `json:"acks"`
+}
+
+type syncResult struct {
+	Key    string `json:"key"`
+	Status string `json:"status"`
+}
+
+const (
+	syncStatusOK        = "ok"
+	syncStatusDuplicate = "duplicate"
+	syncStatusCooldown  = "cooldown"
+	syncStatusNotFound  = "not_found"
+	syncStatusInvalid   = "invalid"
+	syncStatusError     = "error"
+
+	maxSyncAcks   = 100
+	maxSyncAckAge = 30 * 24 * time.Hour
+)
+
+func syncHabitAck(userID uint, a syncAck) string {
+	if a.Key == "" || len(a.Key) > 64 || a.AckedAt.IsZero() {
+		return syncStatusInvalid
+	}
+
+	now := time.Now()
+	if a.AckedAt.Before(now.Add(-maxSyncAckAge)) {
+		return syncStatusInvalid
+	}
+
+	// clocks on phones drift, never record acks in the future
+	at := a.AckedAt.Local()
+	if at.After(now) {
+		at = now
+	}
+
+	habit, err := getHabit(a.HabitID)
+	if err != nil || habit.ID == 0 || habit.UserID != userID {
+		return syncStatusNotFound
+	}
+
+	err = ackHabitAt(&habit, at, a.Key)
+	switch {
+	case errors.Is(err, errAckDuplicate):
+		return syncStatusDuplicate
+	case errors.Is(err, errAckCooldown):
+		return syncStatusCooldown
+	case err != nil:
+		return syncStatusError
+	}
+	return syncStatusOK
+}
+
+// Records acks queued by the PWA while offline, oldest first
+func postSyncHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	var req syncRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64*1024)).Decode(&req)
+	if err != nil || len(req.Acks) > maxSyncAcks {
+		httpError(w, r, "Invalid sync request.", http.StatusBadRequest)
+		return
+	}
+
+	sort.SliceStable(req.Acks, func(i, j int) bool {
+		return req.Acks[i].AckedAt.Before(req.Acks[j].AckedAt)
+	})
+
+	results := make([]syncResult, len(req.Acks))
+	for i, a := range req.Acks {
+		results[i] = syncResult{Key: a.Key, Status: syncHabitAck(user.ID, a)}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"results": results})
+}
+
+// The service worker lives in static/ but must be served from the root to control every page
+func getServiceWorkerHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "no-cache")
+	http.ServeFile(w, r, filepath.Join("static", "sw.js"))
+}
@@ -0,0 +1,108 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyncIdempotencyKeyPerUser(t *testing.T) {
+	alice := createTestUser(t, "syncalice")
+	bob := createTestUser(t, "syncbob")
+
+	aliceHabit := Habit{UserID: alice.ID, Name: "Water", Days: 1}
+	bobHabit := Habit{UserID: bob.ID, Name: "Water", Days: 1}
+	db.Create(&aliceHabit)
+	db.Create(&bobHabit)
+
+	at := time.Now().Add(-time.Hour)
+	if status := syncHabitAck(alice.ID, syncAck{Key: "same-key", HabitID: aliceHabit.ID, AckedAt: at}); status != syncStatusOK {
+		t.Fatalf("first ack: %s", status)
+	}
+	if status := syncHabitAck(bob.ID, syncAck{Key: "same-key", HabitID: bobHabit.ID, AckedAt: at}); status != syncStatusOK {
+		t.Errorf("another user with the same key: %s, want %s", status, syncStatusOK)
+	}
+	if status := syncHabitAck(alice.ID, syncAck{Key: "same-key", HabitID: aliceHabit.ID, AckedAt: at}); status != syncStatusDuplicate {
+		t.Errorf("same user with the same key: %s, want %s", status, syncStatusDuplicate)
+	}
+
+	var acks []Ack
+	db.Where("idempotency_key = ?", "same-key").Find(&acks)
+	if len(acks) != 2 {
+		t.Fatalf("got %d acks, want 2", len(acks))
+	}
+	for _, ack := range acks {
+		if ack.UserID == 0 {
+			t.Errorf("ack %d has no user", ack.ID)
+		}
+	}
+}
+
+func TestPagesAreNotStored(t *testing.T) {
+	user := createTestUser(t, "syncnostore")
+	c := newTestClient(t)
+	c.login(user)
+
+	for _, path := range []string{"/habits", "/offline"} {
+		res := c.get(path)
+		if res.StatusCode != http.StatusOK {
+			t.Fatalf("%s: status %d", path, res.StatusCode)
+		}
+		if cc := res.Header.Get("Cache-Control"); cc != "no-store" {
+			t.Errorf("%s: Cache-Control %q, want no-store", path, cc)
+		}
+	}
+}
+
+// Pages rendered with an error status must not be stored either
+func TestErrorPagesAreNotStored(t *testing.T) {
+	user := createTestUser(t, "syncerrpage")
+	c := newTestClient(t)
+	c.login(user)
+
+	res := c.post("/settings/password", url.Values{
+		"current_password": {testPassword},
+		"password":         {"short"},
+		"confirm_password": {"short"},
+	})
+	if res.StatusCode != http.StatusBadRequest {
+		t.Fatalf("weak password: status %d", res.StatusCode)
+	}
+	if cc := res.Header.Get("Cache-Control"); cc != "no-store" {
+		t.Errorf("weak password: Cache-Control %q, want no-store", cc)
+	}
+}
+
+func TestOfflineShell(t *testing.T) {
+	user := createTestUser(t, "syncshell")
+	db.Create(&Habit{UserID: user.ID, Name: "Floss </script>", Days: 1})
+	c := newTestClient(t)
+	c.login(user)
+
+	// the shell is cached without cookies and shared by every session
+	body := readBody(t, c.get("/offline"))
+	if strings.Contains(body, "syncshell") || strings.Contains(body, "Floss") {
+		t.Error("the offline shell holds the user's data")
+	}
+	if !strings.Contains(body, `<meta name="csrf-token" content="">`) {
+		t.Error("the offline shell holds a CSRF token")
+	}
+	if !strings.Contains(body, `id="offline-habits"`) {
+		t.Error("the offline shell has no habit list")
+	}
+
+	// the habits page hands its habits to the shell
+	body = readBody(t, c.get("/habits"))
+	_, snapshot, _ := strings.Cut(body, `<script type="application/json" id="habits-snapshot">`)
+	snapshot, _, _ = strings.Cut(snapshot, "</script>")
+	var habits struct{ Positive, Negative []HabitDisplay }
+	if err := json.Unmarshal([]byte(snapshot), &habits); err != nil {
+		t.Fatalf("snapshot %q: %v", snapshot, err)
+	}
+	if len(habits.Positive) != 1 || habits.Positive[0].Name != "Floss </script>" {
+		t.Errorf("snapshot %q", snapshot)
+	}
+}
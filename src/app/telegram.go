@@ -0,0 +1,277 @@
+package app
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/birabittoh/auth-boilerplate/src/i18n"
+	"github.com/birabittoh/auth-boilerplate/src/notify"
+	"github.com/birabittoh/auth-boilerplate/src/telegram"
+)
+
+// Delivers messages to the Telegram chat linked to a user
+type telegramNotifier struct {
+	chatID int64
+}
+
+const telegramLinkDuration = 10 * time.Minute
+
+var classEmoji = map[string]string{
+	classGood: "🟢",
+	classWarn: "🟡",
+	classBad:  "🔴",
+	"":        "⚪",
+}
+
+func loadTelegramConfig() *telegram.Bot {
+	token := os.Getenv("APP_TELEGRAM_TOKEN")
+	if token == "" {
+		return nil
+	}
+
+	bot, err := telegram.NewBot(token, os.Getenv("APP_TELEGRAM_API_URL"))
+	if err != nil {
+		log.Println("Could not init Telegram bot:", err)
+		return nil
+	}
+	return bot
+}
+
+func startTelegramBot() {
+	if tg == nil {
+		return
+	}
+
+	log.Println("Telegram bot: @" + tg.Username)
+	err := tg.Poll(handleTelegramUpdate)
+	log.Println("Telegram bot stopped:", err)
+}
+
+func (n telegramNotifier) Notify(message notify.Message) error {
+	return tg.SendMessage(n.chatID, message.Text(), nil)
+}
+
+// Chats of disabled accounts are treated as not linked
+func getUserByTelegramChat(chatID int64) (user User, err error) {
+	err = db.Model(&User{}).Where("telegram_chat_id = ? AND disabled = ?", chatID, false).First(&user).Error
+	return
+}
+
+// Chats that are not linked yet get the default language
+func telegramLocalizer(chatID int64) *i18n.Localizer {
+	user, err := getUserByTelegramChat(chatID)
+	if err != nil {
+		return tr.Localizer()
+	}
+	return userLocalizer(user)
+}
+
+func handleTelegramUpdate(update telegram.Update) {
+	var err error
+	switch {
+	case update.Message != nil:
+		err = handleTelegramMessage(update.Message)
+	case update.CallbackQuery != nil:
+		err = handleTelegramCallback(update.CallbackQuery)
+	}
+
+	if err != nil {
+		log.Println("Could not handle Telegram update:", err)
+	}
+}
+
+func handleTelegramMessage(message *telegram.Message) error {
+	chatID := message.Chat.ID
+
+	fields := strings.Fields(message.Text)
+	if len(fields) == 0 {
+		return nil
+	}
+
+	// everyone in a group could read and ack the habits of the linked account
+	if message.Chat.Type != telegram.ChatPrivate {
+		if !strings.HasPrefix(fields[0], "/") {
+			return nil
+		}
+		return tg.SendMessage(chatID, tr.Localizer().T("This bot only works in private chats."), nil)
+	}
+
+	l := telegramLocalizer(chatID)
+	command, _, _ := strings.Cut(fields[0], "@")
+	switch command {
+	case "/start", "/link":
+		if len(fields) < 2 {
+			return tg.SendMessage(chatID, l.T("Link this chat from the notifications page of WellBinge:")+"\n"+baseUrl+"/notifications", nil)
+		}
+		return linkTelegramChat(chatID, fields[1])
+	case "/unlink":
+		if user, err := getUserByTelegramChat(chatID); err == nil {
+			audit(nil, user.ID, auditTelegramUnlinked, "from the chat")
+		}
+		db.Model(&User{}).Where("telegram_chat_id = ?", chatID).Update("telegram_chat_id", nil)
+		return tg.SendMessage(chatID, l.T("This chat is not linked anymore."), nil)
+	case "/list":
+		return sendTelegramHabits(chatID)
+	}
+
+	return tg.SendMessage(chatID, l.T("Commands:")+"\n/list - "+l.T("show and ack your habits")+"\n/unlink - "+l.T("unlink this chat"), nil)
+}
+
+func linkTelegramChat(chatID int64, code string) error {
+	userID, err := ks.Get("telegram:" + code)
+	if err != nil {
+		return tg.SendMessage(chatID, tr.Localizer().T("This code is invalid or expired."), nil)
+	}
+	ks.Delete("telegram:" + code)
+
+	var user User
+	err = db.First(&user, *userID).Error
+	if err != nil {
+		return err
+	}
+	if user.Disabled {
+		return tg.SendMessage(chatID, tr.Localizer().T("This code is invalid or expired."), nil)
+	}
+
+	db.Model(&User{}).Where("telegram_chat_id = ?", chatID).Update("telegram_chat_id", nil)
+	user.TelegramChatID = &chatID
+	err = db.Save(&user).Error
+	if err != nil {
+		return err
+	}
+	audit(nil, user.ID, auditTelegramLinked, "")
+
+	return tg.SendMessage(chatID, userLocalizer(user).T("Linked to %s. Send /list to see your habits.", user.Username), nil)
+}
+
+func sendTelegramHabits(chatID int64) error {
+	user, err := getUserByTelegramChat(chatID)
+	if err != nil {
+		return tg.SendMessage(chatID, tr.Localizer().T("This chat is not linked to any account."), nil)
+	}
+
+	positive, negative, err := getAllHabits(user.ID)
+	if err != nil {
+		return err
+	}
+
+	l := userLocalizer(user)
+	if len(positive)+len(negative) == 0 {
+		return tg.SendMessage(chatID, l.T("You have no habits yet."), nil)
+	}
+
+	var text strings.Builder
+	var keyboard [][]telegram.InlineKeyboardButton
+	for _, group := range []struct {
+		title  string
+		habits []HabitDisplay
+	}{{l.T("Positive habits"), positive}, {l.T("Negative habits"), negative}} {
+		if len(group.habits) == 0 {
+			continue
+		}
+
+		text.WriteString(group.title + "\n")
+		for _, habit := range group.habits {
+			lastAck := "-"
+			if habit.LastAck != nil {
+				lastAck = l.Ago(*habit.LastAck)
+			}
+			fmt.Fprintf(&text, "%s %s - %s\n", classEmoji[habit.Class], habit.Name, lastAck)
+			if habit.Disabled {
+				continue
+			}
+			keyboard = append(keyboard, []telegram.InlineKeyboardButton{{
+				Text:         l.T("Ack %s", habit.Name),
+				CallbackData: "ack:" + strconv.FormatUint(uint64(habit.ID), 10),
+			}})
+		}
+		text.WriteString("\n")
+	}
+
+	return tg.SendMessage(chatID, strings.TrimSpace(text.String()), &telegram.InlineKeyboardMarkup{InlineKeyboard: keyboard})
+}
+
+func handleTelegramCallback(query *telegram.CallbackQuery) error {
+	action, value, _ := strings.Cut(query.Data, ":")
+	if action != "ack" || query.Message == nil {
+		return tg.AnswerCallbackQuery(query.ID, "")
+	}
+
+	// in a private chat, the chat ID is the ID of the only user in it
+	if query.Message.Chat.Type != telegram.ChatPrivate || query.From.ID != query.Message.Chat.ID {
+		return tg.AnswerCallbackQuery(query.ID, tr.Localizer().T("This chat is not linked to any account."))
+	}
+
+	user, err := getUserByTelegramChat(query.Message.Chat.ID)
+	if err != nil {
+		return tg.AnswerCallbackQuery(query.ID, tr.Localizer().T("This chat is not linked to any account."))
+	}
+
+	l := userLocalizer(user)
+	id, _ := strconv.ParseUint(value, 10, 64)
+	habit, err := getHabit(uint(id))
+	if err != nil || habit.ID == 0 || habit.UserID != user.ID {
+		return tg.AnswerCallbackQuery(query.ID, l.T("Habit not found."))
+	}
+
+	err = ackHabit(&habit)
+	if errors.Is(err, errAckCooldown) {
+		return tg.AnswerCallbackQuery(query.ID, l.T("Habit was acked too recently."))
+	}
+	if err != nil {
+		tg.AnswerCallbackQuery(query.ID, l.T("Could not ack habit."))
+		return err
+	}
+	audit(nil, user.ID, auditTelegramUsed, habit.Name)
+
+	return tg.AnswerCallbackQuery(query.ID, l.T("Acked %s.", habit.Name))
+}
+
+func postTelegramLinkHandler(w http.ResponseWriter, r *http.Request) {
+	if tg == nil {
+		httpError(w, r, "Telegram is not available on this instance.", http.StatusNotFound)
+		return
+	}
+
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	code, err := g.GenerateRandomToken(8)
+	if err != nil {
+		httpError(w, r, "Could not generate link code.", http.StatusInternalServerError)
+		return
+	}
+
+	ks.Set("telegram:"+code, user.ID, telegramLinkDuration)
+
+	data := map[string]interface{}{
+		"Code":     code,
+		"Username": tg.Username,
+	}
+
+	executeTemplate(w, r, http.StatusOK, "telegram-link.tmpl", data)
+}
+
+func postTelegramUnlinkHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if user.TelegramChatID != nil {
+		db.Model(&user).Update("telegram_chat_id", nil)
+		audit(r, user.ID, auditTelegramUnlinked, "")
+	}
+
+	http.Redirect(w, r, "/notifications", http.StatusFound)
+}
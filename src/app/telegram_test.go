@@ -0,0 +1,210 @@
+package app
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/birabittoh/auth-boilerplate/src/telegram"
+)
+
+// A Bot API server that records what the bot sends
+type fakeTelegram struct {
+	messages map[int64][]map[string]interface{}
+	answers  map[string]string
+}
+
+// Points tg at a fake Bot API server for the duration of the test
+func newFakeTelegram(t *testing.T) *fakeTelegram {
+	t.Helper()
+
+	f := &fakeTelegram{messages: map[int64][]map[string]interface{}{}, answers: map[string]string{}}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var params map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&params)
+
+		var result interface{} = true
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/getMe"):
+			result = telegram.User{ID: 1, Username: "wellbinge_bot"}
+		case strings.HasSuffix(r.URL.Path, "/sendMessage"):
+			chatID := int64(params["chat_id"].(float64))
+			f.messages[chatID] = append(f.messages[chatID], params)
+		case strings.HasSuffix(r.URL.Path, "/answerCallbackQuery"):
+			f.answers[params["callback_query_id"].(string)] = params["text"].(string)
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+	}))
+	t.Cleanup(server.Close)
+
+	bot, err := telegram.NewBot("123:token", server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	previous := tg
+	tg = bot
+	t.Cleanup(func() { tg = previous })
+	return f
+}
+
+// Returns the text of the last message sent to the chat
+func (f *fakeTelegram) last(chatID int64) string {
+	messages := f.messages[chatID]
+	if len(messages) == 0 {
+		return ""
+	}
+	return messages[len(messages)-1]["text"].(string)
+}
+
+func privateMessage(chatID int64, text string) telegram.Update {
+	return telegram.Update{Message: &telegram.Message{
+		From: &telegram.User{ID: chatID},
+		Chat: telegram.Chat{ID: chatID, Type: telegram.ChatPrivate},
+		Text: text,
+	}}
+}
+
+func callback(id string, from, chatID int64, chatType, data string) telegram.Update {
+	return telegram.Update{CallbackQuery: &telegram.CallbackQuery{
+		ID:      id,
+		From:    telegram.User{ID: from},
+		Message: &telegram.Message{Chat: telegram.Chat{ID: chatID, Type: chatType}},
+		Data:    data,
+	}}
+}
+
+func TestTelegramLink(t *testing.T) {
+	f := newFakeTelegram(t)
+	user := createTestUser(t, "telegramlink")
+	const chatID = 710001
+
+	handleTelegramUpdate(privateMessage(chatID, "/start not-a-code"))
+	if !strings.Contains(f.last(chatID), "invalid or expired") {
+		t.Errorf("bad code answered %q", f.last(chatID))
+	}
+	if _, err := getUserByTelegramChat(chatID); err == nil {
+		t.Fatal("a bad code linked the chat")
+	}
+
+	ks.Set("telegram:good-code", user.ID, telegramLinkDuration)
+	handleTelegramUpdate(privateMessage(chatID, "/start good-code"))
+	if linked, err := getUserByTelegramChat(chatID); err != nil || linked.ID != user.ID {
+		t.Fatalf("valid code did not link the chat: %v", err)
+	}
+	if !strings.Contains(f.last(chatID), "telegramlink") {
+		t.Errorf("link answered %q", f.last(chatID))
+	}
+
+	// codes are single use
+	const otherChat = 710002
+	handleTelegramUpdate(privateMessage(otherChat, "/start good-code"))
+	if _, err := getUserByTelegramChat(otherChat); err == nil {
+		t.Error("a code linked a second chat")
+	}
+
+	handleTelegramUpdate(privateMessage(chatID, "/unlink"))
+	if _, err := getUserByTelegramChat(chatID); err == nil {
+		t.Error("/unlink kept the chat linked")
+	}
+}
+
+func TestTelegramRefusesGroups(t *testing.T) {
+	f := newFakeTelegram(t)
+	user := createTestUser(t, "telegramgroup")
+	const groupID = -710003
+
+	ks.Set("telegram:group-code", user.ID, telegramLinkDuration)
+	handleTelegramUpdate(telegram.Update{Message: &telegram.Message{
+		From: &telegram.User{ID: 710004},
+		Chat: telegram.Chat{ID: groupID, Type: "group"},
+		Text: "/start@wellbinge_bot group-code",
+	}})
+
+	if _, err := getUserByTelegramChat(groupID); err == nil {
+		t.Error("a group chat was linked")
+	}
+	if !strings.Contains(f.last(groupID), "private chats") {
+		t.Errorf("group answered %q", f.last(groupID))
+	}
+}
+
+func TestTelegramList(t *testing.T) {
+	f := newFakeTelegram(t)
+	user := createTestUser(t, "telegramlist")
+	const chatID = 710005
+
+	handleTelegramUpdate(privateMessage(chatID, "/list"))
+	if !strings.Contains(f.last(chatID), "not linked") {
+		t.Errorf("/list of an unlinked chat answered %q", f.last(chatID))
+	}
+
+	db.Model(&user).Update("telegram_chat_id", chatID)
+	handleTelegramUpdate(privateMessage(chatID, "/list"))
+	if f.last(chatID) != "You have no habits yet." {
+		t.Errorf("/list without habits answered %q", f.last(chatID))
+	}
+
+	habit := Habit{UserID: user.ID, Name: "Stretch", Days: 1}
+	db.Create(&habit)
+	handleTelegramUpdate(privateMessage(chatID, "/list"))
+
+	messages := f.messages[chatID]
+	sent := messages[len(messages)-1]
+	if !strings.Contains(sent["text"].(string), "Stretch") {
+		t.Errorf("/list answered %q", sent["text"])
+	}
+	keyboard, _ := json.Marshal(sent["reply_markup"])
+	if !strings.Contains(string(keyboard), `"callback_data":"ack:`) {
+		t.Errorf("/list keyboard %s", keyboard)
+	}
+}
+
+func TestTelegramCallbackAck(t *testing.T) {
+	f := newFakeTelegram(t)
+	user := createTestUser(t, "telegramack")
+	other := createTestUser(t, "telegramackother")
+	const chatID = 710006
+	db.Model(&user).Update("telegram_chat_id", chatID)
+
+	habit := Habit{UserID: user.ID, Name: "Read", Days: 1}
+	otherHabit := Habit{UserID: other.ID, Name: "Run", Days: 1}
+	db.Create(&habit)
+	db.Create(&otherHabit)
+
+	data := "ack:" + strconv.FormatUint(uint64(habit.ID), 10)
+	tests := []struct {
+		update telegram.Update
+		answer string
+		acked  bool
+	}{
+		{callback("foreign", chatID, chatID, telegram.ChatPrivate, "ack:"+strconv.FormatUint(uint64(otherHabit.ID), 10)), "Habit not found.", false},
+		{callback("stranger", 710007, chatID, telegram.ChatPrivate, data), "This chat is not linked to any account.", false},
+		{callback("group", chatID, chatID, "group", data), "This chat is not linked to any account.", false},
+		{callback("owner", chatID, chatID, telegram.ChatPrivate, data), "Acked Read.", true},
+		{callback("again", chatID, chatID, telegram.ChatPrivate, data), "Habit was acked too recently.", true},
+	}
+
+	for _, tt := range tests {
+		handleTelegramUpdate(tt.update)
+		id := tt.update.CallbackQuery.ID
+		if f.answers[id] != tt.answer {
+			t.Errorf("%s: answered %q, want %q", id, f.answers[id], tt.answer)
+		}
+
+		var count int64
+		db.Model(&Ack{}).Where("habit_id = ?", habit.ID).Count(&count)
+		if (count > 0) != tt.acked {
+			t.Errorf("%s: habit has %d acks", id, count)
+		}
+	}
+
+	var count int64
+	db.Model(&Ack{}).Where("habit_id = ?", otherHabit.ID).Count(&count)
+	if count != 0 {
+		t.Error("a habit of another user was acked")
+	}
+}
@@ -0,0 +1,285 @@
+package app
+
+import (
+	"encoding/base64"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/skip2/go-qrcode"
+)
+
+const (
+	totpIssuer         = "WellBinge"
+	totpSetupPurpose   = "totp-setup"
+	totpSetupDuration  = 15 * time.Minute
+	totpLoginDuration  = 5 * time.Minute
+	totpMaxAttempts    = 5
+	recoveryCodesCount = 10
+)
+
+func hasTwoFactor(user User) bool {
+	return user.TOTPSecret != ""
+}
+
+func generateRecoveryCodes(user User) ([]string, error) {
+	codes, err := g.GenerateRecoveryCodes(recoveryCodesCount)
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Unscoped().Where(&RecoveryCode{UserID: user.ID}).Delete(&RecoveryCode{}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, code := range codes {
+		err = db.Create(&RecoveryCode{UserID: user.ID, Hash: g.HashRecoveryCode(code)}).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return codes, nil
+}
+
+// Records a TOTP step as used, failing if it or a later one already was.
+// The check is in the update so that two concurrent requests cannot both use a code.
+func useTOTPStep(user *User, step int64) bool {
+	res := db.Model(&User{}).Where("id = ? AND totp_last_step < ?", user.ID, step).Update("totp_last_step", step)
+	if res.Error != nil || res.RowsAffected != 1 {
+		return false
+	}
+	user.TOTPLastStep = step
+	return true
+}
+
+// Accepts either a TOTP code or an unused recovery code, which is then burned
+func checkSecondFactor(user *User, code string) bool {
+	step, ok := g.ValidateTOTP(user.TOTPSecret, code, time.Now(), user.TOTPLastStep)
+	if ok {
+		return useTOTPStep(user, step)
+	}
+
+	res := db.Unscoped().Where(&RecoveryCode{UserID: user.ID, Hash: g.HashRecoveryCode(code)}).Delete(&RecoveryCode{})
+	return res.Error == nil && res.RowsAffected == 1
+}
+
+// Confirms a change to the second factor with the password, writing the error if it fails.
+// Accounts without a password use a current TOTP code instead.
+func confirmTwoFactorChange(w http.ResponseWriter, r *http.Request, user *User) bool {
+	if user.PasswordHash != "" {
+		return reauthenticate(w, r, *user, r.FormValue("password"))
+	}
+
+	step, ok := g.ValidateTOTP(user.TOTPSecret, r.FormValue("code"), time.Now(), user.TOTPLastStep)
+	if !ok || !useTOTPStep(user, step) {
+		httpError(w, r, "Invalid code.", http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// Replaces the session login with a pending second factor challenge
+func startTwoFactorLogin(w http.ResponseWriter, r *http.Request, user User, remember bool) {
+	token, err := g.GenerateRandomToken(32)
+	if err != nil {
+		httpError(w, r, "Could not generate login token.", http.StatusInternalServerError)
+		return
+	}
+
+	ks.Set("2fa:"+token, user.ID, totpLoginDuration)
+
+	data := map[string]interface{}{
+		"Token":    token,
+		"Remember": remember,
+	}
+
+	executeTemplate(w, r, http.StatusOK, "auth-2fa.tmpl", data)
+}
+
+func postLoginTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	token := r.FormValue("token")
+	userID, err := ks.Get("2fa:" + token)
+	if err != nil {
+		httpError(w, r, "Login expired, please try again.", http.StatusUnauthorized)
+		return
+	}
+
+	var user User
+	err = db.First(&user, *userID).Error
+	if err != nil {
+		httpError(w, r, "Login expired, please try again.", http.StatusUnauthorized)
+		return
+	}
+
+	if isLockedOut(user) {
+		ks.Delete("2fa:" + token)
+		httpError(w, r, "This account is temporarily locked, please try again later.", http.StatusTooManyRequests)
+		return
+	}
+
+	if !checkSecondFactor(&user, r.FormValue("code")) {
+		recordLoginFailure(user, r, "wrong two-factor code")
+
+		attempts, _ := ks.Get("2fa-attempts:" + token)
+		n := uint(1)
+		if attempts != nil {
+			n = *attempts + 1
+		}
+
+		if n >= totpMaxAttempts {
+			ks.Delete("2fa:" + token)
+			ks.Delete("2fa-attempts:" + token)
+			httpError(w, r, "Too many attempts, please login again.", http.StatusUnauthorized)
+			return
+		}
+
+		ks.Set("2fa-attempts:"+token, n, totpLoginDuration)
+		httpError(w, r, "Invalid code.", http.StatusUnauthorized)
+		return
+	}
+
+	ks.Delete("2fa:" + token)
+	ks.Delete("2fa-attempts:" + token)
+	resetLoginFailures(user)
+
+	login(w, r, user.ID, r.FormValue("remember") == "on", "two-factor")
+	http.Redirect(w, r, "/login", http.StatusFound)
+}
+
+func getTwoFactorHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "Could not find user in context.", http.StatusInternalServerError)
+		return
+	}
+
+	data := map[string]interface{}{
+		"Enabled":       hasTwoFactor(user),
+		"HasPassword":   user.PasswordHash != "",
+		"ReauthMinutes": int(reauthWindow / time.Minute),
+	}
+	if hasTwoFactor(user) {
+		var count int64
+		db.Model(&RecoveryCode{}).Where(&RecoveryCode{UserID: user.ID}).Count(&count)
+		data["RecoveryCodes"] = count
+
+		executeTemplate(w, r, http.StatusOK, "2fa.tmpl", data)
+		return
+	}
+
+	secret, err := g.GenerateTOTPSecret()
+	if err != nil {
+		httpError(w, r, "Could not generate secret.", http.StatusInternalServerError)
+		return
+	}
+
+	png, err := qrcode.Encode(g.TOTPURI(secret, totpIssuer, user.Username), qrcode.Medium, 256)
+	if err != nil {
+		httpError(w, r, "Could not generate QR code.", http.StatusInternalServerError)
+		return
+	}
+
+	subject := strconv.FormatUint(uint64(user.ID), 10) + ":" + secret
+	data["Secret"] = secret
+	data["Token"] = g.SignToken(totpSetupPurpose, subject, time.Now().Add(totpSetupDuration))
+	data["QRCode"] = template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png))
+
+	executeTemplate(w, r, http.StatusOK, "2fa.tmpl", data)
+}
+
+func postTwoFactorEnableHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	subject, err := g.VerifyToken(totpSetupPurpose, r.FormValue("token"))
+	if err != nil {
+		httpError(w, r, "Setup expired, please try again.", http.StatusBadRequest)
+		return
+	}
+
+	id, secret, _ := strings.Cut(subject, ":")
+	if id != strconv.FormatUint(uint64(user.ID), 10) || hasTwoFactor(user) {
+		httpError(w, r, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if !reauthenticate(w, r, user, r.FormValue("password")) {
+		return
+	}
+
+	step, ok := g.ValidateTOTP(secret, r.FormValue("code"), time.Now(), 0)
+	if !ok {
+		httpError(w, r, "Invalid code.", http.StatusBadRequest)
+		return
+	}
+
+	user.TOTPSecret = secret
+	user.TOTPLastStep = step
+	db.Save(&user)
+	audit(r, user.ID, auditTwoFactorEnabled, "")
+
+	codes, err := generateRecoveryCodes(user)
+	if err != nil {
+		httpError(w, r, "Could not generate recovery codes.", http.StatusInternalServerError)
+		return
+	}
+	audit(r, user.ID, auditRecoveryCodes, "")
+
+	executeTemplate(w, r, http.StatusOK, "2fa-recovery_codes.tmpl", codes)
+}
+
+func postTwoFactorDisableHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !hasTwoFactor(user) {
+		http.Redirect(w, r, "/2fa", http.StatusFound)
+		return
+	}
+
+	if !confirmTwoFactorChange(w, r, &user) {
+		return
+	}
+
+	user.TOTPSecret = ""
+	user.TOTPLastStep = 0
+	db.Save(&user)
+	db.Unscoped().Where(&RecoveryCode{UserID: user.ID}).Delete(&RecoveryCode{})
+	audit(r, user.ID, auditTwoFactorDisabled, "")
+
+	http.Redirect(w, r, "/2fa", http.StatusFound)
+}
+
+func postRecoveryCodesHandler(w http.ResponseWriter, r *http.Request) {
+	user, ok := getLoggedUser(r)
+	if !ok {
+		httpError(w, r, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if !hasTwoFactor(user) {
+		httpError(w, r, "Two-factor authentication is not enabled.", http.StatusBadRequest)
+		return
+	}
+
+	if !confirmTwoFactorChange(w, r, &user) {
+		return
+	}
+
+	codes, err := generateRecoveryCodes(user)
+	if err != nil {
+		httpError(w, r, "Could not generate recovery codes.", http.StatusInternalServerError)
+		return
+	}
+
+	executeTemplate(w, r, http.StatusOK, "2fa-recovery_codes.tmpl", codes)
+}
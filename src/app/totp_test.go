@@ -0,0 +1,142 @@
+package app
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// The code an authenticator app shows for the secret, steps periods away from now (RFC 6238)
+func totpNow(t *testing.T, secret string, steps int64) string {
+	t.Helper()
+
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, time.Now().Unix()/30+steps)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:])&0x7fffffff)%1000000)
+}
+
+func TestTwoFactorWithoutPassword(t *testing.T) {
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	user := createTestUser(t, "totpnopassword")
+	db.Model(&user).Updates(map[string]interface{}{"password_hash": "", "totp_secret": secret})
+
+	c := newTestClient(t)
+	c.login(user)
+
+	if res := c.post("/2fa/recovery-codes", url.Values{"code": {"000000"}}); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("recovery codes with a wrong code: status %d", res.StatusCode)
+	}
+
+	code := totpNow(t, secret, -1)
+	if res := c.post("/2fa/recovery-codes", url.Values{"code": {code}}); res.StatusCode != http.StatusOK {
+		t.Fatalf("recovery codes with a TOTP code: status %d", res.StatusCode)
+	}
+	var count int64
+	db.Model(&RecoveryCode{}).Where(&RecoveryCode{UserID: user.ID}).Count(&count)
+	if count != recoveryCodesCount {
+		t.Errorf("%d recovery codes, want %d", count, recoveryCodesCount)
+	}
+
+	if res := c.post("/2fa/disable", url.Values{"code": {code}}); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("disable with a replayed code: status %d", res.StatusCode)
+	}
+
+	if res := c.post("/2fa/disable", url.Values{"code": {totpNow(t, secret, 0)}}); res.StatusCode != http.StatusFound {
+		t.Fatalf("disable with a TOTP code: status %d", res.StatusCode)
+	}
+	db.First(&user, user.ID)
+	if hasTwoFactor(user) {
+		t.Error("two-factor authentication is still enabled")
+	}
+}
+
+func TestTwoFactorWithPassword(t *testing.T) {
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	user := createTestUser(t, "totppassword")
+	db.Model(&user).Update("totp_secret", secret)
+
+	c := newTestClient(t)
+	c.login(user)
+
+	// a code alone is not enough when the account has a password
+	if res := c.post("/2fa/disable", url.Values{"code": {totpNow(t, secret, 0)}}); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("disable with a code only: status %d", res.StatusCode)
+	}
+	if res := c.post("/2fa/disable", url.Values{"password": {testPassword}}); res.StatusCode != http.StatusFound {
+		t.Errorf("disable with the password: status %d", res.StatusCode)
+	}
+}
+
+func TestTwoFactorEnable(t *testing.T) {
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	user := createTestUser(t, "totpenable")
+	passwordless := createTestUser(t, "totpenanopw")
+	db.Model(&passwordless).Update("password_hash", "")
+
+	setup := func(user User) url.Values {
+		subject := strconv.FormatUint(uint64(user.ID), 10) + ":" + secret
+		return url.Values{
+			"token": {g.SignToken(totpSetupPurpose, subject, time.Now().Add(totpSetupDuration))},
+			"code":  {totpNow(t, secret, 0)},
+		}
+	}
+
+	c := newTestClient(t)
+	c.login(user)
+	form := setup(user)
+	if res := c.post("/2fa/enable", form); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("enable without the password: status %d", res.StatusCode)
+	}
+	form.Set("password", "wrong")
+	if res := c.post("/2fa/enable", form); res.StatusCode != http.StatusUnauthorized {
+		t.Errorf("enable with a wrong password: status %d", res.StatusCode)
+	}
+	form.Set("password", testPassword)
+	if res := c.post("/2fa/enable", form); res.StatusCode != http.StatusOK {
+		t.Fatalf("enable with the password: status %d", res.StatusCode)
+	}
+	db.First(&user, user.ID)
+	if !hasTwoFactor(user) {
+		t.Error("two-factor authentication was not enabled")
+	}
+
+	// accounts without a password need a recent login instead
+	c.login(passwordless)
+	if res := c.post("/2fa/enable", setup(passwordless)); res.StatusCode != http.StatusOK {
+		t.Errorf("enable after a recent login: status %d", res.StatusCode)
+	}
+}
+
+func TestTwoFactorCodeUsedOnce(t *testing.T) {
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	user := createTestUser(t, "totponce")
+	db.Model(&user).Update("totp_secret", secret)
+	db.First(&user, user.ID)
+
+	// two requests that loaded the user before either used the code
+	first, second := user, user
+	code := totpNow(t, secret, 0)
+	if !checkSecondFactor(&first, code) {
+		t.Fatal("the code was rejected")
+	}
+	if checkSecondFactor(&second, code) {
+		t.Error("the code was accepted twice")
+	}
+	if checkSecondFactor(&second, totpNow(t, secret, -1)) {
+		t.Error("an earlier code was accepted after a later one")
+	}
+}
@@ -0,0 +1,127 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	verificationPurpose        = "verify-email"
+	verificationDuration       = 2 * 24 * time.Hour
+	verificationResendCooldown = time.Minute
+
+	emailChangePurpose = "change-email"
+)
+
+// Tokens are bound to the address, so they stop working when the email changes
+func verificationSubject(user User) string {
+	return strconv.FormatUint(uint64(user.ID), 10) + ":" + user.Email
+}
+
+func sendVerificationEmail(user User) {
+	token := g.SignToken(verificationPurpose, verificationSubject(user), time.Now().Add(verificationDuration))
+	verifyURL := fmt.Sprintf("%s/verify-email?token=%s", baseUrl, token)
+	err := sendTemplateEmail(user, "verify", map[string]interface{}{"URL": verifyURL})
+	if err != nil {
+		log.Printf("Could not send verification email for %s.", user.Email)
+	}
+}
+
+// Sends the confirmation link to the pending address and warns the current one
+func sendEmailChangeEmails(user User) {
+	subject := strconv.FormatUint(uint64(user.ID), 10) + ":" + user.PendingEmail
+	token := g.SignToken(emailChangePurpose, subject, time.Now().Add(verificationDuration))
+
+	pending := user
+	pending.Email = user.PendingEmail
+	err := sendTemplateEmail(pending, "confirm_email", map[string]interface{}{
+		"URL": fmt.Sprintf("%s/confirm-email?token=%s", baseUrl, token),
+	})
+	if err != nil {
+		log.Printf("Could not send confirmation email for %s.", user.PendingEmail)
+	}
+
+	err = sendTemplateEmail(user, "email_change", map[string]interface{}{"Username": user.Username, "Email": user.PendingEmail})
+	if err != nil {
+		log.Printf("Could not send email change notice for %s.", user.Email)
+	}
+}
+
+func getConfirmEmailHandler(w http.ResponseWriter, r *http.Request) {
+	subject, err := g.VerifyToken(emailChangePurpose, r.URL.Query().Get("token"))
+	if err != nil {
+		httpError(w, r, "Token is invalid or expired.", http.StatusUnauthorized)
+		return
+	}
+
+	id, address, _ := strings.Cut(subject, ":")
+	userID, _ := strconv.ParseUint(id, 10, 64)
+
+	// a later request replaces the pending address, and with it the older links
+	var user User
+	err = db.First(&user, userID).Error
+	if err != nil || user.PendingEmail == "" || user.PendingEmail != address {
+		httpError(w, r, "Token is invalid or expired.", http.StatusUnauthorized)
+		return
+	}
+
+	previous := user.Email
+	err = db.Model(&user).Updates(map[string]interface{}{"email": address, "pending_email": "", "verified": true}).Error
+	if err != nil {
+		httpError(w, r, "This email is already registered.", http.StatusConflict)
+		return
+	}
+	audit(r, user.ID, auditEmailChanged, fmt.Sprintf("from %s to %s", previous, address))
+
+	executeTemplate(w, r, http.StatusOK, "auth-verify_email.tmpl", map[string]interface{}{"Verified": true})
+}
+
+func getVerifyEmailHandler(w http.ResponseWriter, r *http.Request) {
+	subject, err := g.VerifyToken(verificationPurpose, r.URL.Query().Get("token"))
+	if err != nil {
+		httpError(w, r, "Token is invalid or expired.", http.StatusUnauthorized)
+		return
+	}
+
+	id, address, _ := strings.Cut(subject, ":")
+	userID, _ := strconv.ParseUint(id, 10, 64)
+
+	var user User
+	err = db.First(&user, userID).Error
+	if err != nil || user.Email != address {
+		httpError(w, r, "Token is invalid or expired.", http.StatusUnauthorized)
+		return
+	}
+
+	if !user.Verified {
+		user.Verified = true
+		db.Save(&user)
+	}
+
+	executeTemplate(w, r, http.StatusOK, "auth-verify_email.tmpl", map[string]interface{}{"Verified": true})
+}
+
+// Resends the verification email to the logged user, or to the address in the form
+func postVerifyEmailResendHandler(w http.ResponseWriter, r *http.Request) {
+	var user User
+	userID, err := readSessionCookie(r)
+	if err == nil {
+		db.First(&user, *userID)
+	} else {
+		db.Where("email = ?", strings.ToLower(r.FormValue("email"))).First(&user)
+	}
+
+	if user.ID != 0 && !user.Verified {
+		key := "verify-resend:" + strconv.FormatUint(uint64(user.ID), 10)
+		if _, err := ks.Get(key); err != nil {
+			ks.Set(key, user.ID, verificationResendCooldown)
+			sendVerificationEmail(user)
+		}
+	}
+
+	executeTemplate(w, r, http.StatusOK, "auth-verify_email.tmpl", map[string]interface{}{"Sent": true})
+}
@@ -0,0 +1,96 @@
+package app
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+func getWebhook(token string) (webhook Webhook, err error) {
+	err = db.Model(&Webhook{}).Where(&Webhook{Token: token}).First(&webhook).Error
+	return
+}
+
+func postWebhooksHandler(w http.ResponseWriter, r *http.Request) {
+	habit, err := getHabitHelper(w, r)
+	if err != nil {
+		return
+	}
+
+	token, err := g.GenerateRandomToken(16)
+	if err != nil {
+		httpError(w, r, "Could not generate webhook token.", http.StatusInternalServerError)
+		return
+	}
+
+	db.Create(&Webhook{
+		HabitID:  habit.ID,
+		Token:    token,
+		AllowGet: r.FormValue("allow_get") == "on",
+	})
+
+	http.Redirect(w, r, "/habits/"+r.PathValue("id"), http.StatusFound)
+}
+
+func postWebhookDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	habit, err := getHabitHelper(w, r)
+	if err != nil {
+		return
+	}
+
+	webhookID := getPathUint(r, "webhookID")
+	if webhookID == 0 {
+		httpError(w, r, "bad request", http.StatusBadRequest)
+		return
+	}
+
+	db.Unscoped().Delete(&Webhook{}, "id = ? AND habit_id = ?", webhookID, habit.ID)
+
+	http.Redirect(w, r, "/habits/"+r.PathValue("id"), http.StatusFound)
+}
+
+// Acks a habit without a session, for NFC tags, phone shortcuts and home automation
+func webhookHandler(w http.ResponseWriter, r *http.Request) {
+	webhook, err := getWebhook(r.PathValue("token"))
+	if err != nil {
+		httpError(w, r, "not found", http.StatusNotFound)
+		return
+	}
+
+	if !webhook.AllowGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		httpError(w, r, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	habit, err := getHabit(webhook.HabitID)
+	if err != nil || habit.ID == 0 {
+		httpError(w, r, "not found", http.StatusNotFound)
+		return
+	}
+
+	var user User
+	err = db.Select("id", "disabled").First(&user, habit.UserID).Error
+	if err != nil || user.Disabled {
+		httpError(w, r, "This account has been disabled.", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now()
+	webhook.LastUsed = &now
+	db.Save(&webhook)
+
+	err = ackHabit(&habit)
+	if errors.Is(err, errAckCooldown) {
+		audit(r, habit.UserID, auditWebhookUsed, habit.Name+", acked too recently")
+		httpError(w, r, "Habit was acked too recently.", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		httpError(w, r, "Could not ack habit.", http.StatusInternalServerError)
+		return
+	}
+	audit(r, habit.UserID, auditWebhookUsed, habit.Name)
+
+	w.Write([]byte("ok\n"))
+}
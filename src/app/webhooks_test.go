@@ -0,0 +1,102 @@
+package app
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func TestWebhook(t *testing.T) {
+	user := createTestUser(t, "hookuser")
+	habit := createTestHabit(t, user, "hookuser")
+	db.Model(&Ack{}).Where("habit_id = ?", habit.ID).Delete(&Ack{})
+
+	c := newTestClient(t)
+	if res := c.get("/hook/hookuser-hook"); res.StatusCode != http.StatusMethodNotAllowed || res.Header.Get("Allow") != http.MethodPost {
+		t.Errorf("GET: status %d, allow %q", res.StatusCode, res.Header.Get("Allow"))
+	}
+	if res := c.post("/hook/hookuser-hook", url.Values{}); res.StatusCode != http.StatusOK {
+		t.Fatalf("POST: status %d", res.StatusCode)
+	}
+	if res := c.post("/hook/hookuser-hook", url.Values{}); res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("POST in cooldown: status %d", res.StatusCode)
+	}
+
+	var webhook Webhook
+	db.Where("token = ?", "hookuser-hook").First(&webhook)
+	if webhook.LastUsed == nil {
+		t.Error("the webhook was not marked as used")
+	}
+	var count int64
+	db.Model(&Ack{}).Where("habit_id = ?", habit.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("got %d acks, want 1", count)
+	}
+
+	if res := c.post("/hook/unknown-hook", url.Values{}); res.StatusCode != http.StatusNotFound {
+		t.Errorf("unknown token: status %d", res.StatusCode)
+	}
+}
+
+func TestWebhookAllowGet(t *testing.T) {
+	user := createTestUser(t, "hookget")
+	habit := Habit{UserID: user.ID, Name: "Water", Days: 1}
+	db.Create(&habit)
+	db.Create(&Webhook{HabitID: habit.ID, Token: "hookget-hook", AllowGet: true})
+
+	if res := newTestClient(t).get("/hook/hookget-hook"); res.StatusCode != http.StatusOK {
+		t.Errorf("GET: status %d", res.StatusCode)
+	}
+}
+
+func TestWebhookCreateAndRevoke(t *testing.T) {
+	user := createTestUser(t, "hookrevoke")
+	other := createTestUser(t, "hookother")
+	habit := Habit{UserID: user.ID, Name: "Stretch", Days: 1}
+	db.Create(&habit)
+	path := "/habits/" + strconv.FormatUint(uint64(habit.ID), 10) + "/webhooks"
+
+	c := newTestClient(t)
+	c.login(user)
+	c.post(path, url.Values{})
+	c.post(path, url.Values{"allow_get": {"on"}})
+
+	var webhooks []Webhook
+	db.Where("habit_id = ?", habit.ID).Order("id").Find(&webhooks)
+	if len(webhooks) != 2 || webhooks[0].AllowGet || !webhooks[1].AllowGet {
+		t.Fatalf("created %+v, want a POST only webhook and one allowing GET", webhooks)
+	}
+	revoke := path + "/" + strconv.FormatUint(uint64(webhooks[0].ID), 10) + "/delete"
+
+	// only the owner can revoke
+	c.login(other)
+	c.post(revoke, url.Values{})
+	if res := c.post("/hook/"+webhooks[0].Token, url.Values{}); res.StatusCode != http.StatusOK {
+		t.Fatalf("after another user revoked: status %d", res.StatusCode)
+	}
+
+	c.login(user)
+	if res := c.post(revoke, url.Values{}); res.StatusCode != http.StatusFound {
+		t.Fatalf("revoke: status %d", res.StatusCode)
+	}
+	if res := c.post("/hook/"+webhooks[0].Token, url.Values{}); res.StatusCode != http.StatusNotFound {
+		t.Errorf("revoked token: status %d", res.StatusCode)
+	}
+}
+
+func TestAckCooldown(t *testing.T) {
+	user := createTestUser(t, "ackcool")
+	habit := Habit{UserID: user.ID, Name: "Read", Days: 1}
+	db.Create(&habit)
+	path := "/ack/" + strconv.FormatUint(uint64(habit.ID), 10)
+
+	c := newTestClient(t)
+	c.login(user)
+	if res := c.post(path, url.Values{}); res.StatusCode != http.StatusFound {
+		t.Fatalf("ack: status %d", res.StatusCode)
+	}
+	if res := c.post(path, url.Values{}); res.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("ack in cooldown: status %d", res.StatusCode)
+	}
+}
@@ -1,25 +1,43 @@
 package auth
 
 import (
+	"crypto/hmac"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"encoding/hex"
 	"errors"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
-	"unicode"
+	"unicode/utf8"
 
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/unicode/norm"
 )
 
 type Auth struct {
-	Pepper       string
-	spicesLength int
+	LegacyPepper  string            // appended to the password in legacy bcrypt hashes, which have a separate salt
+	Peppers       map[string]string // HMAC keys by identifier, every pepper that hashes may still use
+	CurrentPepper string            // identifier of the pepper for new hashes, empty for none
+	SigningKey    []byte            // secret for SignToken, must be stable across restarts
+	Hasher        Hasher            // makes new password hashes
+	Hashers       []Hasher          // older schemes, still accepted until the hash is upgraded
 }
 
+var (
+	ErrInvalidToken = errors.New("invalid token")
+	ErrExpiredToken = errors.New("expired token")
+
+	tokenEncoding = base64.RawURLEncoding
+)
+
 const (
-	minPasswordLength        = 6
+	MinPasswordLength        = 6
+	MaxPasswordLength        = 256 // in characters, bounds the work of Strength and the hashers
 	maxHashLength            = 72
-	DefaultMaxPasswordLength = 56 // leaves 16 bytes for salt and pepper
+	DefaultMaxPasswordLength = 56 // leaves 16 bytes for salt and pepper in legacy bcrypt hashes
 )
 
 func NewAuth(pepper string, maxPasswordLength uint) *Auth {
@@ -34,35 +52,100 @@ func NewAuth(pepper string, maxPasswordLength uint) *Auth {
 
 	spicesLength := int(maxHashLength-maxPasswordLength) / 2
 	return &Auth{
-		Pepper:       hex.EncodeToString(hash)[:spicesLength],
-		spicesLength: spicesLength,
+		LegacyPepper: hex.EncodeToString(hash)[:spicesLength],
+		Peppers:      map[string]string{},
+		Hasher:       DefaultArgon2id,
+		Hashers:      []Hasher{Bcrypt{Cost: bcrypt.DefaultCost}},
 	}
 }
 
-func (g Auth) HashPassword(password string) (hashedPassword, salt string, err error) {
-	if !isASCII(password) || len(password) < minPasswordLength {
-		err = errors.New("invalid password")
-		return
+// Passwords are compared in NFKC form, so the same text typed on different devices matches
+func normalizePassword(password string) (string, error) {
+	if !utf8.ValidString(password) {
+		return "", errors.New("invalid password")
 	}
 
-	salt, err = g.GenerateRandomToken(g.spicesLength)
-	if err != nil {
-		return
+	password = norm.NFKC.String(password)
+	length := utf8.RuneCountInString(password)
+	if length < MinPasswordLength || length > MaxPasswordLength {
+		return "", errors.New("invalid password")
 	}
-	salt = salt[:g.spicesLength]
+	return password, nil
+}
+
+// Keys the password with a pepper, so stolen hashes cannot be cracked without it
+func pepperPassword(password, pepper string) string {
+	mac := hmac.New(sha256.New, []byte(pepper))
+	mac.Write([]byte(password))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
 
-	bytesPassword, err := bcrypt.GenerateFromPassword([]byte(password+salt+g.Pepper), bcrypt.DefaultCost)
+// HashPassword returns a self-describing hash of password, made with the current Hasher and pepper
+func (g Auth) HashPassword(password string) (string, error) {
+	password, err := normalizePassword(password)
 	if err != nil {
-		return
+		return "", err
 	}
 
-	hashedPassword = string(bytesPassword)
-	return
+	if g.CurrentPepper != "" {
+		pepper, ok := g.Peppers[g.CurrentPepper]
+		if !ok {
+			return "", errors.New("unknown current pepper")
+		}
+		password = pepperPassword(password, pepper)
+	}
+	return g.Hasher.Hash(password, g.CurrentPepper)
 }
 
+func (g Auth) getHasher(hash string) Hasher {
+	for _, h := range append([]Hasher{g.Hasher}, g.Hashers...) {
+		if h.Recognizes(hash) {
+			return h
+		}
+	}
+	return nil
+}
+
+// CheckPassword verifies password against a hash made by any known Hasher and pepper.
+// A non-empty salt marks a legacy bcrypt hash of the password, salt and legacy pepper.
 func (g Auth) CheckPassword(password, salt, hash string) bool {
-	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password+salt+g.Pepper))
-	return err == nil
+	h := g.getHasher(hash)
+	if h == nil || utf8.RuneCountInString(password) > MaxPasswordLength {
+		return false
+	}
+
+	if salt != "" {
+		return h.Verify(password+salt+g.LegacyPepper, hash)
+	}
+
+	password = norm.NFKC.String(password)
+	if id := h.KeyID(hash); id != "" {
+		pepper, ok := g.Peppers[id]
+		if !ok {
+			return false
+		}
+		password = pepperPassword(password, pepper)
+	}
+	return h.Verify(password, hash)
+}
+
+// PepperOf returns the identifier of the pepper a hash was keyed with, empty for none
+func (g Auth) PepperOf(salt, hash string) (id string, legacy bool) {
+	if salt != "" {
+		return "", true
+	}
+
+	h := g.getHasher(hash)
+	if h == nil {
+		return "", false
+	}
+	return h.KeyID(hash), false
+}
+
+// NeedsRehash reports whether a hash should be replaced after the next successful login
+func (g Auth) NeedsRehash(salt, hash string) bool {
+	id, legacy := g.PepperOf(salt, hash)
+	return legacy || id != g.CurrentPepper || !g.Hasher.Recognizes(hash) || g.Hasher.NeedsRehash(hash)
 }
 
 func (g Auth) GenerateRandomToken(n int) (string, error) {
@@ -74,6 +157,62 @@ func (g Auth) GenerateRandomToken(n int) (string, error) {
 	return hex.EncodeToString(token), nil
 }
 
+// SignToken returns a tamper-proof token carrying subject for the given purpose, valid until expires
+func (g Auth) SignToken(purpose, subject string, expires time.Time) string {
+	payload := tokenEncoding.EncodeToString([]byte(strconv.FormatInt(expires.Unix(), 10) + "|" + subject))
+	return payload + "." + tokenEncoding.EncodeToString(g.tokenMAC(purpose, payload))
+}
+
+// VerifyToken checks a token made by SignToken for the same purpose and returns its subject
+func (g Auth) VerifyToken(purpose, token string) (subject string, err error) {
+	payload, signature, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", ErrInvalidToken
+	}
+
+	mac, err := tokenEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, g.tokenMAC(purpose, payload)) {
+		return "", ErrInvalidToken
+	}
+
+	decoded, err := tokenEncoding.DecodeString(payload)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	expires, subject, ok := strings.Cut(string(decoded), "|")
+	if !ok {
+		return "", ErrInvalidToken
+	}
+
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil {
+		return "", ErrInvalidToken
+	}
+
+	if time.Now().After(time.Unix(unix, 0)) {
+		return "", ErrExpiredToken
+	}
+	return subject, nil
+}
+
+// CSRFToken returns the token that forms must send back, bound to a session or browser cookie
+func (g Auth) CSRFToken(binding string) string {
+	return tokenEncoding.EncodeToString(g.tokenMAC("csrf", binding))
+}
+
+// CheckCSRFToken reports whether token was made by CSRFToken for the same binding
+func (g Auth) CheckCSRFToken(binding, token string) bool {
+	mac, err := tokenEncoding.DecodeString(token)
+	return err == nil && binding != "" && hmac.Equal(mac, g.tokenMAC("csrf", binding))
+}
+
+func (g Auth) tokenMAC(purpose, payload string) []byte {
+	mac := hmac.New(sha256.New, g.SigningKey)
+	mac.Write([]byte(purpose + "\x00" + payload))
+	return mac.Sum(nil)
+}
+
 func (g Auth) GenerateCookie(duration time.Duration) (*http.Cookie, error) {
 	sessionToken, err := g.GenerateRandomToken(32)
 	if err != nil {
@@ -87,23 +226,18 @@ func (g Auth) GenerateCookie(duration time.Duration) (*http.Cookie, error) {
 		Path:     "/",
 		HttpOnly: true,
 		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
 	}, nil
 }
 
 func (g Auth) GenerateEmptyCookie() *http.Cookie {
 	return &http.Cookie{
-		Name:    "session_token",
-		Value:   "",
-		Expires: time.Now().Add(-1 * time.Hour),
-		Path:    "/",
-	}
-}
-
-func isASCII(s string) bool {
-	for i := 0; i < len(s); i++ {
-		if s[i] > unicode.MaxASCII {
-			return false
-		}
+		Name:     "session_token",
+		Value:    "",
+		Expires:  time.Now().Add(-1 * time.Hour),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   true,
+		SameSite: http.SameSiteLaxMode,
 	}
-	return true
 }
@@ -0,0 +1,71 @@
+package auth
+
+import "testing"
+
+func TestPepperRotation(t *testing.T) {
+	g := NewAuth("pepper", DefaultMaxPasswordLength)
+	g.Peppers = map[string]string{"old": "first secret", "new": "second secret"}
+	g.CurrentPepper = "old"
+
+	hash, err := g.HashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, legacy := g.PepperOf("", hash); id != "old" || legacy {
+		t.Fatalf("PepperOf = %q, %v", id, legacy)
+	}
+
+	// the hash is of the HMAC, not of the password
+	if DefaultArgon2id.Verify(testPassword, hash) || !DefaultArgon2id.Verify(pepperPassword(testPassword, "first secret"), hash) {
+		t.Error("the password was not keyed with the pepper")
+	}
+
+	g.CurrentPepper = "new"
+	if !g.CheckPassword(testPassword, "", hash) {
+		t.Error("a hash with the previous pepper was rejected")
+	}
+	if !g.NeedsRehash("", hash) {
+		t.Error("a hash with the previous pepper does not need a rehash")
+	}
+
+	rotated, err := g.HashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := g.PepperOf("", rotated); id != "new" || g.NeedsRehash("", rotated) {
+		t.Errorf("rotated hash has pepper %q", id)
+	}
+
+	delete(g.Peppers, "old")
+	if g.CheckPassword(testPassword, "", hash) {
+		t.Error("a hash was accepted after its pepper was removed")
+	}
+
+	g.Peppers["old"] = "another secret"
+	if g.CheckPassword(testPassword, "", hash) {
+		t.Error("a hash was accepted with the wrong pepper secret")
+	}
+
+	g.CurrentPepper = "missing"
+	if _, err := g.HashPassword(testPassword); err == nil {
+		t.Error("HashPassword used an unknown current pepper")
+	}
+}
+
+func TestNoPepper(t *testing.T) {
+	g := NewAuth("pepper", DefaultMaxPasswordLength)
+	hash, err := g.HashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id, _ := g.PepperOf("", hash); id != "" || !DefaultArgon2id.Verify(testPassword, hash) {
+		t.Errorf("hash without pepper %s", hash)
+	}
+
+	// configuring a pepper upgrades existing hashes at the next login
+	g.Peppers["1"] = "secret"
+	g.CurrentPepper = "1"
+	if !g.CheckPassword(testPassword, "", hash) || !g.NeedsRehash("", hash) {
+		t.Error("a hash without pepper is not accepted and upgraded")
+	}
+}
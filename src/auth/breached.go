@@ -0,0 +1,127 @@
+package auth
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Breached looks passwords up in a local copy of the Have I Been Pwned password list, either
+// a directory of range files named after the 5-character SHA-1 prefix (ABCDE.txt, with
+// SUFFIX:COUNT lines as served by the k-anonymity API) or a single SHA1:COUNT file sorted by hash.
+type Breached struct {
+	path string
+	dir  bool
+}
+
+const breachedPrefixLength = 5
+
+func NewBreached(path string) (*Breached, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Breached{path: path, dir: info.IsDir()}, nil
+}
+
+// Contains reports whether password appears in the list
+func (b *Breached) Contains(password string) (bool, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	if b.dir {
+		return b.searchRange(hash[:breachedPrefixLength], hash[breachedPrefixLength:])
+	}
+	return b.searchSorted(hash)
+}
+
+func (b *Breached) searchRange(prefix, suffix string) (bool, error) {
+	f, err := os.Open(filepath.Join(b.path, prefix+".txt"))
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		hash, count, _ := strings.Cut(strings.TrimSpace(scanner.Text()), ":")
+		if strings.EqualFold(hash, suffix) {
+			return count != "0", nil // the API pads responses with zero-count entries
+		}
+	}
+	return false, scanner.Err()
+}
+
+// Binary search over byte offsets, realigning to the next line each time
+func (b *Breached) searchSorted(hash string) (bool, error) {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return false, err
+	}
+
+	lo, hi := int64(0), info.Size()
+	for lo < hi {
+		mid := (lo + hi) / 2
+		line, next, err := readLineFrom(f, mid)
+		if err != nil {
+			return false, err
+		}
+		if line == "" {
+			hi = mid
+			continue
+		}
+
+		lineHash, _, _ := strings.Cut(line, ":")
+		switch c := strings.Compare(strings.ToUpper(lineHash), hash); {
+		case c == 0:
+			return true, nil
+		case c < 0:
+			lo = next
+		default:
+			hi = mid
+		}
+	}
+	return false, nil
+}
+
+// Returns the first full line starting at or after offset, and the offset that follows it
+func readLineFrom(f *os.File, offset int64) (line string, next int64, err error) {
+	start := max(offset-1, 0)
+	_, err = f.Seek(start, io.SeekStart)
+	if err != nil {
+		return
+	}
+
+	reader := bufio.NewReader(f)
+	if offset > 0 {
+		skipped, err := reader.ReadString('\n')
+		if err == io.EOF {
+			return "", 0, nil
+		}
+		if err != nil {
+			return "", 0, err
+		}
+		start += int64(len(skipped))
+	}
+
+	line, err = reader.ReadString('\n')
+	if err == io.EOF {
+		err = nil
+	}
+	next = start + int64(len(line))
+	return strings.TrimSpace(line), next, err
+}
@@ -0,0 +1,107 @@
+package auth
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func sha1Hex(s string) string {
+	sum := sha1.Sum([]byte(s))
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
+
+// Writes a sorted SHA1:COUNT list of the passwords, with some filler around them
+func writeSortedList(t *testing.T, passwords []string) string {
+	var lines []string
+	for _, p := range passwords {
+		lines = append(lines, sha1Hex(p)+":42")
+	}
+	for i := 0; i < 500; i++ {
+		lines = append(lines, sha1Hex(fmt.Sprint("filler", i))+":1")
+	}
+	slices.Sort(lines)
+
+	path := filepath.Join(t.TempDir(), "pwned.txt")
+	err := os.WriteFile(path, []byte(strings.Join(lines, "\r\n")+"\r\n"), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBreachedSearchSorted(t *testing.T) {
+	breached := []string{"password", "123456", "letmein", "filler0", "filler499"}
+	b, err := NewBreached(writeSortedList(t, breached))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the lowest and highest hashes sit on the first and last lines
+	var all []string
+	for i := 0; i < 500; i++ {
+		all = append(all, fmt.Sprint("filler", i))
+	}
+	all = append(all, breached...)
+	slices.SortFunc(all, func(a, b string) int { return strings.Compare(sha1Hex(a), sha1Hex(b)) })
+	breached = append(breached, all[0], all[len(all)-1])
+
+	for _, password := range breached {
+		found, err := b.Contains(password)
+		if err != nil || !found {
+			t.Errorf("Contains(%q) = %v, %v, want true", password, found, err)
+		}
+	}
+
+	for _, password := range []string{"vK8#qL2!mZ9@", "filler500", ""} {
+		found, err := b.Contains(password)
+		if err != nil || found {
+			t.Errorf("Contains(%q) = %v, %v, want false", password, found, err)
+		}
+	}
+}
+
+func TestBreachedSearchSortedEdges(t *testing.T) {
+	for _, content := range []string{"", sha1Hex("password") + ":1", sha1Hex("password") + ":1\n"} {
+		path := filepath.Join(t.TempDir(), "pwned.txt")
+		os.WriteFile(path, []byte(content), 0600)
+
+		b, _ := NewBreached(path)
+		found, err := b.searchSorted(sha1Hex("password"))
+		if err != nil || found != (content != "") {
+			t.Errorf("searchSorted in %q = %v, %v", content, found, err)
+		}
+
+		found, err = b.searchSorted(sha1Hex("other"))
+		if err != nil || found {
+			t.Errorf("searchSorted for a missing hash in %q = %v, %v", content, found, err)
+		}
+	}
+}
+
+func TestBreachedSearchRange(t *testing.T) {
+	dir := t.TempDir()
+	hash := sha1Hex("password")
+	content := hash[5:] + ":3861493\r\n" + sha1Hex("padding")[5:] + ":0\r\n"
+	os.WriteFile(filepath.Join(dir, hash[:5]+".txt"), []byte(content), 0600)
+
+	b, err := NewBreached(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if found, err := b.Contains("password"); err != nil || !found {
+		t.Errorf("Contains(password) = %v, %v, want true", found, err)
+	}
+	if found, err := b.searchRange(hash[:5], sha1Hex("padding")[5:]); err != nil || found {
+		t.Errorf("searchRange matched a zero-count entry: %v, %v", found, err)
+	}
+	if found, err := b.Contains("vK8#qL2!mZ9@"); err != nil || found {
+		t.Errorf("Contains for a missing range = %v, %v, want false", found, err)
+	}
+}
@@ -0,0 +1,169 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Hasher turns passwords into self-describing hashes
+type Hasher interface {
+	// Hash hashes password, recording keyID as the pepper it was keyed with, if any
+	Hash(password, keyID string) (string, error)
+	Verify(password, hash string) bool
+	// KeyID returns the pepper identifier recorded by Hash
+	KeyID(hash string) string
+	// Recognizes reports whether hash was made by this hasher, with any parameters
+	Recognizes(hash string) bool
+	// NeedsRehash reports whether hash was made with parameters other than the current ones
+	NeedsRehash(hash string) bool
+}
+
+// Argon2id hashes passwords with Argon2id (RFC 9106) into PHC strings:
+// $argon2id$v=19$m=<memory>,t=<time>,p=<threads>[,keyid=<id>]$<salt>$<key>
+type Argon2id struct {
+	Memory  uint32 // KiB
+	Time    uint32
+	Threads uint8
+	SaltLen uint32
+	KeyLen  uint32
+}
+
+// Bcrypt hashes passwords with bcrypt, whose input is limited to 72 bytes
+type Bcrypt struct {
+	Cost int
+}
+
+var (
+	// DefaultArgon2id follows the OWASP recommendation for Argon2id
+	DefaultArgon2id = Argon2id{Memory: 19 * 1024, Time: 2, Threads: 1, SaltLen: 16, KeyLen: 32}
+
+	errInvalidHash = errors.New("invalid hash")
+	errKeyedBcrypt = errors.New("bcrypt hashes cannot record a pepper")
+	phcEncoding    = base64.RawStdEncoding
+	validKeyID     = regexp.MustCompile(`^[A-Za-z0-9]{1,16}$`)
+)
+
+const argon2idPrefix = "$argon2id$"
+
+// ValidKeyID reports whether id can be recorded in a hash as a pepper identifier
+func ValidKeyID(id string) bool {
+	return validKeyID.MatchString(id)
+}
+
+func (a Argon2id) Hash(password, keyID string) (string, error) {
+	params := fmt.Sprintf("m=%d,t=%d,p=%d", a.Memory, a.Time, a.Threads)
+	if keyID != "" {
+		if !ValidKeyID(keyID) {
+			return "", errors.New("invalid pepper identifier")
+		}
+		params += ",keyid=" + keyID
+	}
+
+	salt := make([]byte, a.SaltLen)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.Time, a.Memory, a.Threads, a.KeyLen)
+	return fmt.Sprintf("%sv=%d$%s$%s$%s", argon2idPrefix, argon2.Version, params,
+		phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key)), nil
+}
+
+// Parses a PHC string into its parameters, pepper identifier, salt and key
+func decodeArgon2id(hash string) (params Argon2id, keyID string, salt, key []byte, err error) {
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 || "$"+parts[1]+"$" != argon2idPrefix {
+		err = errInvalidHash
+		return
+	}
+
+	var version int
+	_, err = fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		err = errInvalidHash
+		return
+	}
+
+	settings, keyID, _ := strings.Cut(parts[3], ",keyid=")
+	_, err = fmt.Sscanf(settings, "m=%d,t=%d,p=%d", &params.Memory, &params.Time, &params.Threads)
+	if err != nil {
+		err = errInvalidHash
+		return
+	}
+
+	salt, err = phcEncoding.DecodeString(parts[4])
+	if err != nil {
+		err = errInvalidHash
+		return
+	}
+
+	key, err = phcEncoding.DecodeString(parts[5])
+	if err != nil || len(key) == 0 {
+		err = errInvalidHash
+		return
+	}
+
+	params.SaltLen = uint32(len(salt))
+	params.KeyLen = uint32(len(key))
+	return
+}
+
+func (a Argon2id) Verify(password, hash string) bool {
+	params, _, salt, key, err := decodeArgon2id(hash)
+	if err != nil {
+		return false
+	}
+
+	other := argon2.IDKey([]byte(password), salt, params.Time, params.Memory, params.Threads, params.KeyLen)
+	return subtle.ConstantTimeCompare(key, other) == 1
+}
+
+func (a Argon2id) KeyID(hash string) string {
+	_, keyID, _, _, _ := decodeArgon2id(hash)
+	return keyID
+}
+
+func (a Argon2id) Recognizes(hash string) bool {
+	return strings.HasPrefix(hash, argon2idPrefix)
+}
+
+func (a Argon2id) NeedsRehash(hash string) bool {
+	params, _, _, _, err := decodeArgon2id(hash)
+	return err != nil || params != a
+}
+
+func (b Bcrypt) Hash(password, keyID string) (string, error) {
+	if keyID != "" {
+		return "", errKeyedBcrypt
+	}
+
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), b.Cost)
+	return string(hash), err
+}
+
+func (b Bcrypt) Verify(password, hash string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+func (b Bcrypt) KeyID(hash string) string {
+	return ""
+}
+
+func (b Bcrypt) Recognizes(hash string) bool {
+	_, err := bcrypt.Cost([]byte(hash))
+	return err == nil
+}
+
+func (b Bcrypt) NeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err != nil || cost != b.Cost
+}
@@ -0,0 +1,154 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const testPassword = "correct horse battery staple"
+
+func TestArgon2id(t *testing.T) {
+	h := DefaultArgon2id
+	hash, err := h.Hash(testPassword, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(hash, "$argon2id$v=19$m=19456,t=2,p=1$") {
+		t.Errorf("unexpected PHC string %s", hash)
+	}
+	if !h.Recognizes(hash) || h.NeedsRehash(hash) || h.KeyID(hash) != "" {
+		t.Errorf("%s is not a current hash without a pepper", hash)
+	}
+	if !h.Verify(testPassword, hash) {
+		t.Error("Verify rejected the password")
+	}
+	if h.Verify(testPassword+"!", hash) {
+		t.Error("Verify accepted a wrong password")
+	}
+
+	other, _ := h.Hash(testPassword, "")
+	if other == hash {
+		t.Error("two hashes of the same password share their salt")
+	}
+
+	keyed, err := h.Hash(testPassword, "k2024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.KeyID(keyed) != "k2024" || !h.Verify(testPassword, keyed) || h.NeedsRehash(keyed) {
+		t.Errorf("keyed hash %s", keyed)
+	}
+
+	if _, err := h.Hash(testPassword, "not valid!"); err == nil {
+		t.Error("Hash accepted an invalid pepper identifier")
+	}
+}
+
+// Hashes made with other parameters still verify, and are flagged for an upgrade
+func TestArgon2idParameters(t *testing.T) {
+	salt := []byte("somesalt")
+	key := argon2.IDKey([]byte(testPassword), salt, 1, 64, 1, 16)
+	hash := fmt.Sprintf("$argon2id$v=19$m=64,t=1,p=1$%s$%s", phcEncoding.EncodeToString(salt), phcEncoding.EncodeToString(key))
+
+	h := DefaultArgon2id
+	if !h.Verify(testPassword, hash) {
+		t.Error("Verify rejected a hash with other parameters")
+	}
+	if !h.NeedsRehash(hash) {
+		t.Error("a hash with other parameters does not need a rehash")
+	}
+
+	params, _, _, _, err := decodeArgon2id(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := Argon2id{Memory: 64, Time: 1, Threads: 1, SaltLen: 8, KeyLen: 16}
+	if params != want {
+		t.Errorf("decoded %+v, want %+v", params, want)
+	}
+}
+
+func TestArgon2idInvalidHashes(t *testing.T) {
+	h := DefaultArgon2id
+	valid, _ := h.Hash(testPassword, "")
+	parts := strings.Split(valid, "$")
+
+	for _, hash := range []string{
+		"",
+		"$argon2i$v=19$m=64,t=1,p=1$c29tZXNhbHQ$" + parts[5],
+		strings.Replace(valid, "v=19", "v=16", 1),
+		strings.Replace(valid, "m=19456", "m=x", 1),
+		strings.Join(parts[:5], "$"),
+		strings.Join(parts[:5], "$") + "$",
+		strings.Join(parts[:4], "$") + "$!!$" + parts[5],
+	} {
+		if h.Verify(testPassword, hash) {
+			t.Errorf("Verify accepted %q", hash)
+		}
+		if !h.NeedsRehash(hash) {
+			t.Errorf("%q does not need a rehash", hash)
+		}
+	}
+}
+
+func TestBcrypt(t *testing.T) {
+	h := Bcrypt{Cost: bcrypt.MinCost}
+	hash, err := h.Hash(testPassword, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !h.Recognizes(hash) || !h.Verify(testPassword, hash) || h.Verify("wrong password", hash) {
+		t.Errorf("bcrypt hash %s", hash)
+	}
+	if h.NeedsRehash(hash) || !(Bcrypt{Cost: bcrypt.MinCost + 1}).NeedsRehash(hash) {
+		t.Error("NeedsRehash does not follow the cost")
+	}
+	if DefaultArgon2id.Recognizes(hash) || h.Recognizes("$argon2id$v=19$m=64,t=1,p=1$c29tZXNhbHQ$a2V5") {
+		t.Error("a hasher recognizes the other's hashes")
+	}
+
+	if _, err := h.Hash(testPassword, "k1"); err == nil {
+		t.Error("bcrypt recorded a pepper")
+	}
+}
+
+// Legacy bcrypt hashes of password, salt and legacy pepper verify and are upgraded to Argon2id
+func TestAuthUpgradesBcrypt(t *testing.T) {
+	g := NewAuth("pepper", DefaultMaxPasswordLength)
+	salt := "0123456789abcdef"
+
+	legacy, err := Bcrypt{Cost: bcrypt.MinCost}.Hash(testPassword+salt+g.LegacyPepper, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !g.CheckPassword(testPassword, salt, legacy) {
+		t.Fatal("CheckPassword rejected a legacy hash")
+	}
+	if g.CheckPassword(testPassword, "", legacy) {
+		t.Error("CheckPassword accepted a legacy hash without its salt")
+	}
+	if _, isLegacy := g.PepperOf(salt, legacy); !isLegacy || !g.NeedsRehash(salt, legacy) {
+		t.Error("a legacy hash does not need a rehash")
+	}
+
+	hash, err := g.HashPassword(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !DefaultArgon2id.Recognizes(hash) || g.NeedsRehash("", hash) || !g.CheckPassword(testPassword, "", hash) {
+		t.Errorf("upgraded hash %s", hash)
+	}
+
+	// unsalted bcrypt hashes from the previous scheme are still accepted
+	plain, _ := Bcrypt{Cost: bcrypt.MinCost}.Hash(testPassword, "")
+	if !g.CheckPassword(testPassword, "", plain) || !g.NeedsRehash("", plain) {
+		t.Error("unsalted bcrypt hash is not accepted and upgraded")
+	}
+}
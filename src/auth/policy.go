@@ -0,0 +1,62 @@
+package auth
+
+import (
+	"fmt"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// PasswordPolicy decides which new passwords are accepted
+type PasswordPolicy struct {
+	MinLength   int       // in characters, never less than MinPasswordLength
+	MinStrength int       // lowest accepted score from Strength, 0 to 4
+	Breached    *Breached // optional list of passwords seen in data breaches
+}
+
+// Problem is a rule broken by a password. Message is English text with fmt verbs
+// for Args, kept apart so that it can be translated.
+type Problem struct {
+	Message string
+	Args    []interface{}
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf(p.Message, p.Args...)
+}
+
+// Check returns a problem for each rule password breaks, none if it is accepted.
+// userInputs, such as the username and email, count as easy to guess.
+// A failed breach lookup is returned as err, alongside any other problems.
+func (p PasswordPolicy) Check(password string, userInputs ...string) (problems []Problem, err error) {
+	if !utf8.ValidString(password) {
+		return []Problem{{Message: "Use valid text."}}, nil
+	}
+
+	password = norm.NFKC.String(password)
+	if utf8.RuneCountInString(password) > MaxPasswordLength {
+		return []Problem{{Message: "Use at most %d characters.", Args: []interface{}{MaxPasswordLength}}}, nil
+	}
+
+	minLength := max(p.MinLength, MinPasswordLength)
+	if utf8.RuneCountInString(password) < minLength {
+		problems = append(problems, Problem{Message: "Use at least %d characters.", Args: []interface{}{minLength}})
+	}
+
+	score, feedback := Strength(password, userInputs...)
+	if score < p.MinStrength {
+		problems = append(problems, Problem{Message: "This password is too easy to guess."})
+		for _, f := range feedback {
+			problems = append(problems, Problem{Message: f})
+		}
+	}
+
+	if p.Breached != nil {
+		var breached bool
+		breached, err = p.Breached.Contains(password)
+		if breached {
+			problems = append(problems, Problem{Message: "This password has appeared in a data breach, choose a different one."})
+		}
+	}
+	return
+}
@@ -0,0 +1,332 @@
+package auth
+
+import (
+	"math"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Kinds of guessable patterns, used to explain a low strength
+const (
+	patternBruteforce = iota
+	patternDictionary
+	patternUserInput
+	patternRepeat
+	patternSequence
+	patternKeyboard
+	patternYear
+)
+
+// Rank of the most common passwords and password words, most frequent first
+var commonPasswords = rankWords(`
+	password 123456 qwerty letmein welcome admin login iloveyou monkey dragon
+	football baseball abc123 master shadow sunshine princess trustno1 superman batman
+	starwars whatever freedom hello charlie donald michael jordan jennifer hunter
+	killer soccer hockey ranger buster thomas tigger robert daniel andrew
+	pepper ginger summer winter spring autumn flower secret access mustang
+	maggie cheese computer internet orange banana apple chocolate cookie purple
+	silver golden diamond angel lovely love loveme friend family forever
+	blessed jesus god heaven happy smile lucky sweet honey baby
+	qazwsx zaq12wsx passw0rd p@ssw0rd changeme default guest user root test
+	testing temp pass qwertz azerty asdfgh hannah jessica ashley nicole
+	amanda samantha matthew joshua william george harley yankees dallas chelsea
+	arsenal liverpool barcelona madrid united city london paris berlin rome
+	america canada mexico tennis golf hunter2 merlin wizard magic matrix
+	phoenix tiger lion eagle falcon dolphin butterfly kitten puppy doggy
+	pokemon naruto minecraft fortnite gaming player nintendo playstation xbox
+	corvette ferrari porsche mercedes bmw yamaha honda toyota nissan
+	beer pizza coffee whiskey vodka money dollar rich business office
+	work school college student teacher doctor nurse police fire water
+	earth moon star sky ocean river mountain forest garden house
+	home welcome1 password1 qwerty123 iloveyou1 monkey1 dragon1 abc
+	asdf zxcvbn hello123 admin123 root123 letmein1 secret1 superstar rockstar
+	music guitar piano rock metal jazz dance party sexy hottie
+	killer1 cowboy cowboys steelers packers lakers bulls celtic rangers hammer
+	thunder lightning storm shadow1 ninja samurai pirate viking knight king
+	queen prince lady boss chief captain major general soldier warrior
+`)
+
+// Keyboard rows, for runs like qwerty or asdf
+var keyboardRows = []string{"1234567890", "qwertyuiop", "asdfghjkl", "zxcvbnm", "qwertzuiop", "yxcvbnm", "azertyuiop", "wxcvbn"}
+
+var (
+	maxWordLength = longestWord(commonPasswords)
+	maxRowLength  = longestWord(rankWords(strings.Join(keyboardRows, " ")))
+)
+
+var leetSubstitutions = map[rune]rune{'4': 'a', '@': 'a', '3': 'e', '1': 'i', '!': 'i', '0': 'o', '5': 's', '$': 's', '7': 't', '+': 't', '8': 'b', '9': 'g'}
+
+// Guess thresholds for each score, as in zxcvbn
+var strengthThresholds = []float64{3, 6, 8, 10} // log10 of guesses
+
+const (
+	minMatchGuesses = 1  // log10, even the most common word takes a few tries
+	maxRepeatBlock  = 32 // longer blocks are as hard to guess whether repeated or not
+)
+
+var patternFeedback = map[int]string{
+	patternDictionary: "Avoid common words and passwords.",
+	patternUserInput:  "Avoid using your username or email.",
+	patternRepeat:     "Avoid repeated characters and words.",
+	patternSequence:   "Avoid sequences like abc or 1234.",
+	patternKeyboard:   "Avoid keyboard patterns like qwerty.",
+	patternYear:       "Avoid years and dates.",
+}
+
+// A guessable part of a password, with the log10 of the guesses it takes
+type match struct {
+	start, end int
+	guesses    float64
+	pattern    int
+}
+
+// Strength estimates how hard password is to guess, from 0 (too guessable) to 4 (very hard),
+// along with advice on the patterns that make it weaker. Like zxcvbn, it splits the password
+// into the cheapest combination of common words, repeats, sequences, keyboard runs, years and
+// userInputs (such as the username), and counts the guesses needed for each part.
+func Strength(password string, userInputs ...string) (score int, feedback []string) {
+	runes := []rune(password)
+	if len(runes) == 0 {
+		return 0, nil
+	}
+
+	lower := []rune(strings.ToLower(password))
+	if len(lower) != len(runes) {
+		lower = runes
+	}
+
+	matches := findMatches(runes, lower, userInputs)
+	charGuesses := math.Log10(cardinality(runes))
+
+	byEnd := make([][]*match, len(runes)+1)
+	for j := range matches {
+		byEnd[matches[j].end] = append(byEnd[matches[j].end], &matches[j])
+	}
+
+	// best[i] is the cheapest way to guess the first i characters
+	best := make([]float64, len(runes)+1)
+	last := make([]*match, len(runes)+1)
+	for i := 1; i <= len(runes); i++ {
+		best[i] = best[i-1] + charGuesses
+		last[i] = &match{start: i - 1, end: i, guesses: charGuesses, pattern: patternBruteforce}
+		for _, m := range byEnd[i] {
+			guesses := math.Max(m.guesses, minMatchGuesses)
+			if best[m.start]+guesses < best[i] {
+				best[i] = best[m.start] + guesses
+				last[i] = m
+			}
+		}
+	}
+
+	seen := map[int]bool{}
+	for i := len(runes); i > 0; i = last[i].start {
+		p := last[i].pattern
+		if p != patternBruteforce && !seen[p] {
+			seen[p] = true
+			feedback = append([]string{patternFeedback[p]}, feedback...)
+		}
+	}
+
+	for _, threshold := range strengthThresholds {
+		if best[len(runes)] >= threshold {
+			score++
+		}
+	}
+
+	if score < len(strengthThresholds) && len(feedback) == 0 {
+		feedback = append(feedback, "Add more words or characters.")
+	}
+	return
+}
+
+// Size of the character set an attacker would try for each character
+func cardinality(runes []rune) float64 {
+	var lower, upper, digit, symbol, other bool
+	for _, r := range runes {
+		switch {
+		case r >= 'a' && r <= 'z':
+			lower = true
+		case r >= 'A' && r <= 'Z':
+			upper = true
+		case r >= '0' && r <= '9':
+			digit = true
+		case r <= unicode.MaxASCII:
+			symbol = true
+		default:
+			other = true
+		}
+	}
+
+	var c float64
+	for _, set := range []struct {
+		present bool
+		size    float64
+	}{{lower, 26}, {upper, 26}, {digit, 10}, {symbol, 33}, {other, 100}} {
+		if set.present {
+			c += set.size
+		}
+	}
+	return c
+}
+
+func findMatches(runes, lower []rune, userInputs []string) (matches []match) {
+	unleet := make([]rune, len(lower))
+	for i, r := range lower {
+		if s, ok := leetSubstitutions[r]; ok {
+			unleet[i] = s
+		} else {
+			unleet[i] = r
+		}
+	}
+
+	longest := maxWordLength
+	inputs := map[string]bool{}
+	for _, input := range userInputs {
+		input = strings.ToLower(input)
+		inputs[input] = true
+		if local, _, ok := strings.Cut(input, "@"); ok {
+			inputs[local] = true
+		}
+		longest = max(longest, min(utf8.RuneCountInString(input), MaxPasswordLength))
+	}
+
+	for i := range lower {
+		for j := i + 3; j <= min(i+longest, len(lower)); j++ {
+			word := string(lower[i:j])
+			variations := 1.0
+			if string(runes[i:j]) != word && string(runes[i:j]) != strings.ToUpper(word) {
+				variations = 2 // mixed case
+			}
+
+			if inputs[word] {
+				matches = append(matches, match{i, j, math.Log10(variations), patternUserInput})
+			}
+			if r, ok := commonPasswords[word]; ok {
+				matches = append(matches, match{i, j, math.Log10(float64(r) * variations), patternDictionary})
+			} else if r, ok := commonPasswords[string(unleet[i:j])]; ok {
+				matches = append(matches, match{i, j, math.Log10(float64(r) * variations * 2), patternDictionary})
+			}
+		}
+	}
+
+	matches = append(matches, findRepeats(lower)...)
+	matches = append(matches, findSequences(lower)...)
+	matches = append(matches, findKeyboardRuns(lower)...)
+	matches = append(matches, findYears(lower)...)
+	return
+}
+
+// Runs of the same character or block, like aaa or abcabc
+func findRepeats(lower []rune) (matches []match) {
+	for i := range lower {
+		for size := 1; size <= maxRepeatBlock && i+2*size <= len(lower); size++ {
+			j := i + size
+			for j+size <= len(lower) && slices.Equal(lower[j:j+size], lower[i:i+size]) {
+				j += size
+			}
+
+			count := (j - i) / size
+			if count >= 2 && j-i >= 3 {
+				guesses := float64(size)*math.Log10(cardinality(lower[i:i+size])) + math.Log10(float64(count))
+				matches = append(matches, match{i, j, guesses, patternRepeat})
+			}
+		}
+	}
+	return
+}
+
+// Runs of consecutive characters, like abc, 1234 or zyx
+func findSequences(lower []rune) (matches []match) {
+	for i := 0; i+2 < len(lower); i++ {
+		delta := lower[i+1] - lower[i]
+		if delta != 1 && delta != -1 {
+			continue
+		}
+
+		j := i + 1
+		for j+1 < len(lower) && lower[j+1]-lower[j] == delta {
+			j++
+		}
+		if j-i+1 < 3 {
+			continue
+		}
+
+		start := 26.0
+		switch lower[i] {
+		case 'a', 'z', '0', '1', '9':
+			start = 4
+		default:
+			if unicode.IsDigit(lower[i]) {
+				start = 10
+			}
+		}
+		if delta < 0 {
+			start *= 2
+		}
+		matches = append(matches, match{i, j + 1, math.Log10(start * float64(j-i+1)), patternSequence})
+	}
+	return
+}
+
+// Runs along a keyboard row, in either direction
+func findKeyboardRuns(lower []rune) (matches []match) {
+	for i := range lower {
+		for j := i + 3; j <= min(i+maxRowLength, len(lower)); j++ {
+			run := string(lower[i:j])
+			for _, row := range keyboardRows {
+				if strings.Contains(row, run) || strings.Contains(reverse(row), run) {
+					matches = append(matches, match{i, j, math.Log10(float64(len(keyboardRows)*2) * float64(j-i)), patternKeyboard})
+					break
+				}
+			}
+		}
+	}
+	return
+}
+
+// Years from 1900 to 2099
+func findYears(lower []rune) (matches []match) {
+	for i := 0; i+4 <= len(lower); i++ {
+		year := string(lower[i : i+4])
+		if (strings.HasPrefix(year, "19") || strings.HasPrefix(year, "20")) && isDigits(year) {
+			matches = append(matches, match{i, i + 4, math.Log10(200), patternYear})
+		}
+	}
+	return
+}
+
+func rankWords(words string) map[string]int {
+	rank := map[string]int{}
+	for i, word := range strings.Fields(words) {
+		if _, ok := rank[word]; !ok {
+			rank[word] = i + 1
+		}
+	}
+	return rank
+}
+
+func longestWord(words map[string]int) (longest int) {
+	for word := range words {
+		longest = max(longest, utf8.RuneCountInString(word))
+	}
+	return
+}
+
+func isDigits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func reverse(s string) string {
+	runes := []rune(s)
+	for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+		runes[i], runes[j] = runes[j], runes[i]
+	}
+	return string(runes)
+}
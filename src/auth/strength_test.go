@@ -0,0 +1,68 @@
+package auth
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStrength(t *testing.T) {
+	tests := []struct {
+		password string
+		max      int // highest acceptable score
+		min      int // lowest acceptable score
+		feedback string
+	}{
+		{"password", 0, 0, patternFeedback[patternDictionary]},
+		{"P@ssw0rd", 1, 0, patternFeedback[patternDictionary]},
+		{"aaaaaaaa", 0, 0, patternFeedback[patternRepeat]},
+		{"abcdefgh", 0, 0, patternFeedback[patternSequence]},
+		{"zxcvbnm,./", 2, 0, patternFeedback[patternKeyboard]},
+		{"alice2024", 1, 0, patternFeedback[patternUserInput]},
+		{"19871987", 1, 0, patternFeedback[patternRepeat]},
+		{"correct horse battery staple", 4, 4, ""},
+		{"vK8#qL2!mZ9@", 4, 4, ""},
+	}
+
+	for _, test := range tests {
+		score, feedback := Strength(test.password, "alice", "alice@example.com")
+		if score < test.min || score > test.max {
+			t.Errorf("Strength(%q) = %d, want %d to %d", test.password, score, test.min, test.max)
+		}
+		if test.feedback != "" && !slices.Contains(feedback, test.feedback) {
+			t.Errorf("Strength(%q) feedback = %q, want %q", test.password, feedback, test.feedback)
+		}
+	}
+
+	if score, _ := Strength(""); score != 0 {
+		t.Errorf("Strength(\"\") = %d, want 0", score)
+	}
+}
+
+func TestStrengthLongPasswords(t *testing.T) {
+	for _, password := range []string{
+		strings.Repeat("a", MaxPasswordLength),
+		strings.Repeat("ab", MaxPasswordLength/2),
+		strings.Repeat("password", MaxPasswordLength/8),
+		strings.Repeat("qwertyuiop", MaxPasswordLength/10),
+	} {
+		start := time.Now()
+		Strength(password, strings.Repeat("x", 10000))
+		if elapsed := time.Since(start); elapsed > time.Second {
+			t.Errorf("Strength took %v for %q...", elapsed, password[:10])
+		}
+	}
+}
+
+func TestCheckTooLong(t *testing.T) {
+	problems, err := PasswordPolicy{}.Check(strings.Repeat("é", MaxPasswordLength+1))
+	if err != nil || len(problems) != 1 || problems[0].Message != "Use at most %d characters." {
+		t.Errorf("Check accepted a password over MaxPasswordLength: %v, %v", problems, err)
+	}
+
+	problems, _ = PasswordPolicy{}.Check("vK8#qL2!mZ9@" + strings.Repeat("é", MaxPasswordLength-12))
+	if len(problems) != 0 {
+		t.Errorf("Check rejected a password of MaxPasswordLength: %v", problems)
+	}
+}
@@ -0,0 +1,87 @@
+package auth
+
+import (
+	"testing"
+	"time"
+)
+
+// The SHA1 seed of RFC 6238, base32-encoded
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// Test vectors from RFC 6238, Appendix B; the codes are 8 digits there, so only the last 6 are used
+func TestValidateTOTPRFC6238(t *testing.T) {
+	var g Auth
+	vectors := []struct {
+		unix int64
+		code string
+	}{
+		{59, "94287082"},
+		{1111111109, "07081804"},
+		{1111111111, "14050471"},
+		{1234567890, "89005924"},
+		{2000000000, "69279037"},
+		{20000000000, "65353130"},
+	}
+
+	for _, v := range vectors {
+		code := v.code[len(v.code)-totpDigits:]
+		step, ok := g.ValidateTOTP(rfc6238Secret, code, time.Unix(v.unix, 0), 0)
+		if !ok || step != v.unix/totpPeriod {
+			t.Errorf("ValidateTOTP(%s) at %d = %d, %v, want %d", code, v.unix, step, ok, v.unix/totpPeriod)
+		}
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	var g Auth
+	at := time.Unix(1111111111, 0)
+	const code = "050471"
+
+	if _, ok := g.ValidateTOTP("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "050 471", at, 0); !ok {
+		t.Error("rejected a lowercase secret or a spaced code")
+	}
+
+	// one step of clock drift either way is accepted, two are not
+	for _, drift := range []time.Duration{-totpPeriod * time.Second, totpPeriod * time.Second} {
+		if _, ok := g.ValidateTOTP(rfc6238Secret, code, at.Add(drift), 0); !ok {
+			t.Errorf("rejected a drift of %v", drift)
+		}
+	}
+	for _, drift := range []time.Duration{-2 * totpPeriod * time.Second, 2 * totpPeriod * time.Second} {
+		if _, ok := g.ValidateTOTP(rfc6238Secret, code, at.Add(drift), 0); ok {
+			t.Errorf("accepted a drift of %v", drift)
+		}
+	}
+
+	step, _ := g.ValidateTOTP(rfc6238Secret, code, at, 0)
+	if _, ok := g.ValidateTOTP(rfc6238Secret, code, at, step); ok {
+		t.Error("accepted a replayed code")
+	}
+
+	for _, bad := range []string{"", "05047", "0504711", "abcdef", "050472"} {
+		if _, ok := g.ValidateTOTP(rfc6238Secret, bad, at, 0); ok {
+			t.Errorf("accepted %q", bad)
+		}
+	}
+	if _, ok := g.ValidateTOTP("not base32!", code, at, 0); ok {
+		t.Error("accepted an invalid secret")
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	var g Auth
+	secret, err := g.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := totpEncoding.DecodeString(secret)
+	if err != nil || len(key) != totpSecretSize {
+		t.Errorf("secret %q decodes to %d bytes, %v", secret, len(key), err)
+	}
+
+	code := totpCode(key, time.Now().Unix()/totpPeriod)
+	if _, ok := g.ValidateTOTP(secret, code, time.Now(), 0); !ok {
+		t.Error("rejected the current code of a new secret")
+	}
+}
@@ -0,0 +1,118 @@
+package egress
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrScheme is returned for URLs that are not http or https (or not https, when required)
+	ErrScheme = errors.New("URL scheme is not allowed")
+	// ErrAddress is returned for hosts that resolve to loopback, private or link-local addresses
+	ErrAddress = errors.New("URL host is not a public address")
+
+	// ranges that are not caught by the net.IP helpers
+	reserved = []netip.Prefix{
+		netip.MustParsePrefix("0.0.0.0/8"),
+		netip.MustParsePrefix("100.64.0.0/10"),
+		netip.MustParsePrefix("192.0.0.0/24"),
+		netip.MustParsePrefix("198.18.0.0/15"),
+		netip.MustParsePrefix("240.0.0.0/4"),
+		netip.MustParsePrefix("64:ff9b::/96"),
+		netip.MustParsePrefix("64:ff9b:1::/48"),
+		netip.MustParsePrefix("2001:db8::/32"),
+	}
+)
+
+// Public reports whether ip can be reached from the internet
+func Public(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	if !ip.IsValid() || ip.IsUnspecified() || ip.IsLoopback() || ip.IsPrivate() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return false
+	}
+
+	for _, prefix := range reserved {
+		if prefix.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckURL returns an error unless raw is an absolute http(s) URL whose host only resolves to public addresses
+func CheckURL(raw string, httpsOnly bool) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+
+	if u.Scheme != "https" && (httpsOnly || u.Scheme != "http") {
+		return ErrScheme
+	}
+
+	host := u.Hostname()
+	if host == "" {
+		return ErrAddress
+	}
+
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if !Public(ip) {
+			return ErrAddress
+		}
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ips, err := net.DefaultResolver.LookupNetIP(ctx, "ip", host)
+	if err != nil {
+		return err
+	}
+
+	for _, ip := range ips {
+		if !Public(ip) {
+			return ErrAddress
+		}
+	}
+	return nil
+}
+
+// control refuses connections to non-public addresses, after DNS resolution
+func control(network, address string, c syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil || !Public(addrPort.Addr()) {
+		return ErrAddress
+	}
+	return nil
+}
+
+// NewClient returns an http.Client that only connects to public addresses, including on redirects
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: 10 * time.Second, Control: control}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 10 {
+				return errors.New("stopped after 10 redirects")
+			}
+			if req.URL.Scheme != "https" && req.URL.Scheme != "http" {
+				return ErrScheme
+			}
+			return nil
+		},
+	}
+}
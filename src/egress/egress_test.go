@@ -0,0 +1,69 @@
+package egress
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestPublic(t *testing.T) {
+	for _, s := range []string{"93.184.215.14", "1.1.1.1", "2606:4700:4700::1111"} {
+		if !Public(netip.MustParseAddr(s)) {
+			t.Errorf("Public(%s) = false", s)
+		}
+	}
+
+	for _, s := range []string{
+		"127.0.0.1", "10.1.2.3", "172.16.0.1", "192.168.1.1", "169.254.169.254", "0.0.0.0",
+		"100.64.0.1", "224.0.0.1", "255.255.255.255", "::1", "::", "fe80::1", "fd00::1",
+		"::ffff:127.0.0.1", "::ffff:10.0.0.1", "64:ff9b::a00:1",
+	} {
+		if Public(netip.MustParseAddr(s)) {
+			t.Errorf("Public(%s) = true", s)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	tests := []struct {
+		url       string
+		httpsOnly bool
+		want      error
+	}{
+		{"https://93.184.215.14/topic", true, nil},
+		{"http://93.184.215.14/topic", false, nil},
+		{"http://93.184.215.14/topic", true, ErrScheme},
+		{"ftp://93.184.215.14/topic", false, ErrScheme},
+		{"file:///etc/passwd", false, ErrScheme},
+		{"/relative", false, ErrScheme},
+		{"https:///no-host", false, ErrAddress},
+		{"http://127.0.0.1:8080/", false, ErrAddress},
+		{"http://[::1]/", false, ErrAddress},
+		{"http://169.254.169.254/latest/meta-data", false, ErrAddress},
+		{"https://192.168.1.10/", false, ErrAddress},
+		{"http://localhost/", false, ErrAddress},
+	}
+
+	for _, test := range tests {
+		err := CheckURL(test.url, test.httpsOnly)
+		if !errors.Is(err, test.want) {
+			t.Errorf("CheckURL(%q, %v) = %v, want %v", test.url, test.httpsOnly, err, test.want)
+		}
+	}
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer server.Close()
+
+	_, err := NewClient(time.Second).Get(server.URL)
+	if !errors.Is(err, ErrAddress) || hits != 0 {
+		t.Errorf("Get(%s) = %v with %d requests, want ErrAddress", server.URL, err, hits)
+	}
+}
@@ -0,0 +1,207 @@
+package email
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Key from RFC 8463, Appendix A
+const (
+	rfc8463Seed      = "nWGxne/9WmC6hEr0kuwsxERJxWl7MmkZcDusAxyuf2A="
+	rfc8463PublicKey = "11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+)
+
+// Message from RFC 8463, Appendix A.2
+const rfc8463Message = "From: Joe SixPack <joe@football.example.com>\r\n" +
+	"To: Suzie Q <suzie@shopping.example.net>\r\n" +
+	"Subject: Is dinner ready?\r\n" +
+	"Date: Fri, 11 Jul 2003 21:00:37 -0700 (PDT)\r\n" +
+	"Message-ID: <20030712040037.46341.5F8J@football.example.com>\r\n" +
+	"\r\n" +
+	"Hi.\r\n" +
+	"\r\n" +
+	"We lost the game.  Are you hungry yet?\r\n" +
+	"\r\n" +
+	"Joe.\r\n"
+
+func writeKey(t *testing.T, key interface{}) string {
+	t.Helper()
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	path := filepath.Join(t.TempDir(), "dkim.pem")
+	err = os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// Relaxed header canonicalization as spelled out in RFC 6376, section 3.4.2
+func canonicalHeader(field string) string {
+	name, value, _ := strings.Cut(field, ":")
+	value = strings.NewReplacer("\r\n", "", "\t", " ").Replace(value)
+	for strings.Contains(value, "  ") {
+		value = strings.ReplaceAll(value, "  ", " ")
+	}
+	return strings.ToLower(strings.TrimRight(name, " \t")) + ":" + strings.Trim(value, " ") + "\r\n"
+}
+
+// Checks the DKIM-Signature that Sign prepended to msg, returning its tags and the signed data hash
+func verifySignature(t *testing.T, signed []byte, check func(digest, signature []byte) bool) map[string]string {
+	t.Helper()
+
+	header, body, ok := strings.Cut(string(signed), "\r\n\r\n")
+	if !ok {
+		t.Fatal("signed message has no body")
+	}
+
+	// unfold the header into fields, DKIM-Signature first
+	var fields []string
+	for _, line := range strings.Split(header, "\r\n") {
+		if strings.HasPrefix(line, " ") || strings.HasPrefix(line, "\t") {
+			fields[len(fields)-1] += "\r\n" + line
+			continue
+		}
+		fields = append(fields, line)
+	}
+	if !strings.HasPrefix(fields[0], "DKIM-Signature:") {
+		t.Fatalf("first header is %q", fields[0])
+	}
+
+	tags := map[string]string{}
+	_, value, _ := strings.Cut(fields[0], ":")
+	for _, tag := range strings.Split(value, ";") {
+		name, v, _ := strings.Cut(tag, "=")
+		tags[strings.TrimSpace(name)] = strings.NewReplacer("\r\n", "", "\t", "", " ", "").Replace(v)
+	}
+
+	bodyHash := sha256.Sum256(relaxedBody([]byte(body)))
+	if tags["bh"] != base64.StdEncoding.EncodeToString(bodyHash[:]) {
+		t.Errorf("bh=%s does not match the body", tags["bh"])
+	}
+
+	hash := sha256.New()
+	for _, name := range strings.Split(tags["h"], ":") {
+		found := false
+		for i := len(fields) - 1; i > 0; i-- {
+			fieldName, _, _ := strings.Cut(fields[i], ":")
+			if strings.EqualFold(strings.TrimSpace(fieldName), name) {
+				hash.Write([]byte(canonicalHeader(fields[i])))
+				found = true
+				break
+			}
+		}
+		if !found {
+			t.Errorf("signed header %s is not in the message", name)
+		}
+	}
+
+	b := strings.LastIndex(fields[0], "b=")
+	hash.Write([]byte(strings.TrimSuffix(canonicalHeader(fields[0][:b+2]), "\r\n")))
+
+	signature, err := base64.StdEncoding.DecodeString(tags["b"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !check(hash.Sum(nil), signature) {
+		t.Error("signature does not verify")
+	}
+	return tags
+}
+
+func TestRelaxedCanonicalization(t *testing.T) {
+	// RFC 6376, section 3.4.5
+	fields := parseHeader("A: X\r\nB : Y\t\r\n Z  ")
+	got := relaxedHeader(fields["a"]) + relaxedHeader(fields["b"])
+	if got != "a:X\r\nb:Y Z\r\n" {
+		t.Errorf("relaxed header = %q", got)
+	}
+
+	body := relaxedBody([]byte(" C \r\nD \t E\r\n\r\n\r\n"))
+	if string(body) != " C\r\nD E\r\n" {
+		t.Errorf("relaxed body = %q", body)
+	}
+
+	if body := relaxedBody([]byte("\r\n\r\n")); len(body) != 0 {
+		t.Errorf("relaxed empty body = %q", body)
+	}
+}
+
+func TestDKIMEd25519KnownKey(t *testing.T) {
+	seed, _ := base64.StdEncoding.DecodeString(rfc8463Seed)
+	public, _ := base64.StdEncoding.DecodeString(rfc8463PublicKey)
+	key := ed25519.NewKeyFromSeed(seed)
+	if !key.Public().(ed25519.PublicKey).Equal(ed25519.PublicKey(public)) {
+		t.Fatal("seed does not match the RFC 8463 public key")
+	}
+
+	d, err := NewDKIM("football.example.com", "brisbane", writeKey(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := d.Sign([]byte(rfc8463Message))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := verifySignature(t, signed, func(digest, signature []byte) bool {
+		return ed25519.Verify(ed25519.PublicKey(public), digest, signature)
+	})
+
+	// body hash from RFC 8463, Appendix A.3
+	if tags["bh"] != "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=" {
+		t.Errorf("bh=%s", tags["bh"])
+	}
+	if tags["a"] != "ed25519-sha256" || tags["c"] != "relaxed/relaxed" || tags["d"] != "football.example.com" || tags["s"] != "brisbane" {
+		t.Errorf("unexpected tags %v", tags)
+	}
+	if tags["h"] != "From:To:Subject:Date:Message-ID" {
+		t.Errorf("h=%s", tags["h"])
+	}
+}
+
+func TestDKIMRSA(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDKIM("example.com", "mail", writeKey(t, key))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := Email{
+		To:      []string{"user@example.net"},
+		Subject: "Reminder: drink  water",
+		Body:    "Time to drink.  \r\n\r\n\r\n",
+		HTML:    "<p>Time to drink.</p>",
+	}.Message(Sender{Name: "WellBinge", Address: "app@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signed, err := d.Sign(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifySignature(t, signed, func(digest, signature []byte) bool {
+		return rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest, signature) == nil
+	})
+}
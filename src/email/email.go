@@ -1,44 +1,66 @@
 package email
 
-import (
-	"net/smtp"
-)
+import "net/mail"
 
-// EmailConfig rappresenta la configurazione necessaria per inviare un'email
+// Client builds emails and hands them to a Transport
 type Client struct {
-	email string
+	sender Sender
 
-	auth smtp.Auth
-	addr string
+	transport Transport
+	dkim      *DKIM
 }
 
-// Email rappresenta il contenuto dell'email
+// Sender is who the emails come from. Envelope is the SMTP MAIL FROM address that
+// receives bounces, and defaults to Address.
+type Sender struct {
+	Name     string
+	Address  string
+	ReplyTo  string
+	Envelope string
+}
+
+// Email is the content of an email; HTML is an optional alternative to the plain text Body
 type Email struct {
 	To      []string
 	Subject string
 	Body    string
+	HTML    string
 }
 
-func NewClient(senderEmail, password, host, port string) *Client {
+func NewClient(sender Sender, transport Transport) *Client {
+	if sender.Envelope == "" {
+		sender.Envelope = sender.Address
+	}
+
 	return &Client{
-		addr: host + ":" + port,
-		auth: smtp.PlainAuth("", senderEmail, password, host),
+		sender:    sender,
+		transport: transport,
 	}
 }
 
-// Send invia l'email utilizzando la configurazione fornita
+// SignWith makes the client add a DKIM signature to every message
+func (config *Client) SignWith(dkim *DKIM) {
+	config.dkim = dkim
+}
+
+// Send builds the MIME message and delivers it through the transport
 func (config *Client) Send(email Email) error {
-	// Costruzione del messaggio
-	msg := "From: " + config.email + "\n" +
-		"To: " + email.To[0] + "\n" +
-		"Subject: " + email.Subject + "\n\n" +
-		email.Body
-
-	// Invio email tramite il server SMTP
-	err := smtp.SendMail(config.addr, config.auth, config.email, email.To, []byte(msg))
+	msg, err := email.Message(config.sender)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if config.dkim != nil {
+		msg, err = config.dkim.Sign(msg)
+		if err != nil {
+			return err
+		}
+	}
+
+	return config.transport.Send(config.sender.Envelope, email.To, msg)
+}
+
+// From is the From header value, with the display name if there is one
+func (sender Sender) From() string {
+	return (&mail.Address{Name: sender.Name, Address: sender.Address}).String()
 }
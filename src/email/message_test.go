@@ -0,0 +1,134 @@
+package email
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"strings"
+	"testing"
+)
+
+var testSender = Sender{Name: "WellBinge", Address: "app@example.com", ReplyTo: "help@example.com"}
+
+// A line longer than quoted-printable allows, with a character that must be encoded
+const testBody = "Time to drink water, it has been a while since the last glass and your streak of " +
+	"seven days is at stake.\nCiao, è ora!"
+
+func parseMessage(t *testing.T, msg []byte) *mail.Message {
+	t.Helper()
+
+	// quoted-printable keeps body lines within 76 characters
+	_, body, _ := strings.Cut(string(msg), "\r\n\r\n")
+	for _, line := range strings.Split(body, "\r\n") {
+		if len(line) > 76 {
+			t.Errorf("line longer than 76 characters: %q", line)
+		}
+	}
+
+	parsed, err := mail.ReadMessage(bytes.NewReader(msg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed
+}
+
+func TestPlainMessage(t *testing.T) {
+	msg, err := Email{To: []string{"a@example.net", "b@example.net"}, Subject: "Promemoria: è ora", Body: testBody}.Message(testSender)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed := parseMessage(t, msg)
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || subject != "Promemoria: è ora" {
+		t.Errorf("subject %q, %v", subject, err)
+	}
+
+	for key, want := range map[string]string{
+		"From":                      `"WellBinge" <app@example.com>`,
+		"Reply-To":                  "help@example.com",
+		"To":                        "a@example.net, b@example.net",
+		"MIME-Version":              "1.0",
+		"Content-Type":              "text/plain; charset=utf-8",
+		"Content-Transfer-Encoding": "quoted-printable",
+	} {
+		if got := parsed.Header.Get(key); got != want {
+			t.Errorf("%s: %q, want %q", key, got, want)
+		}
+	}
+
+	if id := parsed.Header.Get("Message-ID"); !strings.HasPrefix(id, "<") || !strings.HasSuffix(id, "@example.com>") {
+		t.Errorf("Message-ID %s", id)
+	}
+	if _, err := parsed.Header.Date(); err != nil {
+		t.Error(err)
+	}
+
+	body, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != strings.ReplaceAll(testBody, "\n", "\r\n") {
+		t.Errorf("body %q", body)
+	}
+}
+
+func TestMultipartMessage(t *testing.T) {
+	html := `<p>Time to drink water.</p><p><a href="https://example.com/habits?id=1&amp;ack=1">Ack</a></p>`
+	msg, err := Email{To: []string{"a@example.net"}, Subject: "Water", Body: testBody, HTML: html}.Message(Sender{Address: "app@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	parsed := parseMessage(t, msg)
+	if from := parsed.Header.Get("From"); from != "<app@example.com>" {
+		t.Errorf("From without a name: %q", from)
+	}
+	if parsed.Header.Get("Reply-To") != "" {
+		t.Error("Reply-To without a reply address")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type %s, %v", mediaType, err)
+	}
+
+	// the reader decodes quoted-printable parts
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", strings.ReplaceAll(testBody, "\n", "\r\n")},
+		{"text/html; charset=utf-8", html},
+	} {
+		part, err := reader.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if part.Header.Get("Content-Type") != want.contentType {
+			t.Errorf("part is %s, want %s", part.Header.Get("Content-Type"), want.contentType)
+		}
+
+		body, err := io.ReadAll(part)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(body) != want.body {
+			t.Errorf("%s part %q, want %q", want.contentType, body, want.body)
+		}
+	}
+
+	if _, err := reader.NextPart(); err != io.EOF {
+		t.Errorf("more than two parts: %v", err)
+	}
+}
+
+func TestMessageErrors(t *testing.T) {
+	if _, err := (Email{Subject: "Water", Body: "Drink."}).Message(testSender); err == nil {
+		t.Error("a message without recipients was built")
+	}
+	if _, err := (Email{To: []string{"a@example.net"}, Body: "Drink."}).Message(Sender{}); err == nil {
+		t.Error("a message without a sender was built")
+	}
+}
@@ -0,0 +1,87 @@
+package email
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// File writes each message to Dir as an .eml file, for development
+type File struct {
+	Dir string
+}
+
+// Maildir delivers messages into a Maildir, readable by most mail clients
+type Maildir struct {
+	Dir string
+}
+
+// Writer prints messages, for development; NewStdout writes to the standard output
+type Writer struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func NewStdout() *Writer {
+	return &Writer{w: os.Stdout}
+}
+
+// Unique and sortable by delivery time
+func messageName() (string, error) {
+	id := make([]byte, 4)
+	_, err := rand.Read(id)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d.%d_%s", time.Now().UnixNano(), os.Getpid(), hex.EncodeToString(id)), nil
+}
+
+func (f File) Send(from string, to []string, msg []byte) error {
+	name, err := messageName()
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(f.Dir, 0700)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(f.Dir, name+".eml"), msg, 0600)
+}
+
+func (md Maildir) Send(from string, to []string, msg []byte) error {
+	for _, sub := range []string{"tmp", "new", "cur"} {
+		err := os.MkdirAll(filepath.Join(md.Dir, sub), 0700)
+		if err != nil {
+			return err
+		}
+	}
+
+	name, err := messageName()
+	if err != nil {
+		return err
+	}
+
+	// written to tmp first, so readers never see a partial message in new
+	tmp := filepath.Join(md.Dir, "tmp", name)
+	err = os.WriteFile(tmp, msg, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filepath.Join(md.Dir, "new", name))
+}
+
+func (wr *Writer) Send(from string, to []string, msg []byte) error {
+	wr.mu.Lock()
+	defer wr.mu.Unlock()
+
+	_, err := fmt.Fprintf(wr.w, "---- Email from %s to %s ----\n%s\n---- End of email ----\n",
+		from, strings.Join(to, ", "), strings.ReplaceAll(string(msg), "\r\n", "\n"))
+	return err
+}
@@ -0,0 +1,104 @@
+package email
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testMessage = "Subject: Water\r\n\r\nTime to drink.\r\n"
+
+// Returns the names of the files in dir, failing if it cannot be read
+func listDir(t *testing.T, dir string) []string {
+	t.Helper()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var names []string
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return names
+}
+
+func TestFile(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "outbox") // created on the first message
+	f := File{Dir: dir}
+
+	for range 2 {
+		err := f.Send("app@example.com", []string{"user@example.net"}, []byte(testMessage))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	names := listDir(t, dir)
+	if len(names) != 2 || names[0] == names[1] {
+		t.Fatalf("files %v, want two distinct messages", names)
+	}
+	for _, name := range names {
+		if !strings.HasSuffix(name, ".eml") {
+			t.Errorf("%s is not an .eml file", name)
+		}
+
+		content, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != testMessage {
+			t.Errorf("%s holds %q", name, content)
+		}
+	}
+}
+
+func TestMaildir(t *testing.T) {
+	dir := t.TempDir()
+	md := Maildir{Dir: dir}
+
+	err := md.Send("app@example.com", []string{"user@example.net"}, []byte(testMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// delivered messages are moved out of tmp
+	if names := listDir(t, filepath.Join(dir, "tmp")); len(names) != 0 {
+		t.Errorf("tmp still holds %v", names)
+	}
+	if names := listDir(t, filepath.Join(dir, "cur")); len(names) != 0 {
+		t.Errorf("cur holds %v", names)
+	}
+
+	names := listDir(t, filepath.Join(dir, "new"))
+	if len(names) != 1 {
+		t.Fatalf("new holds %v, want one message", names)
+	}
+	content, err := os.ReadFile(filepath.Join(dir, "new", names[0]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(content) != testMessage {
+		t.Errorf("message %q", content)
+	}
+}
+
+func TestWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w := &Writer{w: &buf}
+
+	err := w.Send("app@example.com", []string{"a@example.net", "b@example.net"}, []byte(testMessage))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "---- Email from app@example.com to a@example.net, b@example.net ----\n" +
+		"Subject: Water\n\nTime to drink.\n\n" +
+		"---- End of email ----\n"
+	if buf.String() != want {
+		t.Errorf("wrote %q, want %q", buf.String(), want)
+	}
+}
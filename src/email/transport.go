@@ -0,0 +1,121 @@
+package email
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// Transport delivers a built message to its recipients
+type Transport interface {
+	Send(from string, to []string, msg []byte) error
+}
+
+// Security of the connection to an SMTP server
+const (
+	SMTPStartTLS = "starttls" // upgrade a plain connection, failing if the server cannot
+	SMTPTLS      = "tls"      // implicit TLS, usually on port 465
+	SMTPPlain    = "none"     // no encryption, only for local relays
+)
+
+// SMTP sends messages through a mail server; authentication is skipped without a password
+type SMTP struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	Security string
+	Timeout  time.Duration
+	RootCAs  *x509.CertPool // nil trusts the system roots
+}
+
+const defaultSMTPTimeout = 30 * time.Second
+
+func (s SMTP) dial() (*smtp.Client, error) {
+	timeout := s.Timeout
+	if timeout == 0 {
+		timeout = defaultSMTPTimeout
+	}
+
+	addr := net.JoinHostPort(s.Host, s.Port)
+	dialer := &net.Dialer{Timeout: timeout}
+	tlsConfig := &tls.Config{ServerName: s.Host, RootCAs: s.RootCAs}
+
+	var conn net.Conn
+	var err error
+	if s.Security == SMTPTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	c, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if s.Security == SMTPStartTLS || s.Security == "" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			c.Close()
+			return nil, errors.New("the SMTP server does not support STARTTLS")
+		}
+
+		err = c.StartTLS(tlsConfig)
+		if err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+func (s SMTP) Send(from string, to []string, msg []byte) error {
+	c, err := s.dial()
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if s.Password != "" {
+		err = c.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = c.Mail(from)
+	if err != nil {
+		return err
+	}
+
+	for _, address := range to {
+		err = c.Rcpt(address)
+		if err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(msg)
+	if err != nil {
+		return err
+	}
+
+	err = w.Close()
+	if err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,243 @@
+package email
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"net"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// What a fakeSMTP server received in a session
+type smtpSession struct {
+	TLS  bool
+	Auth string
+	From string
+	To   []string
+	Data string
+}
+
+// A minimal SMTP server, enough for net/smtp to deliver a message
+type fakeSMTP struct {
+	listener net.Listener
+	tls      *tls.Config
+	startTLS bool // offer STARTTLS on plain connections
+
+	mu       sync.Mutex
+	sessions []smtpSession
+}
+
+// Returns a certificate for 127.0.0.1 and a pool that trusts it
+func testCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// Starts a server speaking implicit TLS if implicitTLS is set, plain SMTP otherwise
+func newFakeSMTP(t *testing.T, cert tls.Certificate, implicitTLS, startTLS bool) *fakeSMTP {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeSMTP{tls: &tls.Config{Certificates: []tls.Certificate{cert}}, startTLS: startTLS}
+	if implicitTLS {
+		listener = tls.NewListener(listener, s.tls)
+	}
+	s.listener = listener
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, implicitTLS)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSMTP) port() string {
+	_, port, _ := net.SplitHostPort(s.listener.Addr().String())
+	return port
+}
+
+func (s *fakeSMTP) serve(conn net.Conn, secure bool) {
+	defer conn.Close()
+
+	session := smtpSession{TLS: secure}
+	text := textproto.NewConn(conn)
+	text.PrintfLine("220 localhost ESMTP")
+
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			return
+		}
+
+		command, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(command) {
+		case "EHLO", "HELO":
+			text.PrintfLine("250-localhost")
+			if s.startTLS && !session.TLS {
+				text.PrintfLine("250-STARTTLS")
+			}
+			text.PrintfLine("250 AUTH PLAIN")
+		case "STARTTLS":
+			text.PrintfLine("220 Ready to start TLS")
+			tlsConn := tls.Server(conn, s.tls)
+			if tlsConn.Handshake() != nil {
+				return
+			}
+			conn = tlsConn
+			text = textproto.NewConn(conn)
+			session.TLS = true
+		case "AUTH":
+			_, encoded, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(encoded)
+			session.Auth = string(decoded)
+			text.PrintfLine("235 Authenticated")
+		case "MAIL":
+			session.From = strings.Trim(strings.TrimPrefix(arg, "FROM:"), "<>")
+			text.PrintfLine("250 OK")
+		case "RCPT":
+			session.To = append(session.To, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			text.PrintfLine("250 OK")
+		case "DATA":
+			text.PrintfLine("354 Go ahead")
+			data, err := text.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			session.Data = string(data)
+			text.PrintfLine("250 Queued")
+		case "QUIT":
+			s.mu.Lock()
+			s.sessions = append(s.sessions, session)
+			s.mu.Unlock()
+			text.PrintfLine("221 Bye")
+			return
+		default:
+			text.PrintfLine("250 OK")
+		}
+	}
+}
+
+func (s *fakeSMTP) received() []smtpSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]smtpSession(nil), s.sessions...)
+}
+
+func TestSMTP(t *testing.T) {
+	cert, pool := testCertificate(t)
+
+	tests := []struct {
+		name        string
+		security    string
+		implicitTLS bool
+		startTLS    bool
+		password    string
+		roots       *x509.CertPool
+		wantErr     bool
+		wantTLS     bool
+	}{
+		{name: "none", security: SMTPPlain, startTLS: true, roots: pool},
+		{name: "none with auth on localhost", security: SMTPPlain, password: "secret", roots: pool},
+		{name: "starttls", security: SMTPStartTLS, startTLS: true, password: "secret", roots: pool, wantTLS: true},
+		{name: "starttls by default", startTLS: true, roots: pool, wantTLS: true},
+		{name: "starttls not offered", security: SMTPStartTLS, roots: pool, wantErr: true},
+		{name: "starttls untrusted", security: SMTPStartTLS, startTLS: true, wantErr: true},
+		{name: "tls", security: SMTPTLS, implicitTLS: true, password: "secret", roots: pool, wantTLS: true},
+		{name: "tls untrusted", security: SMTPTLS, implicitTLS: true, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		server := newFakeSMTP(t, cert, tt.implicitTLS, tt.startTLS)
+		transport := SMTP{
+			Host:     "127.0.0.1",
+			Port:     server.port(),
+			Username: "app",
+			Password: tt.password,
+			Security: tt.security,
+			Timeout:  5 * time.Second,
+			RootCAs:  tt.roots,
+		}
+
+		err := transport.Send("app@example.com", []string{"a@example.net", "b@example.net"}, []byte(testMessage))
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("%s: Send succeeded", tt.name)
+			}
+			if len(server.received()) != 0 {
+				t.Errorf("%s: a message was delivered", tt.name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+
+		sessions := server.received()
+		if len(sessions) != 1 {
+			t.Fatalf("%s: %d sessions, want 1", tt.name, len(sessions))
+		}
+		got := sessions[0]
+		if got.TLS != tt.wantTLS {
+			t.Errorf("%s: TLS %t, want %t", tt.name, got.TLS, tt.wantTLS)
+		}
+		if wantAuth := "\x00app\x00" + tt.password; tt.password != "" && got.Auth != wantAuth {
+			t.Errorf("%s: auth %q, want %q", tt.name, got.Auth, wantAuth)
+		}
+		if tt.password == "" && got.Auth != "" {
+			t.Errorf("%s: authenticated without a password", tt.name)
+		}
+		if got.From != "app@example.com" || strings.Join(got.To, ",") != "a@example.net,b@example.net" {
+			t.Errorf("%s: from %s to %v", tt.name, got.From, got.To)
+		}
+		// ReadDotBytes turns CRLF into LF
+		if got.Data != strings.ReplaceAll(testMessage, "\r\n", "\n") {
+			t.Errorf("%s: data %q", tt.name, got.Data)
+		}
+	}
+}
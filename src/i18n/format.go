@@ -0,0 +1,59 @@
+package i18n
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Date formats the day of t with the "format.date" pattern, e.g. "{month} {day}, {year}"
+func (l *Localizer) Date(t time.Time) string {
+	return strings.NewReplacer(
+		"{day}", strconv.Itoa(t.Day()),
+		"{month}", l.T(t.Month().String()),
+		"{year}", strconv.Itoa(t.Year()),
+	).Replace(l.T("format.date"))
+}
+
+// Time formats the time of day of t with the "format.time" layout, as used by time.Format
+func (l *Localizer) Time(t time.Time) string {
+	return t.Format(l.T("format.time"))
+}
+
+// DateTime joins Date and Time with the "format.datetime" pattern
+func (l *Localizer) DateTime(t time.Time) string {
+	return strings.NewReplacer(
+		"{date}", l.Date(t),
+		"{time}", l.Time(t),
+	).Replace(l.T("format.datetime"))
+}
+
+// Ago describes how many days have passed since t, e.g. "2 weeks, 3 days ago"
+func (l *Localizer) Ago(t time.Time) string {
+	days := int(time.Since(t).Hours()) / 24
+
+	var unit, rest string
+	switch {
+	case days <= 0:
+		return l.T("Today")
+	case days == 1:
+		return l.T("Yesterday")
+	case days <= 7:
+		unit = l.N("%d day", days)
+	case days <= 30:
+		unit = l.N("%d week", days/7)
+		if days%7 != 0 {
+			rest = l.N("%d day", days%7)
+		}
+	default:
+		unit = l.N("%d month", days/30)
+		if days%30 != 0 {
+			rest = l.N("%d day", days%30)
+		}
+	}
+
+	if rest != "" {
+		unit = l.T("%s, %s", unit, rest)
+	}
+	return l.T("%s ago", unit)
+}
@@ -0,0 +1,183 @@
+package i18n
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+)
+
+// Bundle holds one message catalogue per language, read from files named after the
+// language tag (en.json, it.json). Keys are the English text, so the fallback
+// catalogue only needs the messages with plural forms and formats.
+type Bundle struct {
+	tags       []language.Tag // fallback first, as the matcher default
+	matcher    language.Matcher
+	localizers map[string]*Localizer
+}
+
+// Localizer translates messages into a single language
+type Localizer struct {
+	tag      language.Tag
+	catalog  catalog
+	fallback *Localizer
+}
+
+type catalog map[string]message
+
+// A message is either a plain translation or one per CLDR plural category (one, other, ...)
+type message struct {
+	text  string
+	forms map[string]string
+}
+
+func (m *message) UnmarshalJSON(b []byte) error {
+	if json.Unmarshal(b, &m.text) == nil {
+		return nil
+	}
+
+	err := json.Unmarshal(b, &m.forms)
+	if err != nil {
+		return err
+	}
+	if m.forms["other"] == "" {
+		return errors.New("plural messages need an \"other\" form")
+	}
+	return nil
+}
+
+// Load reads every catalogue in dir; fallback is used for unsupported languages and missing messages
+func Load(dir, fallback string) (*Bundle, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Bundle{localizers: map[string]*Localizer{}}
+	var others []language.Tag
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		tag, err := language.Parse(name)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		encoded, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+
+		l := &Localizer{tag: tag}
+		err = json.Unmarshal(encoded, &l.catalog)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+
+		b.localizers[tag.String()] = l
+		if tag.String() == fallback {
+			b.tags = append([]language.Tag{tag}, b.tags...)
+		} else {
+			others = append(others, tag)
+		}
+	}
+
+	if len(b.tags) == 0 {
+		return nil, fmt.Errorf("no catalogue for the fallback language %s in %s", fallback, dir)
+	}
+	b.tags = append(b.tags, others...)
+	b.matcher = language.NewMatcher(b.tags)
+
+	for _, l := range b.localizers {
+		if l.tag != b.tags[0] {
+			l.fallback = b.localizers[fallback]
+		}
+	}
+	return b, nil
+}
+
+// Languages returns the supported language tags, fallback first
+func (b *Bundle) Languages() []string {
+	languages := make([]string, len(b.tags))
+	for i, tag := range b.tags {
+		languages[i] = tag.String()
+	}
+	return languages
+}
+
+// Localizer picks the best supported language for the preferences, in order: each is
+// a language tag or an Accept-Language header, and empty ones are skipped.
+func (b *Bundle) Localizer(preferences ...string) *Localizer {
+	var wanted []language.Tag
+	for _, preference := range preferences {
+		tags, _, err := language.ParseAcceptLanguage(preference)
+		if err == nil {
+			wanted = append(wanted, tags...)
+		}
+	}
+
+	_, index, confidence := b.matcher.Match(wanted...)
+	if confidence == language.No {
+		index = 0
+	}
+	return b.localizers[b.tags[index].String()]
+}
+
+// Language returns the language tag, as used in the lang attribute
+func (l *Localizer) Language() string {
+	return l.tag.String()
+}
+
+// Name returns the name of the language in the language itself
+func (l *Localizer) Name() string {
+	return display.Self.Name(l.tag)
+}
+
+// T translates key, formatting args into it like fmt.Sprintf
+func (l *Localizer) T(key string, args ...interface{}) string {
+	text := key
+	if m, owner := l.lookup(key); owner != nil {
+		text = m.text
+		if m.forms != nil {
+			text = m.forms["other"]
+		}
+	}
+
+	if len(args) == 0 {
+		return text
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// N translates key in the plural form for n, which is the first formatting argument
+func (l *Localizer) N(key string, n int, args ...interface{}) string {
+	args = append([]interface{}{n}, args...)
+
+	m, owner := l.lookup(key)
+	if owner == nil {
+		return fmt.Sprintf(key, args...)
+	}
+	if m.forms == nil {
+		return fmt.Sprintf(m.text, args...)
+	}
+
+	text, ok := m.forms[pluralCategory(owner.tag, n)]
+	if !ok {
+		text = m.forms["other"]
+	}
+	return fmt.Sprintf(text, args...)
+}
+
+// Returns the message for key and the localizer it was found in, nil if there is none
+func (l *Localizer) lookup(key string) (message, *Localizer) {
+	for ; l != nil; l = l.fallback {
+		if m, ok := l.catalog[key]; ok {
+			return m, l
+		}
+	}
+	return message{}, nil
+}
@@ -0,0 +1,204 @@
+package i18n
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/text/language"
+)
+
+func writeCatalogs(t *testing.T, catalogs map[string]string) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	for name, content := range catalogs {
+		err := os.WriteFile(filepath.Join(dir, name+".json"), []byte(content), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func testBundle(t *testing.T) *Bundle {
+	t.Helper()
+
+	b, err := Load(writeCatalogs(t, map[string]string{
+		"en": `{
+			"%d day": { "one": "%d day", "other": "%d days" },
+			"%d week": { "one": "%d week", "other": "%d weeks" },
+			"Only in English": "Only in English, %s"
+		}`,
+		"it": `{
+			"%d day": { "one": "%d giorno", "many": "%d di giorni", "other": "%d giorni" },
+			"Hello": "Ciao",
+			"%d habit": "%d abitudini"
+		}`,
+	}), "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPluralCategory(t *testing.T) {
+	tests := []struct {
+		tag  string
+		n    int
+		want string
+	}{
+		{"en", 0, "other"},
+		{"en", 1, "one"},
+		{"en", 2, "other"},
+		{"en", -1, "one"},
+		{"en-GB", 1, "one"},
+		{"it", 0, "other"},
+		{"it", 1, "one"},
+		{"it", 2, "other"},
+		{"it", 1000, "other"},
+		{"it", 1000000, "many"},
+		{"it", 2000000, "many"},
+		{"it", 1000001, "other"},
+		{"it-CH", 1000000, "many"},
+		{"ja", 1, "one"}, // no rule, English is used
+	}
+
+	for _, tt := range tests {
+		if got := pluralCategory(language.MustParse(tt.tag), tt.n); got != tt.want {
+			t.Errorf("pluralCategory(%s, %d) = %s, want %s", tt.tag, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestN(t *testing.T) {
+	b := testBundle(t)
+	en, it := b.Localizer("en"), b.Localizer("it")
+
+	tests := []struct {
+		l    *Localizer
+		key  string
+		n    int
+		want string
+	}{
+		{en, "%d day", 1, "1 day"},
+		{en, "%d day", 2, "2 days"},
+		{en, "%d day", 0, "0 days"},
+		{it, "%d day", 1, "1 giorno"},
+		{it, "%d day", 3, "3 giorni"},
+		{it, "%d day", 1000000, "1000000 di giorni"},
+		{it, "%d habit", 1, "1 abitudini"}, // not a plural message
+		{it, "%d week", 2, "2 weeks"},      // from the fallback, with its rule
+		{it, "%d month", 1, "1 month"},     // in no catalogue
+	}
+
+	for _, tt := range tests {
+		if got := tt.l.N(tt.key, tt.n); got != tt.want {
+			t.Errorf("%s: N(%q, %d) = %q, want %q", tt.l.Language(), tt.key, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestLocalizerFallback(t *testing.T) {
+	b := testBundle(t)
+	if languages := b.Languages(); len(languages) != 2 || languages[0] != "en" {
+		t.Errorf("Languages() = %v, want the fallback first", languages)
+	}
+
+	tests := []struct {
+		preferences []string
+		want        string
+	}{
+		{nil, "en"},
+		{[]string{""}, "en"},
+		{[]string{"it"}, "it"},
+		{[]string{"it-IT,it;q=0.9,en;q=0.8"}, "it"},
+		{[]string{"de-DE,it;q=0.5"}, "it"},
+		{[]string{"fr-FR,fr;q=0.9"}, "en"},
+		{[]string{"not a tag"}, "en"},
+		{[]string{"", "it"}, "it"},   // a user without a language follows the browser
+		{[]string{"en", "it"}, "en"}, // the user's language wins over the browser
+	}
+
+	for _, tt := range tests {
+		if got := b.Localizer(tt.preferences...).Language(); got != tt.want {
+			t.Errorf("Localizer(%q) = %s, want %s", tt.preferences, got, tt.want)
+		}
+	}
+
+	it := b.Localizer("it")
+	if got := it.T("Hello"); got != "Ciao" {
+		t.Errorf("T(Hello) = %q", got)
+	}
+	if got := it.T("Only in English", "really"); got != "Only in English, really" {
+		t.Errorf("a message missing in Italian = %q, want the English one", got)
+	}
+	if got := it.T("Missing everywhere"); got != "Missing everywhere" {
+		t.Errorf("a missing message = %q, want the key", got)
+	}
+	if got := b.Localizer("en").T("Hello"); got != "Hello" {
+		t.Errorf("English used the Italian catalogue: %q", got)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := map[string]map[string]string{
+		"no fallback":        {"it": `{}`},
+		"invalid JSON":       {"en": `{`},
+		"plural, no other":   {"en": `{ "%d day": { "one": "%d day" } }`},
+		"invalid name":       {"en": `{}`, "not_a_language!": `{}`},
+		"not a message type": {"en": `{ "Hello": 1 }`},
+	}
+
+	for name, catalogs := range tests {
+		if _, err := Load(writeCatalogs(t, catalogs), "en"); err == nil {
+			t.Errorf("%s: Load succeeded", name)
+		}
+	}
+}
+
+func TestAgo(t *testing.T) {
+	b := testBundle(t)
+	en := b.Localizer("en")
+
+	day := 24 * time.Hour
+	tests := []struct {
+		ago  time.Duration
+		want string
+	}{
+		{time.Hour, "Today"},
+		{day + time.Hour, "Yesterday"},
+		{3*day + time.Hour, "3 days ago"},
+		{7*day + time.Hour, "7 days ago"},
+		{8*day + time.Hour, "1 week, 1 day ago"},
+		{14*day + time.Hour, "2 weeks ago"},
+		{45*day + time.Hour, "1 month, 15 days ago"},
+	}
+
+	for _, tt := range tests {
+		if got := en.Ago(time.Now().Add(-tt.ago)); got != tt.want {
+			t.Errorf("Ago(-%s) = %q, want %q", tt.ago, got, tt.want)
+		}
+	}
+}
+
+// The catalogues shipped with the app load, and every plural form keeps the count
+func TestShippedCatalogs(t *testing.T) {
+	b, err := Load(filepath.Join("..", "..", "locales"), "en")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, language := range b.Languages() {
+		l := b.localizers[language]
+		for key, m := range l.catalog {
+			for category, form := range m.forms {
+				if !strings.Contains(form, "%d") {
+					t.Errorf("%s: %q form %s has no %%d", language, key, category)
+				}
+			}
+		}
+	}
+}
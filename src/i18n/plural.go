@@ -0,0 +1,36 @@
+package i18n
+
+import "golang.org/x/text/language"
+
+// Plural rules for integer counts from the CLDR, by base language.
+// Languages without a rule use the English one.
+var pluralRules = map[string]func(n int) string{
+	"en": func(n int) string {
+		if n == 1 {
+			return "one"
+		}
+		return "other"
+	},
+	"it": func(n int) string {
+		switch {
+		case n == 1:
+			return "one"
+		case n != 0 && n%1000000 == 0:
+			return "many" // un milione di giorni
+		}
+		return "other"
+	},
+}
+
+func pluralCategory(tag language.Tag, n int) string {
+	if n < 0 {
+		n = -n
+	}
+
+	base, _ := tag.Base()
+	rule, ok := pluralRules[base.String()]
+	if !ok {
+		rule = pluralRules["en"]
+	}
+	return rule(n)
+}
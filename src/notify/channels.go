@@ -0,0 +1,140 @@
+package notify
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/birabittoh/auth-boilerplate/src/email"
+)
+
+// Mailer sends or queues an email, like email.Client
+type Mailer interface {
+	Send(mail email.Email) error
+}
+
+// Email sends messages through a Mailer
+type Email struct {
+	Mailer Mailer
+	To     string
+}
+
+// Ntfy publishes to a topic on an ntfy server
+type Ntfy struct {
+	Server string
+	Topic  string
+	Token  string
+}
+
+// Gotify pushes to a Gotify server using an application token
+type Gotify struct {
+	Server string
+	Token  string
+}
+
+// Telegram sends messages through the Telegram Bot API
+type Telegram struct {
+	Token  string
+	ChatID string
+}
+
+// Matrix posts to a room using a client access token
+type Matrix struct {
+	Homeserver  string
+	AccessToken string
+	RoomID      string
+}
+
+// Discord posts to a Discord incoming webhook
+type Discord struct {
+	WebhookURL string
+}
+
+// Slack posts to a Slack incoming webhook
+type Slack struct {
+	WebhookURL string
+}
+
+var matrixTxn atomic.Uint64
+
+func (n Email) Notify(message Message) error {
+	body := message.Body
+	if message.URL != "" {
+		body += "\n\n" + message.URL
+	}
+
+	return n.Mailer.Send(email.Email{
+		To:      []string{n.To},
+		Subject: message.Title,
+		Body:    body,
+	})
+}
+
+func (n Ntfy) Notify(message Message) error {
+	header := http.Header{}
+	header.Set("Title", message.Title)
+	if message.URL != "" {
+		header.Set("Click", message.URL)
+	}
+	if n.Token != "" {
+		header.Set("Authorization", "Bearer "+n.Token)
+	}
+
+	return do(http.MethodPost, n.Server+"/"+url.PathEscape(n.Topic), header, strings.NewReader(message.Body))
+}
+
+func (n Gotify) Notify(message Message) error {
+	payload := map[string]interface{}{
+		"title":    message.Title,
+		"message":  message.Body,
+		"priority": 5,
+	}
+	if message.URL != "" {
+		payload["extras"] = map[string]interface{}{
+			"client::notification": map[string]interface{}{
+				"click": map[string]string{"url": message.URL},
+			},
+		}
+	}
+
+	header := http.Header{}
+	header.Set("X-Gotify-Key", n.Token)
+
+	return doJSON(http.MethodPost, n.Server+"/message", header, payload)
+}
+
+func (n Telegram) Notify(message Message) error {
+	return doJSON(http.MethodPost, telegramAPI+"/bot"+n.Token+"/sendMessage", nil, map[string]string{
+		"chat_id": n.ChatID,
+		"text":    message.Text(),
+	})
+}
+
+func (n Matrix) Notify(message Message) error {
+	txnID := strconv.FormatInt(time.Now().UnixNano(), 36) + "." + strconv.FormatUint(matrixTxn.Add(1), 36)
+	endpoint := n.Homeserver + "/_matrix/client/v3/rooms/" + url.PathEscape(n.RoomID) +
+		"/send/m.room.message/" + txnID
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+n.AccessToken)
+
+	return doJSON(http.MethodPut, endpoint, header, map[string]string{
+		"msgtype": "m.text",
+		"body":    message.Text(),
+	})
+}
+
+func (n Discord) Notify(message Message) error {
+	return doJSON(http.MethodPost, n.WebhookURL, nil, map[string]string{
+		"content": strings.TrimSpace("**" + message.Title + "**\n" + message.details()),
+	})
+}
+
+func (n Slack) Notify(message Message) error {
+	return doJSON(http.MethodPost, n.WebhookURL, nil, map[string]string{
+		"text": strings.TrimSpace("*" + message.Title + "*\n" + message.details()),
+	})
+}
@@ -0,0 +1,169 @@
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/birabittoh/auth-boilerplate/src/egress"
+)
+
+// Message is a channel-agnostic notification
+type Message struct {
+	Title string `json:"title"`
+	Body  string `json:"body"`
+	URL   string `json:"url"`
+}
+
+// Notifier delivers a message to a single destination
+type Notifier interface {
+	Notify(message Message) error
+}
+
+const (
+	KindEmail    = "email"
+	KindNtfy     = "ntfy"
+	KindGotify   = "gotify"
+	KindTelegram = "telegram"
+	KindMatrix   = "matrix"
+	KindDiscord  = "discord"
+	KindSlack    = "slack"
+
+	defaultNtfyServer = "https://ntfy.sh"
+	telegramAPI       = "https://api.telegram.org"
+)
+
+var (
+	// Kinds lists every channel kind that can be built with New
+	Kinds = []string{KindNtfy, KindGotify, KindTelegram, KindMatrix, KindDiscord, KindSlack}
+
+	errMissingURL    = errors.New("missing URL")
+	errMissingToken  = errors.New("missing token")
+	errMissingTarget = errors.New("missing target")
+
+	client = egress.NewClient(30 * time.Second)
+)
+
+// New builds the notifier for an HTTP-based channel kind.
+// The meaning of url, token and target depends on the kind.
+// User-supplied URLs must be http(s) and resolve to public addresses.
+func New(kind, url, token, target string) (Notifier, error) {
+	url = strings.TrimRight(url, "/")
+	if url != "" {
+		err := egress.CheckURL(url, false)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	switch kind {
+	case KindNtfy:
+		if url == "" {
+			url = defaultNtfyServer
+		}
+		if target == "" {
+			return nil, errMissingTarget
+		}
+		return Ntfy{Server: url, Topic: target, Token: token}, nil
+	case KindGotify:
+		if url == "" {
+			return nil, errMissingURL
+		}
+		if token == "" {
+			return nil, errMissingToken
+		}
+		return Gotify{Server: url, Token: token}, nil
+	case KindTelegram:
+		if token == "" {
+			return nil, errMissingToken
+		}
+		if target == "" {
+			return nil, errMissingTarget
+		}
+		return Telegram{Token: token, ChatID: target}, nil
+	case KindMatrix:
+		if url == "" {
+			return nil, errMissingURL
+		}
+		if token == "" {
+			return nil, errMissingToken
+		}
+		if target == "" {
+			return nil, errMissingTarget
+		}
+		return Matrix{Homeserver: url, AccessToken: token, RoomID: target}, nil
+	case KindDiscord:
+		if url == "" {
+			return nil, errMissingURL
+		}
+		return Discord{WebhookURL: url}, nil
+	case KindSlack:
+		if url == "" {
+			return nil, errMissingURL
+		}
+		return Slack{WebhookURL: url}, nil
+	}
+
+	return nil, fmt.Errorf("unknown channel kind %q", kind)
+}
+
+// Text renders a message as plain text, for channels without a title field
+func (m Message) Text() string {
+	return strings.TrimSpace(m.Title + "\n" + m.details())
+}
+
+func (m Message) details() string {
+	return strings.TrimSpace(m.Body + "\n" + m.URL)
+}
+
+// Telegram tokens and Discord and Slack webhooks are in the URL, which errors must not carry into logs
+func redactURL(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%s request failed: %w", urlErr.Op, urlErr.Err)
+	}
+	return err
+}
+
+func do(method, target string, header http.Header, body io.Reader) error {
+	req, err := http.NewRequest(method, target, body)
+	if err != nil {
+		return redactURL(err)
+	}
+
+	for k, v := range header {
+		req.Header[k] = v
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s: %w", req.URL.Host, redactURL(err))
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("%s returned %s: %s", req.URL.Host, res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+func doJSON(method, target string, header http.Header, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	if header == nil {
+		header = http.Header{}
+	}
+	header.Set("Content-Type", "application/json")
+
+	return do(method, target, header, bytes.NewReader(body))
+}
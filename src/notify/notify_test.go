@@ -0,0 +1,87 @@
+package notify
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/birabittoh/auth-boilerplate/src/egress"
+)
+
+func TestNewRefusesPrivateURLs(t *testing.T) {
+	tests := []struct {
+		kind, url string
+		want      error
+	}{
+		{KindNtfy, "http://127.0.0.1:8080", egress.ErrAddress},
+		{KindGotify, "http://192.168.1.2", egress.ErrAddress},
+		{KindMatrix, "https://[fe80::1]", egress.ErrAddress},
+		{KindDiscord, "http://169.254.169.254/latest/meta-data", egress.ErrAddress},
+		{KindSlack, "http://localhost/hook", egress.ErrAddress},
+		{KindSlack, "file:///etc/passwd", egress.ErrScheme},
+		{KindGotify, "gopher://93.184.215.14", egress.ErrScheme},
+	}
+
+	for _, test := range tests {
+		_, err := New(test.kind, test.url, "token", "target")
+		if !errors.Is(err, test.want) {
+			t.Errorf("New(%s, %q) = %v, want %v", test.kind, test.url, err, test.want)
+		}
+	}
+
+	for _, kind := range Kinds {
+		_, err := New(kind, "https://93.184.215.14", "token", "target")
+		if err != nil {
+			t.Errorf("New(%s) with a public URL = %v", kind, err)
+		}
+	}
+
+	// the default ntfy server is not checked, so this needs no DNS
+	n, err := New(KindNtfy, "", "", "topic")
+	if err != nil || n.(Ntfy).Server != defaultNtfyServer {
+		t.Errorf("New(ntfy) without a URL = %+v, %v", n, err)
+	}
+}
+
+func TestSendRefusesPrivateAddresses(t *testing.T) {
+	hits := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits++
+	}))
+	defer server.Close()
+
+	// built directly, as if the URL had been saved before it was checked
+	err := Slack{WebhookURL: server.URL + "/services/T000/B000/secret"}.Notify(Message{Title: "Hi"})
+	if !errors.Is(err, egress.ErrAddress) || hits != 0 {
+		t.Errorf("Notify to loopback = %v with %d requests, want ErrAddress", err, hits)
+	}
+	if err != nil && strings.Contains(err.Error(), "secret") {
+		t.Errorf("error leaks the webhook URL: %v", err)
+	}
+}
+
+func TestNtfy(t *testing.T) {
+	var header http.Header
+	var body string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		b, _ := io.ReadAll(r.Body)
+		body = r.URL.Path + " " + string(b)
+	}))
+	defer server.Close()
+
+	previous := client
+	client = server.Client()
+	defer func() { client = previous }()
+
+	err := Ntfy{Server: server.URL, Topic: "habits", Token: "tk"}.Notify(Message{Title: "Water", Body: "Drink", URL: "https://example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if body != "/habits Drink" || header.Get("Title") != "Water" || header.Get("Click") != "https://example.com" || header.Get("Authorization") != "Bearer tk" {
+		t.Errorf("ntfy received %q with %v", body, header)
+	}
+}
@@ -0,0 +1,272 @@
+package push
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/birabittoh/auth-boilerplate/src/egress"
+	"golang.org/x/crypto/hkdf"
+)
+
+// Client sends Web Push messages signed with a VAPID key (RFC 8291, RFC 8292)
+type Client struct {
+	PublicKey string
+
+	key     *ecdsa.PrivateKey
+	subject string
+	http    *http.Client
+}
+
+// Subscription is what the browser returns from PushManager.subscribe()
+type Subscription struct {
+	Endpoint string
+	P256dh   string
+	Auth     string
+}
+
+const recordSize = 4096
+
+var (
+	// ErrGone is returned when the push service says the subscription does not exist anymore
+	ErrGone = errors.New("push subscription is gone")
+
+	encoding = base64.RawURLEncoding
+)
+
+// GenerateKey returns a new PEM-encoded VAPID private key
+func GenerateKey() ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+}
+
+// NewClient loads a PEM-encoded VAPID private key; subject should be a mailto: or https: URL
+func NewClient(privateKey []byte, subject string) (*Client, error) {
+	block, _ := pem.Decode(privateKey)
+	if block == nil {
+		return nil, errors.New("invalid VAPID key")
+	}
+
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok || key.Curve != elliptic.P256() {
+		return nil, errors.New("VAPID key must be a P-256 ECDSA key")
+	}
+
+	pub, err := key.PublicKey.ECDH()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Client{
+		PublicKey: encoding.EncodeToString(pub.Bytes()),
+		key:       key,
+		subject:   subject,
+		http:      egress.NewClient(30 * time.Second),
+	}, nil
+}
+
+// CheckEndpoint returns an error unless endpoint is an https URL on a public host
+func CheckEndpoint(endpoint string) error {
+	return egress.CheckURL(endpoint, true)
+}
+
+// Send encrypts the payload for the given subscription and delivers it to its push service
+func (c *Client) Send(sub Subscription, payload []byte, ttl time.Duration) error {
+	if !strings.HasPrefix(sub.Endpoint, "https://") {
+		return egress.ErrScheme
+	}
+
+	body, err := encrypt(sub, payload)
+	if err != nil {
+		return err
+	}
+
+	authorization, err := c.vapid(sub.Endpoint)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, sub.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("Content-Encoding", "aes128gcm")
+	req.Header.Set("TTL", strconv.Itoa(int(ttl.Seconds())))
+	req.Header.Set("Urgency", "normal")
+	req.Header.Set("Authorization", authorization)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusNotFound || res.StatusCode == http.StatusGone:
+		return ErrGone
+	case res.StatusCode >= 300:
+		msg, _ := io.ReadAll(io.LimitReader(res.Body, 512))
+		return fmt.Errorf("push service returned %s: %s", res.Status, strings.TrimSpace(string(msg)))
+	}
+	return nil
+}
+
+// vapid builds the Authorization header value for the push service behind endpoint
+func (c *Client) vapid(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", err
+	}
+
+	header, _ := json.Marshal(map[string]string{"typ": "JWT", "alg": "ES256"})
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": u.Scheme + "://" + u.Host,
+		"exp": time.Now().Add(12 * time.Hour).Unix(),
+		"sub": c.subject,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	unsigned := encoding.EncodeToString(header) + "." + encoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+
+	r, s, err := ecdsa.Sign(rand.Reader, c.key, digest[:])
+	if err != nil {
+		return "", err
+	}
+
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	jwt := unsigned + "." + encoding.EncodeToString(signature)
+	return "vapid t=" + jwt + ", k=" + c.PublicKey, nil
+}
+
+// encrypt implements the aes128gcm content encoding for a single record (RFC 8291)
+func encrypt(sub Subscription, payload []byte) ([]byte, error) {
+	asPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	salt := make([]byte, 16)
+	_, err = rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return encryptWith(sub, payload, asPrivate, salt)
+}
+
+// encryptWith encrypts with the given ephemeral key and salt, which must never be reused
+func encryptWith(sub Subscription, payload []byte, asPrivate *ecdh.PrivateKey, salt []byte) ([]byte, error) {
+	uaPublicBytes, err := decodeKey(sub.P256dh)
+	if err != nil {
+		return nil, err
+	}
+
+	authSecret, err := decodeKey(sub.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	uaPublic, err := ecdh.P256().NewPublicKey(uaPublicBytes)
+	if err != nil {
+		return nil, err
+	}
+	asPublicBytes := asPrivate.PublicKey().Bytes()
+
+	secret, err := asPrivate.ECDH(uaPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPublicBytes...)
+	keyInfo = append(keyInfo, asPublicBytes...)
+	ikm, err := derive(authSecret, secret, keyInfo, 32)
+	if err != nil {
+		return nil, err
+	}
+
+	cek, err := derive(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce, err := derive(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	// a single record, terminated by the last record delimiter
+	plaintext := append(append([]byte{}, payload...), 0x02)
+	if len(plaintext)+gcm.Overhead() > recordSize {
+		return nil, errors.New("push payload is too large")
+	}
+
+	header := make([]byte, 0, 16+4+1+len(asPublicBytes))
+	header = append(header, salt...)
+	header = binary.BigEndian.AppendUint32(header, recordSize)
+	header = append(header, byte(len(asPublicBytes)))
+	header = append(header, asPublicBytes...)
+
+	return gcm.Seal(header, nonce, plaintext, nil), nil
+}
+
+func derive(salt, secret, info []byte, length int) ([]byte, error) {
+	out := make([]byte, length)
+	_, err := io.ReadFull(hkdf.New(sha256.New, secret, salt, info), out)
+	return out, err
+}
+
+func decodeKey(s string) ([]byte, error) {
+	s = strings.TrimRight(s, "=")
+	s = strings.NewReplacer("+", "-", "/", "_").Replace(s)
+	return encoding.DecodeString(s)
+}
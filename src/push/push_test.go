@@ -0,0 +1,311 @@
+package push
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/birabittoh/auth-boilerplate/src/egress"
+)
+
+// decrypt is the user agent's side of RFC 8291
+func decrypt(t *testing.T, uaPrivate *ecdh.PrivateKey, authSecret, body []byte) []byte {
+	t.Helper()
+
+	if len(body) < 21 || len(body) < 21+int(body[20]) {
+		t.Fatalf("body too short: %d bytes", len(body))
+	}
+	salt := body[:16]
+	rs := binary.BigEndian.Uint32(body[16:20])
+	keyID := body[21 : 21+int(body[20])]
+	ciphertext := body[21+int(body[20]):]
+	if rs != recordSize || uint32(len(ciphertext)) > rs {
+		t.Fatalf("record size %d for %d bytes", rs, len(ciphertext))
+	}
+
+	asPublic, err := ecdh.P256().NewPublicKey(keyID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret, err := uaPrivate.ECDH(asPublic)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyInfo := append([]byte("WebPush: info\x00"), uaPrivate.PublicKey().Bytes()...)
+	keyInfo = append(keyInfo, keyID...)
+	ikm, _ := derive(authSecret, secret, keyInfo, 32)
+	cek, _ := derive(salt, ikm, []byte("Content-Encoding: aes128gcm\x00"), 16)
+	nonce, _ := derive(salt, ikm, []byte("Content-Encoding: nonce\x00"), 12)
+
+	block, _ := aes.NewCipher(cek)
+	gcm, _ := cipher.NewGCM(block)
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(plaintext) == 0 || plaintext[len(plaintext)-1] != 0x02 {
+		t.Fatalf("missing last record delimiter in %x", plaintext)
+	}
+	return plaintext[:len(plaintext)-1]
+}
+
+func newSubscription(t *testing.T, endpoint string) (Subscription, *ecdh.PrivateKey, []byte) {
+	t.Helper()
+
+	uaPrivate, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	authSecret := make([]byte, 16)
+	rand.Read(authSecret)
+
+	return Subscription{
+		Endpoint: endpoint,
+		P256dh:   encoding.EncodeToString(uaPrivate.PublicKey().Bytes()),
+		Auth:     encoding.EncodeToString(authSecret),
+	}, uaPrivate, authSecret
+}
+
+func newTestClient(t *testing.T) *Client {
+	t.Helper()
+
+	key, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(key, "mailto:admin@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// The example in RFC 8291, Appendix A
+func TestEncryptRFC8291(t *testing.T) {
+	decode := func(s string) []byte {
+		b, err := encoding.DecodeString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+
+	asPrivate, err := ecdh.P256().NewPrivateKey(decode("yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sub := Subscription{
+		P256dh: "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4",
+		Auth:   "BTBZMqHH6r4Tts7J_aSIgg",
+	}
+
+	// the example uses a record size of 4096 too
+	want := "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN"
+	got, err := encryptWith(sub, []byte("When I grow up, I want to be a watermelon"), asPrivate, decode("DGv6ra1nlYgDCS1FRnbzlw"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if encoding.EncodeToString(got) != want {
+		t.Errorf("encryptWith = %s, want %s", encoding.EncodeToString(got), want)
+	}
+
+	uaPrivate, err := ecdh.P256().NewPrivateKey(decode("q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext := decrypt(t, uaPrivate, decode(sub.Auth), got); string(plaintext) != "When I grow up, I want to be a watermelon" {
+		t.Errorf("decrypted %q", plaintext)
+	}
+}
+
+func TestEncrypt(t *testing.T) {
+	sub, uaPrivate, authSecret := newSubscription(t, "")
+
+	// browsers may hand out keys in standard, padded base64
+	padded := sub
+	padded.P256dh = strings.NewReplacer("-", "+", "_", "/").Replace(sub.P256dh) + "="
+	padded.Auth = strings.NewReplacer("-", "+", "_", "/").Replace(sub.Auth) + "=="
+
+	for _, s := range []Subscription{sub, padded} {
+		body, err := encrypt(s, []byte(`{"title":"Hi"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plaintext := decrypt(t, uaPrivate, authSecret, body); string(plaintext) != `{"title":"Hi"}` {
+			t.Errorf("decrypted %q", plaintext)
+		}
+	}
+
+	first, _ := encrypt(sub, []byte("same"))
+	second, _ := encrypt(sub, []byte("same"))
+	if bytes.Equal(first[:16], second[:16]) || bytes.Equal(first[21:86], second[21:86]) {
+		t.Error("salt or ephemeral key was reused")
+	}
+
+	_, err := encrypt(sub, make([]byte, recordSize))
+	if err == nil {
+		t.Error("encrypted a payload larger than a record")
+	}
+
+	bad := sub
+	bad.P256dh = encoding.EncodeToString([]byte("not a point"))
+	_, err = encrypt(bad, nil)
+	if err == nil {
+		t.Error("encrypted for an invalid public key")
+	}
+}
+
+// Verifies the VAPID header like a push service would (RFC 8292)
+func TestVapid(t *testing.T) {
+	c := newTestClient(t)
+
+	authorization, err := c.vapid("https://push.example.net/send/abc?x=1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var jwt, k string
+	for _, part := range strings.Split(strings.TrimPrefix(authorization, "vapid "), ", ") {
+		switch {
+		case strings.HasPrefix(part, "t="):
+			jwt = part[2:]
+		case strings.HasPrefix(part, "k="):
+			k = part[2:]
+		}
+	}
+	if k != c.PublicKey {
+		t.Errorf("k = %q, want %q", k, c.PublicKey)
+	}
+
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("malformed JWT %q", jwt)
+	}
+
+	var header map[string]string
+	b, _ := encoding.DecodeString(parts[0])
+	json.Unmarshal(b, &header)
+	if header["alg"] != "ES256" || header["typ"] != "JWT" {
+		t.Errorf("header = %v", header)
+	}
+
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	b, _ = encoding.DecodeString(parts[1])
+	json.Unmarshal(b, &claims)
+	if claims.Aud != "https://push.example.net" || claims.Sub != "mailto:admin@example.com" {
+		t.Errorf("claims = %+v", claims)
+	}
+	if exp := time.Unix(claims.Exp, 0); exp.Before(time.Now()) || exp.After(time.Now().Add(24*time.Hour)) {
+		t.Errorf("exp = %v, must be within 24 hours (RFC 8292, section 2)", exp)
+	}
+
+	// the signature is the raw 64-byte r || s, verified against the advertised key
+	signature, _ := encoding.DecodeString(parts[2])
+	if len(signature) != 64 {
+		t.Fatalf("signature is %d bytes, want 64", len(signature))
+	}
+	publicKey, _ := encoding.DecodeString(k)
+	if len(publicKey) != 65 || publicKey[0] != 4 {
+		t.Fatalf("k is not an uncompressed P-256 point: %x", publicKey)
+	}
+	key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(publicKey[1:33]), Y: new(big.Int).SetBytes(publicKey[33:])}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r, s := new(big.Int).SetBytes(signature[:32]), new(big.Int).SetBytes(signature[32:])
+	if !ecdsa.Verify(key, digest[:], r, s) {
+		t.Error("signature does not verify")
+	}
+}
+
+func TestSend(t *testing.T) {
+	var status atomic.Int32
+	var received atomic.Value
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received.Store(body)
+
+		if r.Header.Get("Content-Encoding") != "aes128gcm" || r.Header.Get("TTL") != "60" ||
+			!strings.HasPrefix(r.Header.Get("Authorization"), "vapid t=") {
+			http.Error(w, "bad headers", http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(int(status.Load()))
+	}))
+	defer server.Close()
+
+	c := newTestClient(t)
+	c.http = server.Client()
+	sub, uaPrivate, authSecret := newSubscription(t, server.URL+"/push/1")
+
+	status.Store(http.StatusCreated)
+	err := c.Send(sub, []byte("hello"), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if plaintext := decrypt(t, uaPrivate, authSecret, received.Load().([]byte)); string(plaintext) != "hello" {
+		t.Errorf("push service received %q", plaintext)
+	}
+
+	status.Store(http.StatusGone)
+	if err := c.Send(sub, []byte("hello"), time.Minute); !errors.Is(err, ErrGone) {
+		t.Errorf("Send to a gone subscription = %v, want ErrGone", err)
+	}
+
+	status.Store(http.StatusTooManyRequests)
+	if err := c.Send(sub, []byte("hello"), time.Minute); err == nil || errors.Is(err, ErrGone) {
+		t.Errorf("Send on 429 = %v", err)
+	}
+
+	plain := sub
+	plain.Endpoint = strings.Replace(sub.Endpoint, "https://", "http://", 1)
+	if err := c.Send(plain, []byte("hello"), time.Minute); !errors.Is(err, egress.ErrScheme) {
+		t.Errorf("Send over http = %v, want ErrScheme", err)
+	}
+}
+
+func TestSendRefusesPrivateAddresses(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	// the default client, which must not reach the test server on the loopback interface
+	c := newTestClient(t)
+	sub, _, _ := newSubscription(t, server.URL)
+	err := c.Send(sub, []byte("hello"), time.Minute)
+	if !errors.Is(err, egress.ErrAddress) || hits.Load() != 0 {
+		t.Errorf("Send to loopback = %v with %d requests, want ErrAddress", err, hits.Load())
+	}
+
+	for _, endpoint := range []string{"http://push.example.net/1", "https://127.0.0.1/1", "https://[::1]/1", "https://169.254.169.254/latest", "https://10.0.0.1/1", "file:///etc/passwd"} {
+		if CheckEndpoint(endpoint) == nil {
+			t.Errorf("CheckEndpoint(%q) accepted", endpoint)
+		}
+	}
+	if err := CheckEndpoint("https://93.184.215.14/push"); err != nil {
+		t.Errorf("CheckEndpoint for a public address = %v", err)
+	}
+}
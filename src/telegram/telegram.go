@@ -0,0 +1,190 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Bot is a minimal Telegram Bot API client
+type Bot struct {
+	Username string
+
+	token  string
+	apiURL string
+	http   *http.Client
+}
+
+type Update struct {
+	UpdateID      int64          `json:"update_id"`
+	Message       *Message       `json:"message"`
+	CallbackQuery *CallbackQuery `json:"callback_query"`
+}
+
+type Message struct {
+	MessageID int64  `json:"message_id"`
+	From      *User  `json:"from"`
+	Chat      Chat   `json:"chat"`
+	Text      string `json:"text"`
+}
+
+type Chat struct {
+	ID   int64  `json:"id"`
+	Type string `json:"type"` // private, group, supergroup or channel
+}
+
+type User struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+type CallbackQuery struct {
+	ID      string   `json:"id"`
+	From    User     `json:"from"`
+	Message *Message `json:"message"`
+	Data    string   `json:"data"`
+}
+
+type InlineKeyboardButton struct {
+	Text         string `json:"text"`
+	CallbackData string `json:"callback_data"`
+}
+
+type InlineKeyboardMarkup struct {
+	InlineKeyboard [][]InlineKeyboardButton `json:"inline_keyboard"`
+}
+
+// Error is returned when the Bot API rejects a request
+type Error struct {
+	Method      string
+	Code        int
+	Description string
+}
+
+type response struct {
+	OK          bool            `json:"ok"`
+	ErrorCode   int             `json:"error_code"`
+	Description string          `json:"description"`
+	Result      json.RawMessage `json:"result"`
+}
+
+const (
+	DefaultAPIURL = "https://api.telegram.org"
+	ChatPrivate   = "private"
+	pollTimeout   = 50 * time.Second
+)
+
+func (e *Error) Error() string {
+	return "telegram: " + e.Method + ": " + e.Description
+}
+
+// NewBot checks the token against the Bot API server at apiURL
+func NewBot(token, apiURL string) (*Bot, error) {
+	if apiURL == "" {
+		apiURL = DefaultAPIURL
+	}
+
+	b := &Bot{
+		token:  token,
+		apiURL: strings.TrimRight(apiURL, "/"),
+		http:   &http.Client{Timeout: pollTimeout + 10*time.Second},
+	}
+
+	var me User
+	err := b.call("getMe", struct{}{}, &me)
+	if err != nil {
+		return nil, err
+	}
+
+	b.Username = me.Username
+	return b, nil
+}
+
+func (b *Bot) call(method string, params interface{}, result interface{}) error {
+	body, err := json.Marshal(params)
+	if err != nil {
+		return err
+	}
+
+	res, err := b.http.Post(b.apiURL+"/bot"+b.token+"/"+method, "application/json", bytes.NewReader(body))
+	if err != nil {
+		// the URL holds the token, so it must not end up in logs
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("telegram: %s: %w", method, err)
+	}
+	defer res.Body.Close()
+
+	var r response
+	err = json.NewDecoder(res.Body).Decode(&r)
+	if err != nil {
+		return err
+	}
+
+	if !r.OK {
+		return &Error{Method: method, Code: r.ErrorCode, Description: r.Description}
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(r.Result, result)
+}
+
+// GetUpdates long-polls for updates with an ID greater or equal to offset
+func (b *Bot) GetUpdates(offset int64) (updates []Update, err error) {
+	err = b.call("getUpdates", map[string]interface{}{
+		"offset":          offset,
+		"timeout":         int(pollTimeout.Seconds()),
+		"allowed_updates": []string{"message", "callback_query"},
+	}, &updates)
+	return
+}
+
+// SendMessage sends a text message, with an optional inline keyboard
+func (b *Bot) SendMessage(chatID int64, text string, markup *InlineKeyboardMarkup) error {
+	params := map[string]interface{}{
+		"chat_id": chatID,
+		"text":    text,
+	}
+	if markup != nil {
+		params["reply_markup"] = markup
+	}
+	return b.call("sendMessage", params, nil)
+}
+
+// AnswerCallbackQuery shows a short notification after an inline button was pressed
+func (b *Bot) AnswerCallbackQuery(id, text string) error {
+	return b.call("answerCallbackQuery", map[string]interface{}{
+		"callback_query_id": id,
+		"text":              text,
+	}, nil)
+}
+
+// Poll calls handle for every update, until the bot token is rejected
+func (b *Bot) Poll(handle func(Update)) error {
+	var offset int64
+	for {
+		updates, err := b.GetUpdates(offset)
+		if err != nil {
+			var e *Error
+			if errors.As(err, &e) && (e.Code == http.StatusUnauthorized || e.Code == http.StatusNotFound) {
+				return err
+			}
+			time.Sleep(5 * time.Second)
+			continue
+		}
+
+		for _, update := range updates {
+			offset = update.UpdateID + 1
+			handle(update)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package telegram
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const testToken = "123456:secret-token"
+
+// Serves the Bot API methods in handlers, answering unknown ones with a 404 like the real server
+func newTestServer(t *testing.T, handlers map[string]func(params map[string]interface{}) (interface{}, *Error)) *httptest.Server {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, method, ok := strings.Cut(strings.TrimPrefix(r.URL.Path, "/bot"), "/")
+		handler, found := handlers[method]
+		if !ok || token != testToken {
+			found = false
+			handler = nil
+		}
+
+		var params map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&params)
+
+		var result interface{}
+		apiErr := &Error{Code: http.StatusNotFound, Description: "Not Found"}
+		if found {
+			result, apiErr = handler(params)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if apiErr != nil {
+			w.WriteHeader(apiErr.Code)
+			json.NewEncoder(w).Encode(map[string]interface{}{"ok": false, "error_code": apiErr.Code, "description": apiErr.Description})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{"ok": true, "result": result})
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func getMe(map[string]interface{}) (interface{}, *Error) {
+	return User{ID: 1, Username: "wellbinge_bot"}, nil
+}
+
+func TestNewBot(t *testing.T) {
+	server := newTestServer(t, map[string]func(map[string]interface{}) (interface{}, *Error){"getMe": getMe})
+
+	bot, err := NewBot(testToken, server.URL+"/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bot.Username != "wellbinge_bot" {
+		t.Errorf("Username = %q", bot.Username)
+	}
+
+	_, err = NewBot("654321:wrong", server.URL)
+	var e *Error
+	if !errors.As(err, &e) || e.Code != http.StatusNotFound || e.Method != "getMe" {
+		t.Errorf("NewBot with a wrong token = %v", err)
+	}
+}
+
+func TestRequestErrorsHideToken(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	server.Close()
+
+	_, err := NewBot(testToken, server.URL)
+	if err == nil {
+		t.Fatal("NewBot succeeded without a server")
+	}
+	if strings.Contains(err.Error(), "secret-token") {
+		t.Errorf("error leaks the token: %v", err)
+	}
+}
+
+func TestPollOffset(t *testing.T) {
+	var offsets []float64
+	server := newTestServer(t, map[string]func(map[string]interface{}) (interface{}, *Error){
+		"getMe": getMe,
+		"getUpdates": func(params map[string]interface{}) (interface{}, *Error) {
+			offsets = append(offsets, params["offset"].(float64))
+			switch len(offsets) {
+			case 1:
+				return []Update{
+					{UpdateID: 5, Message: &Message{Chat: Chat{ID: 7, Type: ChatPrivate}, Text: "/list"}},
+					{UpdateID: 6, CallbackQuery: &CallbackQuery{ID: "q", From: User{ID: 7}, Data: "ack:1"}},
+				}, nil
+			case 2:
+				return []Update{}, nil
+			case 3:
+				return []Update{{UpdateID: 9}}, nil
+			}
+			// the token was revoked
+			return nil, &Error{Code: http.StatusUnauthorized, Description: "Unauthorized"}
+		},
+	})
+
+	bot, err := NewBot(testToken, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var handled []int64
+	err = bot.Poll(func(update Update) {
+		handled = append(handled, update.UpdateID)
+	})
+
+	var e *Error
+	if !errors.As(err, &e) || e.Code != http.StatusUnauthorized {
+		t.Errorf("Poll = %v, want the Unauthorized error", err)
+	}
+
+	want := []float64{0, 7, 7, 10}
+	if len(offsets) != len(want) {
+		t.Fatalf("offsets %v, want %v", offsets, want)
+	}
+	for i := range want {
+		if offsets[i] != want[i] {
+			t.Errorf("offsets %v, want %v", offsets, want)
+			break
+		}
+	}
+	if len(handled) != 3 || handled[0] != 5 || handled[1] != 6 || handled[2] != 9 {
+		t.Errorf("handled updates %v", handled)
+	}
+}
+
+func TestSendMessage(t *testing.T) {
+	var sent map[string]interface{}
+	server := newTestServer(t, map[string]func(map[string]interface{}) (interface{}, *Error){
+		"getMe": getMe,
+		"sendMessage": func(params map[string]interface{}) (interface{}, *Error) {
+			sent = params
+			if params["chat_id"].(float64) == 0 {
+				return nil, &Error{Code: http.StatusBadRequest, Description: "Bad Request: chat not found"}
+			}
+			return Message{MessageID: 1}, nil
+		},
+	})
+
+	bot, err := NewBot(testToken, server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	markup := &InlineKeyboardMarkup{InlineKeyboard: [][]InlineKeyboardButton{{{Text: "Ack", CallbackData: "ack:1"}}}}
+	err = bot.SendMessage(42, "Hi", markup)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyboard, _ := json.Marshal(sent["reply_markup"])
+	if sent["text"] != "Hi" || string(keyboard) != `{"inline_keyboard":[[{"callback_data":"ack:1","text":"Ack"}]]}` {
+		t.Errorf("sent %v", sent)
+	}
+
+	err = bot.SendMessage(0, "Hi", nil)
+	var e *Error
+	if !errors.As(err, &e) || e.Method != "sendMessage" || e.Description != "Bad Request: chat not found" {
+		t.Errorf("SendMessage to a missing chat = %v", err)
+	}
+}
@@ -0,0 +1,108 @@
+// Offline ack queue and the habits shown by the offline shell, shared by the pages and the service worker.
+const ACK_DB = "wellbinge";
+const ACK_STORE = "acks";
+const HABIT_STORE = "habits";
+
+// POST requests need the CSRF token of the session: pages have it in a meta tag,
+// the service worker and the offline shell, which is shared by every session, ask the server for it.
+async function csrfToken() {
+  const meta = self.document && document.querySelector('meta[name="csrf-token"]');
+  if (meta && meta.content) {
+    return meta.content;
+  }
+
+  const res = await fetch("/csrf", { credentials: "same-origin" });
+  if (!res.ok) {
+    throw new Error("could not get CSRF token: " + res.status);
+  }
+  return (await res.json()).token;
+}
+
+function openAckDB() {
+  return new Promise((resolve, reject) => {
+    const req = indexedDB.open(ACK_DB, 2);
+    req.onupgradeneeded = () => {
+      const stores = req.result.objectStoreNames;
+      if (!stores.contains(ACK_STORE)) {
+        req.result.createObjectStore(ACK_STORE, { keyPath: "key" });
+      }
+      if (!stores.contains(HABIT_STORE)) {
+        req.result.createObjectStore(HABIT_STORE, { keyPath: "ID" });
+      }
+    };
+    req.onsuccess = () => resolve(req.result);
+    req.onerror = () => reject(req.error);
+  });
+}
+
+async function objectStore(name, mode, fn) {
+  const db = await openAckDB();
+  return new Promise((resolve, reject) => {
+    const tx = db.transaction(name, mode);
+    const result = fn(tx.objectStore(name));
+    tx.oncomplete = () => resolve(result && result.result);
+    tx.onerror = () => reject(tx.error);
+  });
+}
+
+function ackStore(mode, fn) {
+  return objectStore(ACK_STORE, mode, fn);
+}
+
+function newAckKey() {
+  if (self.crypto && crypto.randomUUID) {
+    return crypto.randomUUID();
+  }
+  return Array.from(crypto.getRandomValues(new Uint8Array(16)), (b) => b.toString(16).padStart(2, "0")).join("");
+}
+
+function queueAck(habitID) {
+  const ack = { key: newAckKey(), habit_id: Number(habitID), acked_at: new Date().toISOString() };
+  return ackStore("readwrite", (store) => store.put(ack));
+}
+
+function pendingAcks() {
+  return ackStore("readonly", (store) => store.getAll());
+}
+
+// Replaces the habits kept for the offline shell with the ones of the page
+function saveHabits(habits) {
+  return objectStore(HABIT_STORE, "readwrite", (store) => {
+    store.clear();
+    habits.forEach((habit) => store.put(habit));
+  });
+}
+
+function savedHabits() {
+  return objectStore(HABIT_STORE, "readonly", (store) => store.getAll());
+}
+
+// Drops everything the user left on this device, on logout
+async function forgetDevice() {
+  await objectStore(HABIT_STORE, "readwrite", (store) => store.clear());
+  await ackStore("readwrite", (store) => store.clear());
+}
+
+// Sends every queued ack to the server and forgets the ones it resolved.
+// Returns the per-ack results, or throws if the server could not be reached.
+async function flushAcks() {
+  const acks = await pendingAcks();
+  if (!acks.length) {
+    return [];
+  }
+
+  const res = await fetch("/sync", {
+    method: "POST",
+    credentials: "same-origin",
+    headers: { "Content-Type": "application/json", "X-CSRF-Token": await csrfToken() },
+    body: JSON.stringify({ acks }),
+  });
+  if (!res.ok) {
+    throw new Error("sync failed: " + res.status);
+  }
+
+  const { results } = await res.json();
+  const done = results.filter((r) => r.status !== "error").map((r) => r.key);
+  await ackStore("readwrite", (store) => done.forEach((key) => store.delete(key)));
+  return results;
+}
@@ -0,0 +1,116 @@
+async function showPendingAcks() {
+  const status = document.getElementById("sync-status");
+  if (!status) {
+    return;
+  }
+
+  // the messages come translated from the page, in the forms for one and other counts
+  const acks = await pendingAcks();
+  const form = new Intl.PluralRules(document.documentElement.lang).select(acks.length);
+  const message = form === "one" ? status.dataset.one : status.dataset.other;
+  status.textContent = acks.length ? message.replace("%d", acks.length) : "";
+}
+
+async function syncAcks() {
+  try {
+    const results = await flushAcks();
+    if (results.length && document.querySelector("form[data-ack]")) {
+      location.reload();
+      return;
+    }
+  } catch (e) {
+    // offline, try again later
+  }
+  showPendingAcks();
+}
+
+async function submitAck(event) {
+  event.preventDefault();
+  await queueAck(event.target.dataset.ack);
+
+  if ("serviceWorker" in navigator && "SyncManager" in window) {
+    const registration = await navigator.serviceWorker.ready;
+    registration.sync.register("sync-acks").catch(() => {});
+  }
+
+  await syncAcks();
+}
+
+// The habits page keeps a copy of the habits for the offline shell
+async function keepHabits() {
+  const snapshot = document.getElementById("habits-snapshot");
+  if (!snapshot) {
+    return;
+  }
+
+  const { positive, negative } = JSON.parse(snapshot.textContent);
+  const habits = (positive || []).map((h) => ({ ...h, Negative: false }))
+    .concat((negative || []).map((h) => ({ ...h, Negative: true })));
+  await saveHabits(habits);
+}
+
+// The offline shell lists the kept habits, so they can be acked without a connection
+async function showOfflineHabits() {
+  const shell = document.getElementById("offline-habits");
+  if (!shell) {
+    return;
+  }
+
+  const habits = await savedHabits();
+  if (!habits.length) {
+    document.getElementById("offline-empty").hidden = false;
+    return;
+  }
+
+  const lang = document.documentElement.lang;
+  const relative = new Intl.RelativeTimeFormat(lang, { numeric: "auto" });
+  for (const habit of habits) {
+    const row = shell.querySelector(`tbody[data-kind="${habit.Negative ? "negative" : "positive"}"]`).insertRow();
+    row.className = habit.Class;
+    row.insertCell().textContent = habit.Name;
+
+    const last = document.createElement("i");
+    last.textContent = habit.LastAck ? relative.format(-Math.floor((Date.now() - new Date(habit.LastAck)) / 86400000), "day") : "-";
+    row.insertCell().append(last);
+
+    const actions = row.insertCell();
+    actions.className = "actions";
+    if (habit.Negative || !habit.Disabled) {
+      const form = document.createElement("form");
+      form.dataset.ack = habit.ID;
+      const button = document.createElement("input");
+      button.type = "submit";
+      button.value = shell.dataset.ack;
+      form.append(button);
+      form.addEventListener("submit", submitAck);
+      actions.append(form);
+    }
+  }
+  shell.hidden = false;
+}
+
+// Nothing of the user stays on the device after logout: queued acks are sent first
+async function logout(event) {
+  event.preventDefault();
+  try {
+    await flushAcks();
+  } catch (e) {
+    // offline, the logout will fail as well
+  }
+  await forgetDevice();
+  event.target.submit();
+}
+
+if ("serviceWorker" in navigator) {
+  navigator.serviceWorker.register("/sw.js");
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  document.querySelectorAll("form[data-ack]").forEach((form) => form.addEventListener("submit", submitAck));
+  document.querySelectorAll('form[action="/logout"]').forEach((form) => form.addEventListener("submit", logout));
+  keepHabits();
+  showOfflineHabits();
+  syncAcks();
+});
+
+window.addEventListener("online", syncAcks);
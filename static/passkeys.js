@@ -0,0 +1,95 @@
+function fromBase64URL(s) {
+  const padding = "=".repeat((4 - (s.length % 4)) % 4);
+  const raw = atob((s + padding).replace(/-/g, "+").replace(/_/g, "/"));
+  return Uint8Array.from(raw, (c) => c.charCodeAt(0)).buffer;
+}
+
+function toBase64URL(buffer) {
+  const bytes = new Uint8Array(buffer);
+  let s = "";
+  bytes.forEach((b) => (s += String.fromCharCode(b)));
+  return btoa(s).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+async function postJSON(url, body) {
+  const res = await fetch(url, {
+    method: "POST",
+    credentials: "same-origin",
+    headers: { "Content-Type": "application/json", "X-CSRF-Token": await csrfToken() },
+    body: body === undefined ? undefined : JSON.stringify(body),
+  });
+  if (!res.ok) {
+    throw new Error((await res.text()).trim());
+  }
+  return res.json();
+}
+
+async function registerPasskey(name) {
+  const { publicKey } = await postJSON("/passkeys/register/begin");
+  publicKey.challenge = fromBase64URL(publicKey.challenge);
+  publicKey.user.id = fromBase64URL(publicKey.user.id);
+  (publicKey.excludeCredentials || []).forEach((c) => (c.id = fromBase64URL(c.id)));
+
+  const credential = await navigator.credentials.create({ publicKey });
+  const { redirect } = await postJSON("/passkeys/register/finish?name=" + encodeURIComponent(name), {
+    id: credential.id,
+    rawId: toBase64URL(credential.rawId),
+    type: credential.type,
+    response: {
+      attestationObject: toBase64URL(credential.response.attestationObject),
+      clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+      transports: credential.response.getTransports ? credential.response.getTransports() : [],
+    },
+    clientExtensionResults: credential.getClientExtensionResults(),
+  });
+  location.href = redirect;
+}
+
+async function loginWithPasskey(remember) {
+  const { publicKey } = await postJSON("/passkeys/login/begin");
+  publicKey.challenge = fromBase64URL(publicKey.challenge);
+  (publicKey.allowCredentials || []).forEach((c) => (c.id = fromBase64URL(c.id)));
+
+  const credential = await navigator.credentials.get({ publicKey });
+  const { redirect } = await postJSON("/passkeys/login/finish" + (remember ? "?remember=on" : ""), {
+    id: credential.id,
+    rawId: toBase64URL(credential.rawId),
+    type: credential.type,
+    response: {
+      authenticatorData: toBase64URL(credential.response.authenticatorData),
+      clientDataJSON: toBase64URL(credential.response.clientDataJSON),
+      signature: toBase64URL(credential.response.signature),
+      userHandle: credential.response.userHandle ? toBase64URL(credential.response.userHandle) : null,
+    },
+    clientExtensionResults: credential.getClientExtensionResults(),
+  });
+  location.href = redirect;
+}
+
+function passkeyError(e) {
+  const status = document.getElementById("passkey-status");
+  if (status) {
+    status.textContent = e.message;
+  }
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  const supported = !!window.PublicKeyCredential;
+  document.querySelectorAll("[data-passkey]").forEach((el) => (el.hidden = !supported));
+
+  const register = document.getElementById("passkey-register");
+  if (register) {
+    register.addEventListener("submit", (event) => {
+      event.preventDefault();
+      registerPasskey(register.elements.name.value).catch(passkeyError);
+    });
+  }
+
+  const login = document.getElementById("passkey-login");
+  if (login) {
+    login.addEventListener("click", () => {
+      const remember = document.querySelector("input[name=remember]");
+      loginWithPasskey(remember && remember.checked).catch(passkeyError);
+    });
+  }
+});
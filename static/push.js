@@ -0,0 +1,54 @@
+function urlBase64ToUint8Array(s) {
+  const padding = "=".repeat((4 - (s.length % 4)) % 4);
+  const raw = atob((s + padding).replace(/-/g, "+").replace(/_/g, "/"));
+  return Uint8Array.from(raw, (c) => c.charCodeAt(0));
+}
+
+async function enablePush(button) {
+  const status = document.getElementById("push-status");
+
+  if (!("serviceWorker" in navigator) || !("PushManager" in window)) {
+    status.textContent = status.dataset.unsupported;
+    return;
+  }
+
+  button.disabled = true;
+  try {
+    const permission = await Notification.requestPermission();
+    if (permission !== "granted") {
+      status.textContent = status.dataset.denied;
+      return;
+    }
+
+    const registration = await navigator.serviceWorker.register("/sw.js");
+    await navigator.serviceWorker.ready;
+
+    const subscription = await registration.pushManager.subscribe({
+      userVisibleOnly: true,
+      applicationServerKey: urlBase64ToUint8Array(button.dataset.key),
+    });
+
+    const res = await fetch("/push/subscribe", {
+      method: "POST",
+      credentials: "same-origin",
+      headers: { "Content-Type": "application/json", "X-CSRF-Token": await csrfToken() },
+      body: JSON.stringify(subscription),
+    });
+    if (!res.ok) {
+      throw new Error(await res.text());
+    }
+
+    location.reload();
+  } catch (e) {
+    status.textContent = status.dataset.failed + " " + e.message;
+  } finally {
+    button.disabled = false;
+  }
+}
+
+document.addEventListener("DOMContentLoaded", () => {
+  const button = document.getElementById("push-enable");
+  if (button) {
+    button.addEventListener("click", () => enablePush(button));
+  }
+});
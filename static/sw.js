@@ -0,0 +1,126 @@
+importScripts("/static/ackqueue.js");
+
+const CACHE = "wellbinge-v4";
+const OFFLINE = "/offline";
+const SHELL = [
+  "/static/style.css",
+  "/static/app.js",
+  "/static/ackqueue.js",
+  "/static/favicon/favicon.svg",
+  "/static/favicon/favicon-48x48.png",
+  "/static/favicon/web-app-manifest-192x192.png",
+  "/static/favicon/site.webmanifest",
+];
+const CDN = ["https://cdn.simplecss.org/simple.min.css"];
+
+self.addEventListener("install", (event) => {
+  event.waitUntil(
+    caches.open(CACHE).then(async (cache) => {
+      await cache.addAll(SHELL);
+      // without cookies, so it carries nothing of the user
+      await cache.add(new Request(OFFLINE, { credentials: "omit" }));
+      for (const url of CDN) {
+        const res = await fetch(new Request(url, { mode: "no-cors" }));
+        await cache.put(url, res);
+      }
+    }).then(() => self.skipWaiting())
+  );
+});
+
+self.addEventListener("activate", (event) => {
+  event.waitUntil(
+    caches.keys()
+      .then((keys) => Promise.all(keys.filter((k) => k !== CACHE).map((k) => caches.delete(k))))
+      .then(() => self.clients.claim())
+  );
+});
+
+// Pages hold the user's data, so they are never cached: offline, the shell at /offline is shown,
+// which lists the habits app.js kept in IndexedDB. Static assets are stale-while-revalidate.
+self.addEventListener("fetch", (event) => {
+  const req = event.request;
+  if (req.method !== "GET") {
+    return;
+  }
+
+  if (req.mode === "navigate") {
+    event.respondWith(fetch(req).catch(async () => (await caches.match(OFFLINE)) || Response.error()));
+    return;
+  }
+
+  const url = new URL(req.url);
+
+  if (url.pathname.startsWith("/static/") || CDN.includes(req.url)) {
+    event.respondWith(
+      caches.match(req).then((cached) => {
+        const network = fetch(req)
+          .then((res) => {
+            if (res.ok || res.type === "opaque") {
+              const copy = res.clone();
+              caches.open(CACHE).then((cache) => cache.put(req, copy));
+            }
+            return res;
+          })
+          .catch(() => cached);
+        return cached || network;
+      })
+    );
+  }
+});
+
+self.addEventListener("sync", (event) => {
+  if (event.tag === "sync-acks") {
+    event.waitUntil(flushAcks());
+  }
+});
+
+self.addEventListener("push", (event) => {
+  let message = { title: "WellBinge", body: "", url: "/habits" };
+  if (event.data) {
+    try {
+      message = { ...message, ...event.data.json() };
+    } catch (e) {
+      message.body = event.data.text();
+    }
+  }
+
+  event.waitUntil(
+    self.registration.showNotification(message.title, {
+      body: message.body,
+      icon: "/static/favicon/web-app-manifest-192x192.png",
+      badge: "/static/favicon/favicon-48x48.png",
+      data: { url: message.url },
+    })
+  );
+});
+
+self.addEventListener("notificationclick", (event) => {
+  event.notification.close();
+  const url = (event.notification.data && event.notification.data.url) || "/habits";
+
+  event.waitUntil(
+    clients.matchAll({ type: "window", includeUncontrolled: true }).then((windows) => {
+      for (const w of windows) {
+        if (w.url === new URL(url, self.location.origin).href && "focus" in w) {
+          return w.focus();
+        }
+      }
+      return clients.openWindow(url);
+    })
+  );
+});
+
+self.addEventListener("pushsubscriptionchange", (event) => {
+  event.waitUntil(
+    self.registration.pushManager
+      .subscribe(event.oldSubscription.options)
+      .then(async (subscription) =>
+        fetch("/push/subscribe", {
+          method: "POST",
+          credentials: "same-origin",
+          headers: { "Content-Type": "application/json", "X-CSRF-Token": await csrfToken() },
+          body: JSON.stringify(subscription),
+        })
+      )
+  );
+});